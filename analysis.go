@@ -0,0 +1,183 @@
+package formula
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CollectVariables возвращает имена всех переменных, на которые ссылается формула.
+// Имена уникальны и отсортированы по возрастанию, порядок не зависит от
+// порядка появления переменных в формуле. Встроенные константы E и PI
+// переменными не считаются.
+func CollectVariables(node ASTNode) []string {
+	names := make(map[string]bool)
+	collectNames(node, func(n ASTNode) {
+		if v, ok := n.(*VariableNode); ok {
+			if _, constant := constants[v.Name]; constant {
+				return
+			}
+			names[v.Name] = true
+		}
+	})
+	return sortedKeys(names)
+}
+
+// CollectFunctions возвращает имена всех функций, вызываемых в формуле.
+// Имена уникальны и отсортированы по возрастанию.
+func CollectFunctions(node ASTNode) []string {
+	names := make(map[string]bool)
+	collectNames(node, func(n ASTNode) {
+		if f, ok := n.(*FunctionNode); ok {
+			names[f.Name] = true
+		}
+	})
+	return sortedKeys(names)
+}
+
+// CollectUnresolvedFunctions возвращает отсортированные имена функций, которые
+// вызываются в формуле, но недоступны в контексте. Позволяет сообщить обо всех
+// отсутствующих функциях сразу, не прерываясь на первой ошибке вычисления.
+func CollectUnresolvedFunctions(node ASTNode, ctx *Context) []string {
+	var unresolved []string
+	for _, name := range CollectFunctions(node) {
+		if _, exists := ctx.lookupFunction(name); !exists {
+			unresolved = append(unresolved, name)
+		}
+	}
+	return unresolved
+}
+
+// CheckVariables возвращает отсортированные имена переменных, на которые
+// ссылается формула, но которые отсутствуют в контексте. Формула не
+// вычисляется: проверяется только наличие, поэтому сообщаются все
+// отсутствующие переменные, а не только первая.
+func CheckVariables(node ASTNode, ctx *Context) []string {
+	var missing []string
+	for _, name := range CollectVariables(node) {
+		if _, exists, err := ctx.lookupVariable(name); !exists || err != nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// Skeleton возвращает структурную сигнатуру формулы: все литералы заменяются
+// на N, все переменные на V, а операторы, функции и расстановка скобок
+// сохраняются. Формулы "a + 2*b" и "x + 3*y" имеют одинаковую сигнатуру
+// "(V + (N * V))", а "a + b" - другую. Ключевые слова приводятся к английским,
+// поэтому "ЕСЛИ" и "IF" не различаются.
+func Skeleton(node ASTNode) string {
+	switch n := node.(type) {
+	case nil:
+		return ""
+	case *LiteralNode:
+		return "N"
+	case *VariableNode:
+		return "V"
+	case *OperationNode:
+		return "(" + Skeleton(n.Left) + " " + n.Operator + " " + Skeleton(n.Right) + ")"
+	case *ComparisonNode:
+		return "(" + Skeleton(n.Left) + " " + n.Operator + " " + Skeleton(n.Right) + ")"
+	case *LogicalNode:
+		return "(" + Skeleton(n.Left) + " " + n.Operator + " " + Skeleton(n.Right) + ")"
+	case *UnaryNode:
+		switch n.Operator {
+		case "abs":
+			return "|" + Skeleton(n.Operand) + "|"
+		case "NOT":
+			return "NOT " + Skeleton(n.Operand)
+		}
+		return n.Operator + Skeleton(n.Operand)
+	case *ConditionalNode:
+		parts := []string{Skeleton(n.Condition), Skeleton(n.Then)}
+		if n.Else != nil {
+			parts = append(parts, Skeleton(n.Else))
+		}
+		return "IF(" + strings.Join(parts, ", ") + ")"
+	case *SwitchNode:
+		parts := make([]string, 0, len(children(n)))
+		for _, child := range children(n) {
+			parts = append(parts, Skeleton(child))
+		}
+		return "SWITCH(" + strings.Join(parts, ", ") + ")"
+	case *FunctionNode:
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = Skeleton(arg)
+		}
+		return strings.ToLower(n.Name) + "(" + strings.Join(args, ", ") + ")"
+	default:
+		return "?"
+	}
+}
+
+// FunctionNames возвращает отсортированные имена зарегистрированных функций
+func (c *Context) FunctionNames() []string {
+	names := make([]string, 0, len(c.Functions))
+	for name := range c.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VariableNames возвращает отсортированные имена заданных переменных
+func (c *Context) VariableNames() []string {
+	names := make([]string, 0, len(c.Variables))
+	for name := range c.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// maxDebugVariables ограничивает число переменных в DebugString
+const maxDebugVariables = 50
+
+// DebugString возвращает описание контекста для логов: отсортированные
+// переменные со значениями и имена функций, без самих функций, например
+// "variables(2): a=1, b=2; functions(1): abs". Если переменных больше
+// maxDebugVariables, выводятся первые из них и количество пропущенных.
+func (c *Context) DebugString() string {
+	if c == nil {
+		return "<nil context>"
+	}
+
+	names := c.VariableNames()
+	shown := names
+	if len(shown) > maxDebugVariables {
+		shown = shown[:maxDebugVariables]
+	}
+
+	variables := make([]string, 0, len(shown)+1)
+	for _, name := range shown {
+		variables = append(variables, name+"="+formatNumber(c.Variables[name]))
+	}
+	if skipped := len(names) - len(shown); skipped > 0 {
+		variables = append(variables, fmt.Sprintf("... and %d more", skipped))
+	}
+
+	functions := c.FunctionNames()
+	return fmt.Sprintf("variables(%d): %s; functions(%d): %s",
+		len(names), strings.Join(variables, ", "),
+		len(functions), strings.Join(functions, ", "))
+}
+
+// collectNames обходит дерево в прямом порядке и вызывает visit для каждого узла
+func collectNames(node ASTNode, visit func(ASTNode)) {
+	Walk(node, func(n ASTNode) bool {
+		visit(n)
+		return true
+	})
+}
+
+// sortedKeys возвращает ключи множества в отсортированном порядке
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
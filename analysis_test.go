@@ -0,0 +1,223 @@
+package formula
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Встроенные константы не должны попадать в списки переменных
+func TestCollectVariablesSkipsConstants(t *testing.T) {
+	node := mustParse(t, "2 ^ E + PI * r")
+	if got, want := CollectVariables(node), []string{"r"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CollectVariables = %v, want %v", got, want)
+	}
+	if got := CheckVariables(node, NewContext()); !reflect.DeepEqual(got, []string{"r"}) {
+		t.Errorf("CheckVariables = %v, want [r]", got)
+	}
+
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"r": 2}
+	_, outcome, err := EvaluateWithOutcome(node, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]float64{"r": 2}; !reflect.DeepEqual(outcome.Variables, want) {
+		t.Errorf("EvalOutcome.Variables = %v, want %v", outcome.Variables, want)
+	}
+
+	if _, err := CompileIndexed(mustParse(t, "r * PI"), []string{"r"}); err != nil {
+		t.Errorf("CompileIndexed(r * PI): %v", err)
+	}
+}
+
+func TestExponentAndConstantE(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"2e3", 2000},
+		{"2E3", 2000},
+		{"2e+3", 2000},
+		{"2E-1", 0.2},
+		{"2 ^ E", 6.5808859910179205},
+		{"2 * E", 5.43656365691809},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, nil); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+
+	for _, formula := range []string{"2E", "2e", "2E)", "(2e+)"} {
+		if _, err := NewSimpleParser().ParseString(formula); err == nil {
+			t.Errorf("%q: expected error", formula)
+		}
+	}
+}
+
+// Порядок имен не должен зависеть от порядка их появления или добавления
+func TestNamesAreSorted(t *testing.T) {
+	for _, formula := range []string{"zeta + alpha * max(mid, alpha)", "max(mid, alpha) * alpha + zeta"} {
+		node := mustParse(t, formula)
+		if got, want := CollectVariables(node), []string{"alpha", "mid", "zeta"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("CollectVariables(%s) = %v, want %v", formula, got, want)
+		}
+	}
+	if got, want := CollectFunctions(mustParse(t, "sqrt(a) + abs(max(a, b))")), []string{"abs", "max", "sqrt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CollectFunctions = %v, want %v", got, want)
+	}
+
+	ctx := &Context{Variables: map[string]float64{}, Functions: map[string]func([]float64) (float64, error){}}
+	for _, name := range []string{"c", "a", "b"} {
+		ctx.Variables[name] = 1
+		ctx.Functions[name+"fn"] = nil
+	}
+	if got, want := ctx.VariableNames(), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("VariableNames = %v, want %v", got, want)
+	}
+	if got, want := ctx.FunctionNames(), []string{"afn", "bfn", "cfn"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FunctionNames = %v, want %v", got, want)
+	}
+	if got, want := CollectUnresolvedFunctions(mustParse(t, "zz(1) + aa(2)"), ctx), []string{"aa", "zz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CollectUnresolvedFunctions = %v, want %v", got, want)
+	}
+}
+
+func TestCollectUnresolvedFunctions(t *testing.T) {
+	node := mustParse(t, "sqrt(a) + bonus(a, b) * penalty(c) + bonus(1, 2)")
+	if got, want := CollectUnresolvedFunctions(node, NewContext()), []string{"bonus", "penalty"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CollectUnresolvedFunctions = %v, want %v", got, want)
+	}
+
+	ctx := NewContext()
+	ctx.Functions["bonus"] = func(args []float64) (float64, error) { return 0, nil }
+	if got, want := CollectUnresolvedFunctions(node, ctx), []string{"penalty"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("with bonus registered = %v, want %v", got, want)
+	}
+	if got := CollectUnresolvedFunctions(mustParse(t, "SQRT(a) + Max(a, b)"), NewContext()); len(got) != 0 {
+		t.Errorf("built-in functions in another case reported as unresolved: %v", got)
+	}
+}
+
+func TestLinearCoefficients(t *testing.T) {
+	tests := []struct {
+		formula  string
+		coeffs   map[string]float64
+		constant float64
+	}{
+		{"2*a + 3*b - 5", map[string]float64{"a": 2, "b": 3}, -5},
+		{"-(a - 4) / 2", map[string]float64{"a": -0.5}, 2},
+		{"a + b - a", map[string]float64{"b": 1}, 0},
+		{"2 ^ 3", map[string]float64{}, 8},
+	}
+	for _, tt := range tests {
+		coeffs, constant, ok := LinearCoefficients(mustParse(t, tt.formula))
+		if !ok {
+			t.Errorf("LinearCoefficients(%q): ok = false, want true", tt.formula)
+			continue
+		}
+		if !reflect.DeepEqual(coeffs, tt.coeffs) || constant != tt.constant {
+			t.Errorf("LinearCoefficients(%q) = %v, %v, want %v, %v", tt.formula, coeffs, constant, tt.coeffs, tt.constant)
+		}
+	}
+
+	// Произведение переменных, деление на переменную, функции и условия
+	// линейными не считаются
+	for _, formula := range []string{"a*b", "1 / a", "a ^ 2", "sqrt(a)", "IF(a > 1, a, 0)"} {
+		if coeffs, constant, ok := LinearCoefficients(mustParse(t, formula)); ok {
+			t.Errorf("LinearCoefficients(%q) = %v, %v, true, want ok = false", formula, coeffs, constant)
+		}
+	}
+}
+
+// CheckVariables сообщает обо всех отсутствующих переменных сразу и не
+// вычисляет формулу, поэтому учитывает и невыбранные ветви IF
+func TestCheckVariablesReportsAllMissing(t *testing.T) {
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"price": 10}
+	ctx.LookupVariable = func(name string) (float64, bool) {
+		return 1, name == "rate"
+	}
+
+	node := mustParse(t, "IF(price > 0, price * rate * qty, discount + PI)")
+	if got, want := CheckVariables(node, ctx), []string{"discount", "qty"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CheckVariables = %v, want %v", got, want)
+	}
+
+	ctx.Variables["qty"] = 2
+	ctx.Variables["discount"] = 0
+	if got := CheckVariables(node, ctx); got != nil {
+		t.Errorf("CheckVariables with all variables = %v, want nil", got)
+	}
+}
+
+func TestDebugString(t *testing.T) {
+	ctx := &Context{
+		Variables: map[string]float64{"b": 2.5, "a": 1},
+		Functions: map[string]func([]float64) (float64, error){
+			"max": nil,
+			"abs": nil,
+		},
+	}
+	if got, want := ctx.DebugString(), "variables(2): a=1, b=2.5; functions(2): abs, max"; got != want {
+		t.Errorf("DebugString = %q, want %q", got, want)
+	}
+
+	// Большие карты переменных обрезаются
+	ctx = &Context{Variables: make(map[string]float64)}
+	for i := 0; i < maxDebugVariables+3; i++ {
+		ctx.Variables[fmt.Sprintf("v%03d", i)] = float64(i)
+	}
+	got := ctx.DebugString()
+	if !strings.HasPrefix(got, fmt.Sprintf("variables(%d): v000=0, v001=1,", maxDebugVariables+3)) {
+		t.Errorf("DebugString = %q, want sorted variables first", got)
+	}
+	if !strings.Contains(got, "v049=49, ... and 3 more; functions(0): ") || strings.Contains(got, "v050") {
+		t.Errorf("DebugString = %q, want the list capped at %d variables", got, maxDebugVariables)
+	}
+
+	var nilCtx *Context
+	if got := nilCtx.DebugString(); got != "<nil context>" {
+		t.Errorf("nil DebugString = %q", got)
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	same := Skeleton(mustParse(t, "a + 2*b"))
+	if got := Skeleton(mustParse(t, "x + 3*y")); got != same {
+		t.Errorf("Skeleton(x + 3*y) = %q, want %q as for a + 2*b", got, same)
+	}
+	if same != "(V + (N * V))" {
+		t.Errorf("Skeleton(a + 2*b) = %q, want %q", same, "(V + (N * V))")
+	}
+	for _, formula := range []string{"a + b", "(a + 2) * b", "a - 2*b", "a + max(2, b)"} {
+		if got := Skeleton(mustParse(t, formula)); got == same {
+			t.Errorf("Skeleton(%q) = %q, should differ from a + 2*b", formula, got)
+		}
+	}
+
+	// Язык ключевых слов не влияет на сигнатуру
+	if en, ru := Skeleton(mustParse(t, "IF(a > 1, 2, b)")), Skeleton(mustParse(t, "ЕСЛИ x > 5 ТОГДА 7 ИНАЧЕ y")); en != ru {
+		t.Errorf("Skeleton of IF = %q, of ЕСЛИ = %q, want equal", en, ru)
+	}
+}
+
+func TestCollectVariables(t *testing.T) {
+	if got, want := CollectVariables(mustParse(t, gradeFormula)), []string{"score"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CollectVariables(grade formula) = %v, want %v", got, want)
+	}
+
+	// Обходятся все виды узлов: операции, сравнения, логика, условия,
+	// унарные операции и аргументы функций
+	node := mustParse(t, "IF(a > b AND NOT c, -d + |e|, max(f, g * 2)) + IF(h, i)")
+	want := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}
+	if got := CollectVariables(node); !reflect.DeepEqual(got, want) {
+		t.Errorf("CollectVariables = %v, want %v", got, want)
+	}
+
+	if got := CollectVariables(mustParse(t, "2 * max(1, 3)")); len(got) != 0 {
+		t.Errorf("CollectVariables without variables = %v, want empty", got)
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // NodeType определяет тип узла AST
@@ -11,6 +15,12 @@ type NodeType string
 
 var (
 	ErrNotFound = errors.New("entity not found")
+
+	// ErrMissingElse возвращается строгим условием без ветки ELSE, если условие ложно
+	ErrMissingElse = errors.New("missing else branch")
+
+	// ErrFunctionExists возвращается RegisterFunction, если имя уже занято
+	ErrFunctionExists = errors.New("function already registered")
 )
 
 const (
@@ -22,6 +32,7 @@ const (
 	NodeTypeFunction    NodeType = "function"
 	NodeTypeLogical     NodeType = "logical"
 	NodeTypeUnary       NodeType = "unary"
+	NodeTypeSwitch      NodeType = "switch"
 )
 
 // ASTNode базовый интерфейс для всех узлов AST
@@ -34,6 +45,382 @@ type ASTNode interface {
 type Context struct {
 	Variables map[string]float64
 	Functions map[string]func([]float64) (float64, error)
+
+	// NonDeterministic отмечает функции, результат которых может меняться
+	// между вызовами с одинаковыми аргументами (RAND, NOW и т.п.).
+	// Поддеревья с такими функциями не кэшируются и не сворачиваются.
+	NonDeterministic map[string]bool
+
+	// ModMode задает семантику оператора % для отрицательных операндов
+	ModMode ModMode
+
+	// MaxExponent ограничивает модуль показателя степени для формул из
+	// недоверенных источников. 0 означает отсутствие ограничения.
+	MaxExponent float64
+
+	// FixedScale задает число знаков после запятой, до которого округляется
+	// результат каждой арифметической операции (а не только итоговый результат),
+	// как в десятичной арифметике с фиксированной точкой для денежных сумм.
+	// nil означает вычисление с полной точностью.
+	FixedScale *int
+
+	// AccountingScale включает бухгалтерское округление: операнды и результат
+	// сложения и вычитания округляются до AccountingScale знаков, а умножение
+	// и деление выполняются с полной точностью до ближайшего сложения. Так
+	// каждая строка суммы округляется до копеек до суммирования. Если задан
+	// FixedScale, AccountingScale без ошибки игнорируется и каждая операция
+	// округляется до FixedScale знаков. nil означает вычисление с полной
+	// точностью.
+	AccountingScale *int
+
+	// AngleMode задает единицы углов для тригонометрических функций.
+	// Функции из NewContext читают режим того контекста, которым были созданы.
+	AngleMode AngleMode
+
+	// Rand - источник случайных чисел для rand и randbetween, например
+	// rand.New(rand.NewSource(42)) для воспроизводимых расчетов.
+	// nil означает общий источник пакета math/rand.
+	Rand *rand.Rand
+
+	// MaxDepth ограничивает глубину дерева формулы: вычисление более глубоких
+	// деревьев (например, из недоверенного JSON) завершается ErrMaxDepth, а не
+	// переполнением стека. Глубина всего дерева, включая невыбранные ветки
+	// условий, проверяется до вычисления, поэтому node.Evaluate, Evaluate,
+	// EvaluateWithTrace, Compile, Program.Run и IndexedFormula отвергают одни и
+	// те же формулы. 0 означает отсутствие ограничения.
+	MaxDepth int
+
+	// RequireNumericResult запрещает формулы, результат которых - логическое
+	// значение (сравнение, AND/OR), там, где ожидается число. Проверяется
+	// функцией Evaluate до вычисления.
+	RequireNumericResult bool
+
+	// Resolver вычисляет переменные, которых нет в Variables. nil - только Variables.
+	Resolver VariableResolver
+
+	// LookupVariable - пользовательский поиск переменной, проверяется раньше
+	// Variables. Если функция вернула false, поиск продолжается в Variables.
+	LookupVariable func(name string) (float64, bool)
+
+	// CaseInsensitive включает поиск в Variables без учета регистра:
+	// "Salary" находит значение "salary". Точное совпадение имеет приоритет.
+	CaseInsensitive bool
+
+	// MissingVarPolicy задает поведение при отсутствующей переменной.
+	// По умолчанию (ErrorOnMissing) вычисление завершается ошибкой.
+	MissingVarPolicy MissingVarPolicy
+
+	// DefaultVariable - значение отсутствующих переменных при политике DefaultOnMissing
+	DefaultVariable float64
+
+	// Fuzzy включает нечеткий режим: сравнения, AND, OR и NOT возвращают степень
+	// истинности из [0, 1]. nil означает обычные значения 0 и 1.
+	Fuzzy *FuzzyConfig
+
+	// LenientComparisons включает семантику фильтров: сравнение, в операндах
+	// которого есть отсутствующая переменная, ложно (0), а не завершается
+	// ошибкой. Отсутствующая переменная вне сравнений по-прежнему дает ошибку.
+	LenientComparisons bool
+
+	// Ranges собирает наблюдаемые диапазоны значений переменных и поддеревьев
+	// при вычислении функцией Evaluate. nil - диапазоны не собираются.
+	Ranges *RangeCollector
+
+	// trace заполняется при вычислении через EvaluateWithTrace
+	trace *Trace
+
+	// cancel задается при вычислении через EvaluateWithContext
+	cancel *cancellation
+
+	// depthChecked отмечает копию контекста, с которой вычисляется дерево,
+	// уже проверенное на MaxDepth (см. enter)
+	depthChecked bool
+}
+
+// ModMode определяет, как вычисляется остаток от деления
+type ModMode int
+
+const (
+	// ModTruncated - знак результата совпадает со знаком делимого (как math.Mod):
+	// -7 % 3 = -1, 7 % -3 = 1
+	ModTruncated ModMode = iota
+	// ModFloored - знак результата совпадает со знаком делителя:
+	// -7 % 3 = 2, 7 % -3 = -2
+	ModFloored
+	// ModEuclidean - результат всегда неотрицателен:
+	// -7 % 3 = 2, 7 % -3 = 1
+	ModEuclidean
+)
+
+// MissingVarPolicy определяет, что делать с переменной, которой нет в контексте
+type MissingVarPolicy int
+
+const (
+	// ErrorOnMissing - вернуть ошибку, оборачивающую ErrNotFound
+	ErrorOnMissing MissingVarPolicy = iota
+	// ZeroOnMissing - считать значение переменной равным 0
+	ZeroOnMissing
+	// DefaultOnMissing - использовать значение Context.DefaultVariable
+	DefaultOnMissing
+)
+
+// AngleMode определяет, в каких единицах тригонометрические функции
+// принимают и возвращают углы
+type AngleMode int
+
+const (
+	// AngleRadians - углы в радианах (по умолчанию): sin(pi/2) = 1
+	AngleRadians AngleMode = iota
+	// AngleDegrees - углы в градусах: sin(90) = 1
+	AngleDegrees
+)
+
+// VariableResolver вычисляет значения переменных, отсутствующих в Context.Variables,
+// например из внешнего документа. Для неизвестной переменной Resolve должен
+// вернуть ошибку, оборачивающую ErrNotFound.
+type VariableResolver interface {
+	Resolve(name string) (float64, error)
+}
+
+// lookupVariable ищет значение переменной по имени: сначала через LookupVariable,
+// затем среди значений контекста, через Resolver и среди констант E и PI.
+// Ошибка возвращается, только если Resolver нашел переменную, но не смог
+// получить ее значение.
+func (c *Context) lookupVariable(name string) (float64, bool, error) {
+	if c == nil {
+		return 0, false, nil
+	}
+	if c.LookupVariable != nil {
+		if value, exists := c.LookupVariable(name); exists {
+			return value, true, nil
+		}
+	}
+	if value, exists := c.Variables[name]; exists {
+		return value, true, nil
+	}
+	if c.CaseInsensitive {
+		for key, value := range c.Variables {
+			if strings.EqualFold(key, name) {
+				return value, true, nil
+			}
+		}
+	}
+	if c.Resolver != nil {
+		value, err := c.Resolver.Resolve(name)
+		switch {
+		case err == nil:
+			return value, true, nil
+		case !errors.Is(err, ErrNotFound):
+			return 0, false, err
+		}
+	}
+	if value, ok := constants[name]; ok {
+		return value, true, nil
+	}
+	return 0, false, nil
+}
+
+// constants - встроенные константы. Используются, только если переменной
+// с таким именем нет в контексте, поэтому не мешают существующим данным.
+var constants = map[string]float64{
+	"E":  math.E,
+	"PI": math.Pi,
+}
+
+// variableValue возвращает значение переменной; для отсутствующей переменной
+// действует MissingVarPolicy, по умолчанию возвращается ошибка
+func (c *Context) variableValue(name string) (float64, error) {
+	value, exists, err := c.lookupVariable(name)
+	if err != nil {
+		return 0, fmt.Errorf("variable '%s': %w", name, err)
+	}
+	if !exists {
+		if c != nil {
+			switch c.MissingVarPolicy {
+			case ZeroOnMissing:
+				return 0, nil
+			case DefaultOnMissing:
+				return c.DefaultVariable, nil
+			}
+		}
+		return 0, missingVariableError{name: name}
+	}
+	return value, nil
+}
+
+// missingVariableError - ошибка отсутствующей переменной. Отличается от
+// отсутствующей функции, хотя обе сводятся к ErrNotFound.
+type missingVariableError struct {
+	name string
+}
+
+func (e missingVariableError) Error() string {
+	return fmt.Sprintf("variable '%s' not found %v", e.name, ErrNotFound)
+}
+
+func (e missingVariableError) Unwrap() error {
+	return ErrNotFound
+}
+
+// isMissingVariable сообщает, вызвана ли ошибка отсутствующей переменной
+func isMissingVariable(err error) bool {
+	var missing missingVariableError
+	return errors.As(err, &missing)
+}
+
+// lenientComparison сообщает, нужно ли считать сравнение ложным вместо ошибки err
+func (c *Context) lenientComparison(err error) bool {
+	return c != nil && c.LenientComparisons && isMissingVariable(err)
+}
+
+// lookupFunction ищет зарегистрированную функцию по имени без учета регистра:
+// SUM, Sqrt и sqrt находят одну функцию. Точное совпадение имеет приоритет.
+func (c *Context) lookupFunction(name string) (func([]float64) (float64, error), bool) {
+	if c == nil {
+		return nil, false
+	}
+	if fn, exists := c.Functions[name]; exists {
+		return fn, true
+	}
+	// Функции NewContext зарегистрированы в нижнем регистре
+	if fn, exists := c.Functions[strings.ToLower(name)]; exists {
+		return fn, true
+	}
+	for key, fn := range c.Functions {
+		if strings.EqualFold(key, name) {
+			return fn, true
+		}
+	}
+	return nil, false
+}
+
+// isNonDeterministic сообщает, помечена ли функция как недетерминированная,
+// без учета регистра имени
+func (c *Context) isNonDeterministic(name string) bool {
+	if c == nil || len(c.NonDeterministic) == 0 {
+		return false
+	}
+	if c.NonDeterministic[name] || c.NonDeterministic[strings.ToLower(name)] {
+		return true
+	}
+	for key, marked := range c.NonDeterministic {
+		if marked && strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// functionByName возвращает функцию для вызова или ошибку, оборачивающую ErrNotFound.
+// Для контекста без функций (например, &Context{} без NewContext) ошибка
+// сообщает, что функции не зарегистрированы вовсе.
+func (c *Context) functionByName(name string) (func([]float64) (float64, error), error) {
+	if c == nil || c.Functions == nil {
+		return nil, fmt.Errorf("function '%s' not found: no functions registered in context (use NewContext or RegisterFunction) %w", name, ErrNotFound)
+	}
+	fn, exists := c.lookupFunction(name)
+	if !exists {
+		return nil, fmt.Errorf("function '%s' not found %w", name, ErrNotFound)
+	}
+	return fn, nil
+}
+
+// HasFunction сообщает, зарегистрирована ли функция
+func (c *Context) HasFunction(name string) bool {
+	_, exists := c.lookupFunction(name)
+	return exists
+}
+
+// RegisterFunction добавляет функцию в контекст. Если функция с таким именем
+// уже есть, возвращается ошибка ErrFunctionExists; для замены используйте
+// SetFunction. Работает и для контекста, созданного без NewContext.
+func (c *Context) RegisterFunction(name string, fn func([]float64) (float64, error)) error {
+	if fn == nil {
+		return fmt.Errorf("function '%s' is nil", name)
+	}
+	if c.HasFunction(name) {
+		return fmt.Errorf("%w: %s", ErrFunctionExists, name)
+	}
+	c.SetFunction(name, fn)
+	return nil
+}
+
+// RegisterFunctions добавляет набор функций. Если хотя бы одно имя уже занято,
+// ни одна функция не добавляется.
+func (c *Context) RegisterFunctions(fns map[string]func([]float64) (float64, error)) error {
+	names := make([]string, 0, len(fns))
+	for name := range fns {
+		names = append(names, name)
+	}
+	sort.Strings(names) // ошибка не зависит от порядка обхода карты
+
+	for _, name := range names {
+		if fns[name] == nil {
+			return fmt.Errorf("function '%s' is nil", name)
+		}
+		if c.HasFunction(name) {
+			return fmt.Errorf("%w: %s", ErrFunctionExists, name)
+		}
+	}
+	for name, fn := range fns {
+		c.SetFunction(name, fn)
+	}
+	return nil
+}
+
+// SetFunction добавляет функцию или заменяет уже зарегистрированную
+func (c *Context) SetFunction(name string, fn func([]float64) (float64, error)) {
+	if c.Functions == nil {
+		c.Functions = make(map[string]func([]float64) (float64, error))
+	}
+	c.Functions[name] = fn
+}
+
+// MarkNonDeterministic помечает функцию как недетерминированную
+func (c *Context) MarkNonDeterministic(name string) {
+	if c.NonDeterministic == nil {
+		c.NonDeterministic = make(map[string]bool)
+	}
+	c.NonDeterministic[name] = true
+}
+
+// Snapshot запоминает текущие значения переменных и возвращает функцию,
+// которая откатывает все последующие изменения: добавленные переменные
+// удаляются, измененные и удаленные получают прежние значения.
+// Карта Variables при этом остается той же, поэтому ссылки на нее не устаревают.
+func (c *Context) Snapshot() func() {
+	saved := make(map[string]float64, len(c.Variables))
+	for name, value := range c.Variables {
+		saved[name] = value
+	}
+
+	return func() {
+		if c.Variables == nil {
+			c.Variables = make(map[string]float64, len(saved))
+		}
+		for name := range c.Variables {
+			if _, exists := saved[name]; !exists {
+				delete(c.Variables, name)
+			}
+		}
+		for name, value := range saved {
+			c.Variables[name] = value
+		}
+	}
+}
+
+// IsDeterministic сообщает, можно ли кэшировать или заранее вычислять узел:
+// поддерево не должно содержать вызовов недетерминированных функций
+func IsDeterministic(node ASTNode, ctx *Context) bool {
+	if fn, ok := node.(*FunctionNode); ok && ctx.isNonDeterministic(fn.Name) {
+		return false
+	}
+	for _, child := range children(node) {
+		if !IsDeterministic(child, ctx) {
+			return false
+		}
+	}
+	return true
 }
 
 // LiteralNode представляет числовое значение
@@ -55,10 +442,7 @@ type VariableNode struct {
 }
 
 func (n *VariableNode) Evaluate(ctx *Context) (float64, error) {
-	if value, exists := ctx.Variables[n.Name]; exists {
-		return value, nil
-	}
-	return 0, fmt.Errorf("variable '%s' not found %w", n.Name, ErrNotFound)
+	return ctx.variableValue(n.Name)
 }
 
 func (n *VariableNode) GetType() NodeType {
@@ -73,6 +457,14 @@ type OperationNode struct {
 }
 
 func (n *OperationNode) Evaluate(ctx *Context) (float64, error) {
+	if err := ctx.interrupted(false); err != nil {
+		return 0, err
+	}
+	ctx, err := ctx.enter(n)
+	if err != nil {
+		return 0, err
+	}
+
 	left, err := n.Left.Evaluate(ctx)
 	if err != nil {
 		return 0, err
@@ -83,7 +475,35 @@ func (n *OperationNode) Evaluate(ctx *Context) (float64, error) {
 		return 0, err
 	}
 
-	switch n.Operator {
+	return evalOperation(n.Operator, left, right, ctx)
+}
+
+// evalOperation применяет арифметический оператор к вычисленным операндам
+// и округляет результат, если задан ctx.FixedScale или ctx.AccountingScale
+func evalOperation(operator string, left, right float64, ctx *Context) (float64, error) {
+	accounting := ctx != nil && ctx.FixedScale == nil && ctx.AccountingScale != nil &&
+		(operator == "+" || operator == "-")
+	if accounting {
+		left = roundToScale(left, *ctx.AccountingScale)
+		right = roundToScale(right, *ctx.AccountingScale)
+	}
+
+	result, err := applyOperator(operator, left, right, ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case ctx != nil && ctx.FixedScale != nil:
+		result = roundToScale(result, *ctx.FixedScale)
+	case accounting:
+		result = roundToScale(result, *ctx.AccountingScale)
+	}
+	return result, nil
+}
+
+func applyOperator(operator string, left, right float64, ctx *Context) (float64, error) {
+	switch operator {
 	case "+":
 		return left + right, nil
 	case "-":
@@ -95,23 +515,73 @@ func (n *OperationNode) Evaluate(ctx *Context) (float64, error) {
 			return 0, errors.New("division by zero")
 		}
 		return left / right, nil
+	case "//":
+		if right == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return math.Floor(left / right), nil
 	case "^", "**":
+		if ctx != nil && ctx.MaxExponent > 0 && math.Abs(right) > ctx.MaxExponent {
+			return 0, fmt.Errorf("exponent %v exceeds maximum allowed %v", right, ctx.MaxExponent)
+		}
 		return math.Pow(left, right), nil
 	case "%":
 		if right == 0 {
 			return 0, errors.New("modulo by zero")
 		}
-		return math.Mod(left, right), nil
+		mode := ModTruncated
+		if ctx != nil {
+			mode = ctx.ModMode
+		}
+		return modulo(left, right, mode), nil
 	default:
-		return 0, fmt.Errorf("unknown operator: %s", n.Operator)
+		return 0, fmt.Errorf("unknown operator: %s", operator)
 	}
 }
 
+// roundToScale округляет значение до scale знаков после запятой, половина
+// округляется от нуля: 2.345 -> 2.35, -2.345 -> -2.35. Перед округлением
+// значение приводится к 15 значащим цифрам, чтобы погрешность двоичного
+// представления не влияла на результат (1.005 округляется до 1.01, а не 1.00).
+func roundToScale(value float64, scale int) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+	factor := math.Pow10(scale)
+	shifted, err := strconv.ParseFloat(strconv.FormatFloat(value*factor, 'g', 15, 64), 64)
+	if err != nil {
+		return value
+	}
+	return math.Round(shifted) / factor
+}
+
+// modulo вычисляет остаток от деления в соответствии с режимом
+func modulo(left, right float64, mode ModMode) float64 {
+	result := math.Mod(left, right)
+	if result == 0 {
+		return result
+	}
+
+	switch mode {
+	case ModFloored:
+		if (result < 0) != (right < 0) {
+			result += right
+		}
+	case ModEuclidean:
+		if result < 0 {
+			result += math.Abs(right)
+		}
+	}
+	return result
+}
+
 func (n *OperationNode) GetType() NodeType {
 	return NodeTypeOperation
 }
 
-// ComparisonNode представляет операцию сравнения
+// ComparisonNode представляет операцию сравнения.
+// Сравнения следуют IEEE 754: любое сравнение с NaN, кроме !=, ложно,
+// поэтому для проверки на NaN используйте функцию isnan.
 type ComparisonNode struct {
 	Operator string  `json:"operator"`
 	Left     ASTNode `json:"left"`
@@ -119,21 +589,45 @@ type ComparisonNode struct {
 }
 
 func (n *ComparisonNode) Evaluate(ctx *Context) (float64, error) {
+	if err := ctx.interrupted(false); err != nil {
+		return 0, err
+	}
+	ctx, err := ctx.enter(n)
+	if err != nil {
+		return 0, err
+	}
+
 	left, err := n.Left.Evaluate(ctx)
 	if err != nil {
+		if ctx.lenientComparison(err) {
+			return 0, nil
+		}
 		return 0, err
 	}
 
 	right, err := n.Right.Evaluate(ctx)
 	if err != nil {
+		if ctx.lenientComparison(err) {
+			return 0, nil
+		}
 		return 0, err
 	}
 
+	return evalComparison(n.Operator, left, right, ctx)
+}
+
+// evalComparison сравнивает вычисленные операнды и возвращает 1 или 0
+// (степень истинности в нечетком режиме)
+func evalComparison(operator string, left, right float64, ctx *Context) (float64, error) {
+	if ctx != nil && ctx.Fuzzy != nil {
+		return ctx.Fuzzy.compare(operator, left, right)
+	}
+
 	var result bool
-	switch n.Operator {
+	switch operator {
 	case "=":
 		result = left == right
-	case "!=":
+	case "!=", "<>":
 		result = left != right
 	case ">":
 		result = left > right
@@ -144,7 +638,7 @@ func (n *ComparisonNode) Evaluate(ctx *Context) (float64, error) {
 	case "<=":
 		result = left <= right
 	default:
-		return 0, fmt.Errorf("unknown comparison operator: %s", n.Operator)
+		return 0, fmt.Errorf("unknown comparison operator: %s", operator)
 	}
 
 	if result {
@@ -162,48 +656,62 @@ type LogicalNode struct {
 	Operator string  `json:"operator"`
 	Left     ASTNode `json:"left"`
 	Right    ASTNode `json:"right"`
+	// Keyword - исходное написание ключевого слова ("И", "or"), если узел получен разбором текста
+	Keyword string `json:"keyword,omitempty"`
 }
 
 func (n *LogicalNode) Evaluate(ctx *Context) (float64, error) {
+	if err := ctx.interrupted(false); err != nil {
+		return 0, err
+	}
+	ctx, err := ctx.enter(n)
+	if err != nil {
+		return 0, err
+	}
+
 	left, err := n.Left.Evaluate(ctx)
 	if err != nil {
 		return 0, err
 	}
 
-	switch n.Operator {
-	case "OR":
-		// В логике OR: если левый операнд истинен (не равен 0), возвращаем 1
-		if left != 0 {
-			return 1, nil
-		}
-		// Иначе вычисляем правый операнд
-		right, err := n.Right.Evaluate(ctx)
-		if err != nil {
-			return 0, err
-		}
-		if right != 0 {
+	// Короткое замыкание: правый операнд не вычисляется, если результат уже известен.
+	// В нечетком режиме нужны степени истинности обоих операндов.
+	if ctx == nil || ctx.Fuzzy == nil {
+		switch {
+		case n.Operator == "OR" && left != 0:
 			return 1, nil
-		}
-		return 0, nil
-
-	case "AND":
-		// В логике AND: если левый операнд ложен (равен 0), возвращаем 0
-		if left == 0 {
+		case n.Operator == "AND" && left == 0:
 			return 0, nil
 		}
-		// Иначе вычисляем правый операнд
-		right, err := n.Right.Evaluate(ctx)
-		if err != nil {
-			return 0, err
-		}
-		if right != 0 {
-			return 1, nil
-		}
-		return 0, nil
+	}
+
+	right, err := n.Right.Evaluate(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return evalLogical(n.Operator, left, right, ctx)
+}
 
+// evalLogical объединяет вычисленные операнды AND или OR
+func evalLogical(operator string, left, right float64, ctx *Context) (float64, error) {
+	if ctx != nil && ctx.Fuzzy != nil {
+		return ctx.Fuzzy.combine(operator, left, right)
+	}
+
+	var result bool
+	switch operator {
+	case "OR":
+		result = left != 0 || right != 0
+	case "AND":
+		result = left != 0 && right != 0
 	default:
-		return 0, fmt.Errorf("unknown logical operator: %s", n.Operator)
+		return 0, fmt.Errorf("unknown logical operator: %s", operator)
 	}
+
+	if result {
+		return 1, nil
+	}
+	return 0, nil
 }
 
 func (n *LogicalNode) GetType() NodeType {
@@ -215,18 +723,38 @@ type ConditionalNode struct {
 	Condition ASTNode `json:"condition"`
 	Then      ASTNode `json:"then"`
 	Else      ASTNode `json:"else"`
+	// Keyword - исходное написание IF/ЕСЛИ, если узел получен разбором текста
+	Keyword string `json:"keyword,omitempty"`
+	// StrictElse запрещает неявный 0: при ложном условии без ELSE
+	// вычисление возвращает ErrMissingElse
+	StrictElse bool `json:"strict_else,omitempty"`
 }
 
 func (n *ConditionalNode) Evaluate(ctx *Context) (float64, error) {
+	if err := ctx.interrupted(false); err != nil {
+		return 0, err
+	}
+	ctx, err := ctx.enter(n)
+	if err != nil {
+		return 0, err
+	}
+
 	condition, err := n.Condition.Evaluate(ctx)
 	if err != nil {
 		return 0, err
 	}
 
-	if condition != 0 { // 0 считается false, все остальное true
+	holds := ctx.conditionHolds(condition)
+	if ctx != nil && ctx.trace != nil {
+		ctx.trace.recordDecision(n, condition, holds)
+	}
+
+	if holds {
 		return n.Then.Evaluate(ctx)
 	} else if n.Else != nil {
 		return n.Else.Evaluate(ctx)
+	} else if n.StrictElse {
+		return 0, fmt.Errorf("condition is false: %w", ErrMissingElse)
 	}
 
 	return 0, nil
@@ -236,25 +764,113 @@ func (n *ConditionalNode) GetType() NodeType {
 	return NodeTypeConditional
 }
 
-// UnaryNode представляет унарную операцию
+// SwitchNode представляет SWITCH(expr, case1, val1, ..., default). Subject
+// вычисляется один раз и по порядку сравнивается с Cases так же, как
+// оператором '='; результат - значение из Values для первого совпавшего
+// варианта, а без совпадений - Default или 0, если Default нет. Cases и
+// Values имеют одинаковую длину.
+type SwitchNode struct {
+	Subject ASTNode   `json:"subject"`
+	Cases   []ASTNode `json:"cases"`
+	Values  []ASTNode `json:"values"`
+	Default ASTNode   `json:"default"`
+}
+
+func (n *SwitchNode) Evaluate(ctx *Context) (float64, error) {
+	if err := ctx.interrupted(false); err != nil {
+		return 0, err
+	}
+	ctx, err := ctx.enter(n)
+	if err != nil {
+		return 0, err
+	}
+
+	subject, err := n.Subject.Evaluate(ctx)
+	if err != nil {
+		if !ctx.lenientComparison(err) {
+			return 0, err
+		}
+		// С LenientComparisons отсутствующая переменная не совпадает ни с одним вариантом
+		return n.otherwise(ctx)
+	}
+
+	for i, match := range n.Cases {
+		value, err := match.Evaluate(ctx)
+		if err != nil {
+			if ctx.lenientComparison(err) {
+				continue
+			}
+			return 0, err
+		}
+		equal, err := evalComparison("=", subject, value, ctx)
+		if err != nil {
+			return 0, err
+		}
+		if ctx.conditionHolds(equal) {
+			return n.Values[i].Evaluate(ctx)
+		}
+	}
+	return n.otherwise(ctx)
+}
+
+// otherwise вычисляет результат SWITCH без совпавших вариантов
+func (n *SwitchNode) otherwise(ctx *Context) (float64, error) {
+	if n.Default != nil {
+		return n.Default.Evaluate(ctx)
+	}
+	return 0, nil
+}
+
+func (n *SwitchNode) GetType() NodeType {
+	return NodeTypeSwitch
+}
+
+// UnaryNode представляет унарную операцию: "-", "+", "abs" (модуль |x|)
+// или логическое отрицание "NOT"
 type UnaryNode struct {
 	Operator string  `json:"operator"`
 	Operand  ASTNode `json:"operand"`
+	// Keyword - исходное написание NOT/НЕ, если узел получен разбором текста
+	Keyword string `json:"keyword,omitempty"`
 }
 
 func (n *UnaryNode) Evaluate(ctx *Context) (float64, error) {
+	if err := ctx.interrupted(false); err != nil {
+		return 0, err
+	}
+	ctx, err := ctx.enter(n)
+	if err != nil {
+		return 0, err
+	}
+
 	operand, err := n.Operand.Evaluate(ctx)
 	if err != nil {
 		return 0, err
 	}
 
-	switch n.Operator {
+	return evalUnary(n.Operator, operand, ctx)
+}
+
+// evalUnary применяет унарный оператор к вычисленному операнду. В нечетком
+// режиме NOT возвращает дополнение степени истинности 1 - x
+func evalUnary(operator string, operand float64, ctx *Context) (float64, error) {
+	switch operator {
 	case "-":
 		return -operand, nil
 	case "+":
 		return operand, nil
+	case "abs":
+		return math.Abs(operand), nil
+	case "NOT":
+		if ctx != nil && ctx.Fuzzy != nil {
+			return 1 - clampUnit(operand), nil
+		}
+		if operand == 0 {
+			return 1, nil
+		}
+		return 0, nil
 	default:
-		return 0, fmt.Errorf("unknown unary operator: %s", n.Operator)
+		return 0, fmt.Errorf("unknown unary operator: %s", operator)
 	}
 }
 
@@ -269,9 +885,14 @@ type FunctionNode struct {
 }
 
 func (n *FunctionNode) Evaluate(ctx *Context) (float64, error) {
-	fn, exists := ctx.Functions[n.Name]
-	if !exists {
-		return 0, fmt.Errorf("function '%s' not found", n.Name)
+	fn, err := ctx.functionByName(n.Name)
+	if err != nil {
+		return 0, err
+	}
+	if len(n.Args) > 0 {
+		if ctx, err = ctx.enter(n); err != nil {
+			return 0, err
+		}
 	}
 
 	args := make([]float64, len(n.Args))
@@ -283,6 +904,9 @@ func (n *FunctionNode) Evaluate(ctx *Context) (float64, error) {
 		args[i] = value
 	}
 
+	if err := ctx.interrupted(true); err != nil {
+		return 0, err
+	}
 	return fn(args)
 }
 
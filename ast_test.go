@@ -0,0 +1,332 @@
+package formula
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNonDeterministicFunctions(t *testing.T) {
+	calls := 0
+	ctx := NewContext()
+	ctx.Functions["now"] = func(args []float64) (float64, error) {
+		calls++
+		return float64(calls), nil
+	}
+	ctx.MarkNonDeterministic("now")
+
+	node := mustParse(t, "now() * (1 + 1) + x")
+	if IsDeterministic(node, ctx) {
+		t.Error("IsDeterministic(now() * (1 + 1) + x) = true")
+	}
+	if !IsDeterministic(mustParse(t, "sqrt(4) + x"), ctx) {
+		t.Error("IsDeterministic(sqrt(4) + x) = false")
+	}
+
+	simplified, err := Simplify(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := CollectFunctions(simplified); len(got) != 1 || got[0] != "now" {
+		t.Errorf("Simplify folded the call away: %v", simplified)
+	}
+
+	ctx.Variables = map[string]float64{"x": 10}
+	e := NewIncrementalEvaluator(node, ctx)
+	first, _ := e.Evaluate()
+	second, _ := e.Evaluate()
+	if first != 12 || second != 14 || calls != 2 {
+		t.Errorf("incremental results %v, %v with %d calls; want 12, 14 and 2 calls", first, second, calls)
+	}
+}
+
+func TestModMode(t *testing.T) {
+	tests := []struct {
+		mode ModMode
+		a, b float64
+		want float64
+	}{
+		{ModTruncated, -7, 3, -1},
+		{ModTruncated, 7, -3, 1},
+		{ModTruncated, -7, -3, -1},
+		{ModFloored, -7, 3, 2},
+		{ModFloored, 7, -3, -2},
+		{ModFloored, -7, -3, -1},
+		{ModEuclidean, -7, 3, 2},
+		{ModEuclidean, 7, -3, 1},
+		{ModEuclidean, -7, -3, 2},
+		{ModEuclidean, 7, 3, 1},
+	}
+	node := mustParse(t, "a % b")
+	for _, tt := range tests {
+		ctx := NewContext()
+		ctx.ModMode = tt.mode
+		ctx.Variables = map[string]float64{"a": tt.a, "b": tt.b}
+		got, err := node.Evaluate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("mode %d: %v %% %v = %v, want %v", tt.mode, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// Целочисленное деление округляет частное вниз, к минус бесконечности
+func TestFloorDivision(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"7 // 2", 3},
+		{"-7 // 2", -4},
+		{"7 // -2", -4},
+		{"-7 // -2", 3},
+		{"6 // 3", 2},
+		{"7.5 // 2", 3},
+		{"1 + 7 // 2 * 2", 7},
+	}
+	for _, tt := range tests {
+		for name, eval := range evalPaths(t, mustParse(t, tt.formula)) {
+			if got, err := eval(NewContext()); err != nil || got != tt.want {
+				t.Errorf("%s(%s) = %v, %v, want %v", name, tt.formula, got, err, tt.want)
+			}
+		}
+	}
+
+	for name, eval := range evalPaths(t, mustParse(t, "x // 0")) {
+		ctx := NewContext()
+		ctx.Variables = map[string]float64{"x": 7}
+		if _, err := eval(ctx); err == nil || !strings.Contains(err.Error(), "division by zero") {
+			t.Errorf("%s(x // 0) error = %v, want division by zero", name, err)
+		}
+	}
+
+	// // - только два слеша подряд: "a/ /b" - два деления, как и до появления //
+	for formula, pos := range map[string]int{"a/ /b": 3, "a /  / b": 5} {
+		_, err := NewSimpleParser().ParseString(formula)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Pos != pos || !strings.Contains(err.Error(), "unexpected operator '/'") {
+			t.Errorf("%q: error %v, want unexpected operator '/' at position %d", formula, err, pos)
+		}
+	}
+}
+
+func TestMaxExponent(t *testing.T) {
+	tests := []struct {
+		formula string
+		ok      bool
+	}{
+		{"2 ^ 10", true},
+		{"2 ^ -10", true},
+		{"2 ^ 10.5", false},
+		{"2 ^ 11", false},
+		{"2 ^ -11", false},
+		{"pow(2, 10)", true},
+		{"pow(2, 11)", false},
+		{"2 ^ 2 ^ 3", true},
+		{"2 ^ 2 ^ 4", false},
+	}
+	for _, tt := range tests {
+		node := mustParse(t, tt.formula)
+		paths := evalPaths(t, node)
+		for name, eval := range paths {
+			ctx := NewContext()
+			ctx.MaxExponent = 10
+			_, err := eval(ctx)
+			if tt.ok && err != nil {
+				t.Errorf("%s: %s at the limit: %v", name, tt.formula, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("%s: %s above the limit: expected error", name, tt.formula)
+			}
+		}
+	}
+
+	if got := evalFormula(t, "2 ^ 100", nil); got != math.Pow(2, 100) {
+		t.Errorf("2 ^ 100 without a limit = %v", got)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"a": 1, "b": 2}
+	vars := ctx.Variables
+
+	restore := ctx.Snapshot()
+	ctx.Variables["c"] = 3
+	ctx.Variables["a"] = 10
+	delete(ctx.Variables, "b")
+	if got, err := mustParse(t, "a + c").Evaluate(ctx); err != nil || got != 13 {
+		t.Fatalf("a + c after changes = %v, %v, want 13", got, err)
+	}
+
+	restore()
+	if _, exists := ctx.Variables["c"]; exists {
+		t.Error("variable c added after Snapshot was not removed")
+	}
+	if ctx.Variables["a"] != 1 || ctx.Variables["b"] != 2 || len(ctx.Variables) != 2 {
+		t.Errorf("Variables after restore = %v, want map[a:1 b:2]", ctx.Variables)
+	}
+	// Восстановление не подменяет карту, поэтому сохраненные ссылки видят откат
+	if _, exists := vars["c"]; exists || len(vars) != 2 {
+		t.Errorf("original map after restore = %v, want map[a:1 b:2]", vars)
+	}
+
+	// Снимок пустого контекста откатывает и переменные, созданные позже
+	empty := NewContext()
+	restore = empty.Snapshot()
+	empty.Variables = map[string]float64{"x": 1}
+	restore()
+	if len(empty.Variables) != 0 {
+		t.Errorf("Variables after restoring empty snapshot = %v, want empty", empty.Variables)
+	}
+}
+
+// FixedScale округляет каждую арифметическую операцию, а не только итог:
+// 10 / 3 * 3 дает 3.33 * 3 = 9.99 вместо 10
+func TestFixedScale(t *testing.T) {
+	node := mustParse(t, "10 / 3 * 3")
+	paths := evalPaths(t, node)
+
+	scale := 2
+	for name, eval := range paths {
+		ctx := NewContext()
+		if got, err := eval(ctx); err != nil || got != 10 {
+			t.Errorf("%s at full precision = %v, %v, want 10", name, got, err)
+		}
+		ctx.FixedScale = &scale
+		if got, err := eval(ctx); err != nil || got != 9.99 {
+			t.Errorf("%s with FixedScale 2 = %v, %v, want 9.99", name, got, err)
+		}
+	}
+
+	// Половина округляется от нуля
+	ctx := NewContext()
+	ctx.FixedScale = &scale
+	for formula, want := range map[string]float64{"1.005 + 0": 1.01, "0 - 2.345": -2.35, "2 / 3": 0.67} {
+		if got, err := mustParse(t, formula).Evaluate(ctx); err != nil || got != want {
+			t.Errorf("%s with FixedScale 2 = %v, %v, want %v", formula, got, err, want)
+		}
+	}
+}
+
+// AccountingScale округляет операнды и результат сложения: 0.1 + 0.1 + 0.1
+// дает ровно 0.3, а не 0.30000000000000004
+func TestAccountingScale(t *testing.T) {
+	node := mustParse(t, "0.1 + 0.1 + 0.1")
+	scale := 2
+	for name, eval := range evalPaths(t, node) {
+		ctx := NewContext()
+		if got, err := eval(ctx); err != nil || got != 0.30000000000000004 {
+			t.Errorf("%s at full precision = %v, %v, want 0.30000000000000004", name, got, err)
+		}
+		ctx.AccountingScale = &scale
+		if got, err := eval(ctx); err != nil || got != 0.3 {
+			t.Errorf("%s with AccountingScale 2 = %v, %v, want 0.3", name, got, err)
+		}
+	}
+
+	// Умножение и деление не округляются до ближайшего сложения
+	ctx := NewContext()
+	ctx.AccountingScale = &scale
+	for formula, want := range map[string]float64{"10 / 3 * 3": 10, "10 / 3 * 3 + 0": 10, "1.005 * 1 + 0": 1.01, "2 / 3": 2.0 / 3} {
+		if got, err := mustParse(t, formula).Evaluate(ctx); err != nil || got != want {
+			t.Errorf("%s with AccountingScale 2 = %v, %v, want %v", formula, got, err, want)
+		}
+	}
+}
+
+// FixedScale без ошибки перекрывает AccountingScale: округляется каждая
+// операция, в том числе умножение и деление
+func TestFixedScaleOverridesAccountingScale(t *testing.T) {
+	node := mustParse(t, "10 / 3 * 3")
+	fixed, accounting := 2, 4
+	for name, eval := range evalPaths(t, node) {
+		ctx := NewContext()
+		ctx.FixedScale = &fixed
+		ctx.AccountingScale = &accounting
+		if got, err := eval(ctx); err != nil || got != 9.99 {
+			t.Errorf("%s with FixedScale 2 and AccountingScale 4 = %v, %v, want 9.99", name, got, err)
+		}
+	}
+
+	ctx := NewContext()
+	ctx.FixedScale = &fixed
+	ctx.AccountingScale = &accounting
+	if got, err := mustParse(t, "0.123 + 0.001").Evaluate(ctx); err != nil || got != 0.12 {
+		t.Errorf("0.123 + 0.001 with FixedScale 2 and AccountingScale 4 = %v, %v, want 0.12", got, err)
+	}
+}
+
+func TestRegisterFunction(t *testing.T) {
+	double := func(args []float64) (float64, error) { return 2 * args[0], nil }
+	triple := func(args []float64) (float64, error) { return 3 * args[0], nil }
+
+	// Контекст без NewContext: карта функций создается при регистрации
+	ctx := &Context{}
+	if ctx.HasFunction("double") {
+		t.Error("HasFunction(double) on empty context = true")
+	}
+	if err := ctx.RegisterFunction("double", double); err != nil {
+		t.Fatal(err)
+	}
+	if !ctx.HasFunction("double") || !ctx.HasFunction("DOUBLE") {
+		t.Error("HasFunction after RegisterFunction = false")
+	}
+	if err := ctx.RegisterFunction("Double", triple); !errors.Is(err, ErrFunctionExists) {
+		t.Errorf("RegisterFunction of an existing name: error %v, want ErrFunctionExists", err)
+	}
+	if err := ctx.RegisterFunction("nilfn", nil); err == nil {
+		t.Error("RegisterFunction(nil): expected error")
+	}
+	if got, err := mustParse(t, "double(4)").Evaluate(ctx); err != nil || got != 8 {
+		t.Errorf("double(4) = %v, %v, want 8", got, err)
+	}
+
+	// SetFunction заменяет уже зарегистрированную функцию
+	ctx.SetFunction("double", triple)
+	if got, err := mustParse(t, "double(4)").Evaluate(ctx); err != nil || got != 12 {
+		t.Errorf("double(4) after SetFunction = %v, %v, want 12", got, err)
+	}
+
+	// RegisterFunctions не добавляет ничего, если хотя бы одно имя занято
+	err := ctx.RegisterFunctions(map[string]func([]float64) (float64, error){"half": double, "double": double})
+	if !errors.Is(err, ErrFunctionExists) {
+		t.Errorf("RegisterFunctions with a taken name: error %v, want ErrFunctionExists", err)
+	}
+	if ctx.HasFunction("half") {
+		t.Error("RegisterFunctions added half despite the error")
+	}
+	if err := ctx.RegisterFunctions(map[string]func([]float64) (float64, error){"half": double, "quad": double}); err != nil {
+		t.Fatal(err)
+	}
+	if !ctx.HasFunction("half") || !ctx.HasFunction("quad") {
+		t.Error("RegisterFunctions did not add all functions")
+	}
+
+	// Встроенные функции NewContext тоже считаются занятыми
+	if err := NewContext().RegisterFunction("sqrt", double); !errors.Is(err, ErrFunctionExists) {
+		t.Errorf("RegisterFunction(sqrt): error %v, want ErrFunctionExists", err)
+	}
+}
+
+// Контекст, собранный литералом без функций, дает понятную ошибку, а не панику
+func TestNilFunctions(t *testing.T) {
+	node := mustParse(t, "sqrt(c)")
+	paths := evalPaths(t, node)
+	for name, eval := range paths {
+		ctx := &Context{Variables: map[string]float64{"c": 9}, Functions: nil}
+		_, err := eval(ctx)
+		if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "no functions registered") {
+			t.Errorf("%s: error %v, want a no functions registered error wrapping ErrNotFound", name, err)
+		}
+	}
+
+	// Переменные в таком контексте по-прежнему работают
+	ctx := &Context{Variables: map[string]float64{"c": 9}}
+	if got, err := mustParse(t, "c * 2").Evaluate(ctx); err != nil || got != 18 {
+		t.Errorf("c * 2 = %v, %v, want 18", got, err)
+	}
+}
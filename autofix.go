@@ -0,0 +1,115 @@
+package formula
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// AutoFix исправляет в формуле типичные опечатки, не меняющие ее смысл:
+// заменяет "==" на "=" и "<>" на "!=", убирает одиночный оператор в конце
+// формулы ("a + b +") и добавляет одну недостающую закрывающую скобку в конце.
+// Каждое исправление описывается в changes. Если исправление неоднозначно
+// (несколько операторов подряд в конце, не хватает нескольких скобок, лишняя
+// закрывающая скобка), формула не меняется и возвращается ошибка. Ошибка
+// возвращается и тогда, когда исправленная формула все еще не разбирается.
+func AutoFix(formula string) (fixed string, changes []string, err error) {
+	fixed = formula
+	tokens := lexAll(fixed)
+
+	// Оператор в конце формулы. Удаляется до замены операторов сравнения,
+	// чтобы все позиции в changes относились к исходной формуле
+	var trailing string
+	if n := len(tokens); n > 0 && tokens[n-1].Type == TokenOperator {
+		last := tokens[n-1]
+		if last.Value == "!" || n == 1 || !endsOperand(tokens[n-2]) {
+			return formula, nil, fmt.Errorf("ambiguous trailing operator '%s' at position %d", last.Value, last.Pos)
+		}
+		fixed = string([]rune(fixed)[:tokens[n-2].End])
+		trailing = fmt.Sprintf("позиция %d: удален оператор '%s' в конце формулы", last.Pos, last.Value)
+		tokens = tokens[:n-1]
+	}
+
+	// Операторы сравнения: только токены лексера, имена в кавычках не затрагиваются
+	for i := len(tokens) - 1; i >= 0; i-- {
+		token := tokens[i]
+		if token.Type != TokenOperator {
+			continue
+		}
+		var replacement string
+		switch token.Value {
+		case "==":
+			replacement = "="
+		case "<>":
+			replacement = "!="
+		default:
+			continue
+		}
+		fixed = replaceRunes(fixed, token.Pos, token.End, replacement)
+		changes = append(changes, fmt.Sprintf("позиция %d: '%s' заменен на '%s'", token.Pos, token.Value, replacement))
+	}
+	reverseStrings(changes) // исправления перечисляются слева направо
+	if trailing != "" {
+		changes = append(changes, trailing)
+	}
+
+	// Незакрытая скобка
+	depth := 0
+	for _, token := range lexAll(fixed) {
+		switch token.Type {
+		case TokenParenOpen:
+			depth++
+		case TokenParenClose:
+			depth--
+			if depth < 0 {
+				return formula, nil, fmt.Errorf("unexpected closing parenthesis at position %d", token.Pos)
+			}
+		}
+	}
+	switch {
+	case depth == 1:
+		fixed = strings.TrimRightFunc(fixed, unicode.IsSpace) + ")"
+		changes = append(changes, "добавлена закрывающая скобка в конце формулы")
+	case depth > 1:
+		return formula, nil, fmt.Errorf("%d closing parentheses are missing, cannot tell where they belong", depth)
+	}
+
+	if _, err := NewParser(fixed).Parse(); err != nil {
+		return fixed, changes, fmt.Errorf("formula is still invalid after fixes: %w", err)
+	}
+	return fixed, changes, nil
+}
+
+// lexAll возвращает все токены формулы без завершающего TokenEOF
+func lexAll(formula string) []Token {
+	lexer := NewLexer(formula)
+	var tokens []Token
+	for {
+		token := lexer.NextToken()
+		if token.Type == TokenEOF {
+			return tokens
+		}
+		tokens = append(tokens, token)
+	}
+}
+
+// endsOperand сообщает, может ли токен завершать операнд бинарного оператора
+func endsOperand(token Token) bool {
+	switch token.Type {
+	case TokenNumber, TokenVariable, TokenParenClose, TokenBar, TokenTrue, TokenFalse:
+		return true
+	}
+	return false
+}
+
+// replaceRunes заменяет руны [start, end) строки s на replacement
+func replaceRunes(s string, start, end int, replacement string) string {
+	runes := []rune(s)
+	return string(runes[:start]) + replacement + string(runes[end:])
+}
+
+func reverseStrings(values []string) {
+	for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+		values[i], values[j] = values[j], values[i]
+	}
+}
@@ -0,0 +1,68 @@
+package formula
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAutoFix(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    string
+		changes []string
+	}{
+		{"a == b", "a = b", []string{"позиция 2: '==' заменен на '='"}},
+		{"a <> b", "a != b", []string{"позиция 2: '<>' заменен на '!='"}},
+		{"a == 1 OR b == 2", "a = 1 OR b = 2", []string{
+			"позиция 2: '==' заменен на '='",
+			"позиция 12: '==' заменен на '='",
+		}},
+		{"a + b +", "a + b", []string{"позиция 6: удален оператор '+' в конце формулы"}},
+		{"(a + b", "(a + b)", []string{"добавлена закрывающая скобка в конце формулы"}},
+		{"max(a, b  ", "max(a, b)", []string{"добавлена закрывающая скобка в конце формулы"}},
+		{"(a == b *", "(a = b)", []string{
+			"позиция 3: '==' заменен на '='",
+			"позиция 8: удален оператор '*' в конце формулы",
+			"добавлена закрывающая скобка в конце формулы",
+		}},
+		// Имена в кавычках не изменяются
+		{"`x == y` == 1", "`x == y` = 1", []string{"позиция 9: '==' заменен на '='"}},
+		// Корректная формула возвращается без изменений
+		{"a + b", "a + b", nil},
+	}
+	for _, tt := range tests {
+		fixed, changes, err := AutoFix(tt.formula)
+		if err != nil {
+			t.Errorf("AutoFix(%q): %v", tt.formula, err)
+			continue
+		}
+		if fixed != tt.want {
+			t.Errorf("AutoFix(%q) = %q, want %q", tt.formula, fixed, tt.want)
+		}
+		if !reflect.DeepEqual(changes, tt.changes) {
+			t.Errorf("AutoFix(%q) changes = %q, want %q", tt.formula, changes, tt.changes)
+		}
+	}
+}
+
+// Неоднозначные случаи не исправляются: формула возвращается как есть
+func TestAutoFixRefusesAmbiguous(t *testing.T) {
+	for _, formula := range []string{"a + b + *", "+", "a + !", "((a + b", "max(a, (b", "a + b)", "(a + b))"} {
+		fixed, changes, err := AutoFix(formula)
+		if err == nil {
+			t.Errorf("AutoFix(%q) = %q, %q, want error", formula, fixed, changes)
+			continue
+		}
+		if fixed != formula || changes != nil {
+			t.Errorf("AutoFix(%q) changed the formula to %q (%q) despite error %v", formula, fixed, changes, err)
+		}
+	}
+
+	// Исправления, после которых формула все равно не разбирается, тоже ошибка
+	_, _, err := AutoFix("a + * b")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Errorf("AutoFix(a + * b) error = %v, want a wrapped ParseError", err)
+	}
+}
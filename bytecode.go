@@ -0,0 +1,396 @@
+package formula
+
+import (
+	"fmt"
+	"strings"
+)
+
+// opcode - код инструкции стековой машины
+type opcode int
+
+const (
+	opPush            opcode = iota // положить value на стек
+	opLoad                          // положить значение переменной name; arg > 0 - адрес opCompareFallback
+	opBinary                        // снять два значения, применить арифметический оператор name
+	opCompare                       // снять два значения, применить сравнение name
+	opUnary                         // снять значение, применить унарный оператор name
+	opCall                          // снять arg аргументов, вызвать функцию name
+	opJump                          // перейти к инструкции arg
+	opJumpIfFalse                   // снять условие, перейти к arg, если оно ложно (см. conditionHolds)
+	opAndShort                      // если вершина равна 0, заменить ее на 0 и перейти к arg
+	opOrShort                       // если вершина не равна 0, заменить ее на 1 и перейти к arg
+	opLogical                       // снять два значения, применить AND или OR (name)
+	opMissingElse                   // ошибка строгого условия без ELSE
+	opCompareFallback               // обрезать стек до arg значений и положить 0 (LenientComparisons)
+	opDup                           // положить копию вершины стека
+	opPop                           // снять значение
+)
+
+// instruction - одна инструкция программы
+type instruction struct {
+	op    opcode
+	value float64
+	name  string
+	arg   int
+}
+
+// Program - формула, скомпилированная в байткод для стековой машины.
+// Вычисление не обходит дерево и не вызывает методы интерфейса для каждого
+// узла, поэтому подходит для многократного вычисления в горячих циклах.
+// Трассировка (EvaluateWithTrace) для программ не поддерживается.
+type Program struct {
+	code      []instruction
+	stackSize int
+	depth     int // глубина исходного дерева для проверки Context.MaxDepth
+}
+
+// CompileBytecode компилирует дерево в программу. Логические операции
+// и условия компилируются в условные переходы, поэтому, как и при обходе
+// дерева, невыбранные ветки и правые операнды AND/OR не вычисляются.
+func CompileBytecode(node ASTNode) (*Program, error) {
+	c := &compiler{}
+	if err := c.compile(node); err != nil {
+		return nil, err
+	}
+	return &Program{code: c.code, stackSize: c.maxDepth, depth: treeDepth(node)}, nil
+}
+
+// compiler накапливает инструкции и отслеживает глубину стека
+type compiler struct {
+	code     []instruction
+	depth    int
+	maxDepth int
+
+	// fallbacks - для каждого компилируемого сравнения адреса загрузок
+	// переменных в его операндах (для LenientComparisons)
+	fallbacks [][]int
+}
+
+func (c *compiler) emit(in instruction, stackEffect int) int {
+	c.code = append(c.code, in)
+	c.depth += stackEffect
+	if c.depth > c.maxDepth {
+		c.maxDepth = c.depth
+	}
+	return len(c.code) - 1
+}
+
+// patch устанавливает адрес перехода на текущий конец программы
+func (c *compiler) patch(at int) {
+	c.code[at].arg = len(c.code)
+}
+
+func (c *compiler) compile(node ASTNode) error {
+	switch n := node.(type) {
+	case *LiteralNode:
+		c.emit(instruction{op: opPush, value: n.Value}, 1)
+
+	case *VariableNode:
+		at := c.emit(instruction{op: opLoad, name: n.Name}, 1)
+		if len(c.fallbacks) > 0 {
+			last := len(c.fallbacks) - 1
+			c.fallbacks[last] = append(c.fallbacks[last], at)
+		}
+
+	case *OperationNode:
+		if err := c.compileOperands(n.Left, n.Right); err != nil {
+			return err
+		}
+		c.emit(instruction{op: opBinary, name: n.Operator}, -1)
+
+	case *ComparisonNode:
+		return c.compileComparison(n.Operator, func() error {
+			return c.compileOperands(n.Left, n.Right)
+		})
+
+	case *LogicalNode:
+		if err := c.compile(n.Left); err != nil {
+			return err
+		}
+		short := opOrShort
+		if n.Operator == "AND" {
+			short = opAndShort
+		}
+		jump := c.emit(instruction{op: short}, 0)
+		if err := c.compile(n.Right); err != nil {
+			return err
+		}
+		c.emit(instruction{op: opLogical, name: n.Operator}, -1)
+		c.patch(jump)
+
+	case *UnaryNode:
+		if err := c.compile(n.Operand); err != nil {
+			return err
+		}
+		c.emit(instruction{op: opUnary, name: n.Operator}, 0)
+
+	case *ConditionalNode:
+		if err := c.compile(n.Condition); err != nil {
+			return err
+		}
+		toElse := c.emit(instruction{op: opJumpIfFalse}, -1)
+		if err := c.compile(n.Then); err != nil {
+			return err
+		}
+		toEnd := c.emit(instruction{op: opJump}, -1) // на стеке останется результат одной из веток
+		c.patch(toElse)
+		switch {
+		case n.Else != nil:
+			if err := c.compile(n.Else); err != nil {
+				return err
+			}
+		case n.StrictElse:
+			c.emit(instruction{op: opMissingElse}, 1)
+		default:
+			c.emit(instruction{op: opPush, value: 0}, 1)
+		}
+		c.patch(toEnd)
+
+	case *SwitchNode:
+		return c.compileSwitch(n)
+
+	case *FunctionNode:
+		for _, arg := range n.Args {
+			if err := c.compile(arg); err != nil {
+				return err
+			}
+		}
+		c.emit(instruction{op: opCall, name: n.Name, arg: len(n.Args)}, 1-len(n.Args))
+
+	default:
+		return fmt.Errorf("unsupported node type: %T", node)
+	}
+	return nil
+}
+
+// compileComparison компилирует сравнение, операнды которого кладет на стек
+// operands. Отсутствующие переменные в операндах при LenientComparisons
+// переходят к инструкции, заменяющей результат сравнения на 0.
+func (c *compiler) compileComparison(operator string, operands func() error) error {
+	depth := c.depth
+	loads, err := c.collectLoads(operands)
+	if err != nil {
+		return err
+	}
+	c.emit(instruction{op: opCompare, name: operator}, -1)
+	c.emitFallback(depth, loads)
+	return nil
+}
+
+// collectLoads компилирует код и возвращает адреса загрузок переменных в нем,
+// не вложенных в другие сравнения
+func (c *compiler) collectLoads(compile func() error) ([]int, error) {
+	c.fallbacks = append(c.fallbacks, nil)
+	err := compile()
+	loads := c.fallbacks[len(c.fallbacks)-1]
+	c.fallbacks = c.fallbacks[:len(c.fallbacks)-1]
+	return loads, err
+}
+
+// emitFallback направляет загрузки loads к инструкции, которая обрезает стек
+// до depth значений и кладет 0; обычное выполнение ее перепрыгивает
+func (c *compiler) emitFallback(depth int, loads []int) {
+	if len(loads) == 0 {
+		return
+	}
+	toEnd := c.emit(instruction{op: opJump}, 0)
+	c.depth = depth
+	fallback := c.emit(instruction{op: opCompareFallback, arg: depth}, 1)
+	c.patch(toEnd)
+	for _, at := range loads {
+		c.code[at].arg = fallback
+	}
+}
+
+// compileSwitch держит значение Subject на стеке, пока идут сравнения:
+// каждое сравнивает его копию (opDup) с вариантом, а перед вычислением
+// выбранного значения или Default оно снимается (opPop). При LenientComparisons
+// отсутствующая переменная в Subject заменяет его на 0 и ведет к Default.
+func (c *compiler) compileSwitch(n *SwitchNode) error {
+	depth := c.depth
+	subjectLoads, err := c.collectLoads(func() error { return c.compile(n.Subject) })
+	if err != nil {
+		return err
+	}
+
+	var toEnd []int
+	for i, match := range n.Cases {
+		err := c.compileComparison("=", func() error {
+			c.emit(instruction{op: opDup}, 1)
+			return c.compile(match)
+		})
+		if err != nil {
+			return err
+		}
+		toNext := c.emit(instruction{op: opJumpIfFalse}, -1)
+		c.emit(instruction{op: opPop}, -1)
+		if err := c.compile(n.Values[i]); err != nil {
+			return err
+		}
+		// На стеке снова одно значение, как и на месте следующего сравнения
+		toEnd = append(toEnd, c.emit(instruction{op: opJump}, 0))
+		c.patch(toNext)
+	}
+
+	c.emitFallback(depth, subjectLoads)
+	c.emit(instruction{op: opPop}, -1)
+	if n.Default != nil {
+		if err := c.compile(n.Default); err != nil {
+			return err
+		}
+	} else {
+		c.emit(instruction{op: opPush, value: 0}, 1)
+	}
+
+	for _, at := range toEnd {
+		c.patch(at)
+	}
+	return nil
+}
+
+func (c *compiler) compileOperands(left, right ASTNode) error {
+	if err := c.compile(left); err != nil {
+		return err
+	}
+	return c.compile(right)
+}
+
+// Run выполняет программу в контексте ctx. Программа не изменяется при
+// выполнении, поэтому Run можно вызывать одновременно из нескольких горутин.
+func (p *Program) Run(ctx *Context) (float64, error) {
+	if err := ctx.checkDepth(p.depth); err != nil {
+		return 0, err
+	}
+	stack := make([]float64, 0, p.stackSize)
+	pop := func() float64 {
+		value := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return value
+	}
+	fuzzy := ctx != nil && ctx.Fuzzy != nil
+
+	for pc := 0; pc < len(p.code); pc++ {
+		in := p.code[pc]
+		switch in.op {
+		case opPush:
+			stack = append(stack, in.value)
+
+		case opLoad:
+			value, err := ctx.variableValue(in.name)
+			if err != nil {
+				if in.arg > 0 && ctx.lenientComparison(err) {
+					pc = in.arg - 1
+					continue
+				}
+				return 0, err
+			}
+			stack = append(stack, value)
+
+		case opBinary, opCompare, opLogical:
+			right, left := pop(), pop()
+			var result float64
+			var err error
+			switch in.op {
+			case opBinary:
+				result, err = evalOperation(in.name, left, right, ctx)
+			case opCompare:
+				result, err = evalComparison(in.name, left, right, ctx)
+			default:
+				result, err = evalLogical(in.name, left, right, ctx)
+			}
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, result)
+
+		case opUnary:
+			result, err := evalUnary(in.name, pop(), ctx)
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, result)
+
+		case opCall:
+			fn, err := ctx.functionByName(in.name)
+			if err != nil {
+				return 0, err
+			}
+			args := make([]float64, in.arg)
+			copy(args, stack[len(stack)-in.arg:])
+			stack = stack[:len(stack)-in.arg]
+			result, err := fn(args)
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, result)
+
+		case opJump:
+			pc = in.arg - 1
+
+		case opJumpIfFalse:
+			if !ctx.conditionHolds(pop()) {
+				pc = in.arg - 1
+			}
+
+		case opAndShort:
+			if !fuzzy && stack[len(stack)-1] == 0 {
+				pc = in.arg - 1
+			}
+
+		case opOrShort:
+			if !fuzzy && stack[len(stack)-1] != 0 {
+				stack[len(stack)-1] = 1
+				pc = in.arg - 1
+			}
+
+		case opMissingElse:
+			return 0, fmt.Errorf("condition is false: %w", ErrMissingElse)
+
+		case opCompareFallback:
+			stack = append(stack[:in.arg], 0)
+
+		case opDup:
+			stack = append(stack, stack[len(stack)-1])
+
+		case opPop:
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if len(stack) != 1 {
+		return 0, fmt.Errorf("invalid program: %d values left on stack", len(stack))
+	}
+	return stack[0], nil
+}
+
+// String возвращает листинг программы для отладки
+func (p *Program) String() string {
+	names := map[opcode]string{
+		opPush: "PUSH", opLoad: "LOAD", opBinary: "BINARY", opCompare: "COMPARE",
+		opUnary: "UNARY", opCall: "CALL", opJump: "JUMP", opJumpIfFalse: "JUMP_IF_FALSE",
+		opAndShort: "AND_SHORT", opOrShort: "OR_SHORT", opLogical: "LOGICAL", opMissingElse: "MISSING_ELSE",
+		opCompareFallback: "COMPARE_FALLBACK", opDup: "DUP", opPop: "POP",
+	}
+
+	var b strings.Builder
+	for i, in := range p.code {
+		fmt.Fprintf(&b, "%3d %s", i, names[in.op])
+		switch in.op {
+		case opPush:
+			fmt.Fprintf(&b, " %s", formatNumber(in.value))
+		case opLoad, opBinary, opCompare, opUnary, opLogical:
+			fmt.Fprintf(&b, " %s", in.name)
+			if in.op == opLoad && in.arg > 0 {
+				fmt.Fprintf(&b, " ?%d", in.arg)
+			}
+		case opCompareFallback:
+			fmt.Fprintf(&b, " %d", in.arg)
+		case opCall:
+			fmt.Fprintf(&b, " %s/%d", in.name, in.arg)
+		case opJump, opJumpIfFalse, opAndShort, opOrShort:
+			fmt.Fprintf(&b, " %d", in.arg)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,140 @@
+package formula
+
+import (
+	"errors"
+	"testing"
+)
+
+// nodeKindFormulas покрывают все виды узлов и типичные ошибки вычисления
+var nodeKindFormulas = []string{
+	// литералы, переменные и арифметика
+	"42", "a", "a + b * c", "(a - b) / c", "a // b", "a % b", "a ^ 2", "a ** b",
+	// унарные операции
+	"-a", "+a", "-(a - b)", "|b - a|", "NOT a",
+	// сравнения
+	"a = b", "a != b", "a <> b", "a > b", "a < b", "a >= b", "a <= b",
+	// логические операции
+	"a > 0 AND b > 0", "a > 0 OR b > 0", "NOT (a > 0) OR b = c", "a AND b OR c",
+	// условия
+	"IF(a > b, a, b)", "IF(a > b, 5)", "IF a > 1 THEN a ELSE IF b > 1 THEN b ELSE c",
+	"ЕСЛИ a > b ТОГДА 1 ИНАЧЕ 2",
+	// функции
+	"max(a, b, c)", "min(a, sqrt(c * c))", "abs(a - b) + round(c / 3, 2)", "count(a, b, c)",
+	"IF(max(a, b) > c, a * b, c) + min(a, IF(b > 0, b, 1))",
+	// ошибки
+	"a / b", "a // b", "missing + 1", "unknown(a)", "sqrt(a) / (b - b)",
+}
+
+// nodeKindVarSets - наборы переменных для nodeKindFormulas
+var nodeKindVarSets = []map[string]float64{
+	{"a": 3, "b": 2, "c": 7},
+	{"a": -1.5, "b": 0, "c": 4},
+	{"a": 0, "b": 5, "c": 0},
+}
+
+// Программа должна давать те же результаты и ошибки, что и обход дерева,
+// для всех видов узлов
+func TestBytecodeMatchesTree(t *testing.T) {
+	for _, formula := range nodeKindFormulas {
+		node := mustParse(t, formula)
+		program, err := CompileBytecode(node)
+		if err != nil {
+			t.Fatalf("CompileBytecode(%q): %v", formula, err)
+		}
+		for _, vars := range nodeKindVarSets {
+			ctx := NewContext()
+			ctx.Variables = vars
+			want, wantErr := node.Evaluate(ctx)
+			got, err := program.Run(ctx)
+			if (err == nil) != (wantErr == nil) || (err != nil && err.Error() != wantErr.Error()) {
+				t.Errorf("%s with %v: error %v, tree error %v", formula, vars, err, wantErr)
+				continue
+			}
+			if got != want {
+				t.Errorf("%s with %v = %v, tree = %v", formula, vars, got, want)
+			}
+		}
+	}
+}
+
+// Как и при обходе дерева, правые операнды AND/OR и невыбранные ветки
+// не вычисляются, поэтому ошибки в них не возникают
+func TestBytecodeShortCircuit(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"a = 0 OR 1 / a > 1", 1},
+		{"a != 0 AND 1 / a > 1", 0},
+		{"IF(a > 0, 1 / a, -1)", -1},
+		{"IF(a = 0, -1, 1 / a)", -1},
+		{"a = 0 OR missing > 1", 1},
+	}
+	for _, tt := range tests {
+		program, err := CompileBytecode(mustParse(t, tt.formula))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := NewContext()
+		ctx.Variables = map[string]float64{"a": 0}
+		if got, err := program.Run(ctx); err != nil || got != tt.want {
+			t.Errorf("%s = %v, %v, want %v", tt.formula, got, err, tt.want)
+		}
+	}
+
+	// Строгое условие без ELSE
+	node := mustParse(t, "IF(a > 0, 1)")
+	node.(*ConditionalNode).StrictElse = true
+	program, err := CompileBytecode(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"a": 0}
+	if _, err := program.Run(ctx); !errors.Is(err, ErrMissingElse) {
+		t.Errorf("strict IF error = %v, want ErrMissingElse", err)
+	}
+}
+
+// Программа не хранит состояния между вызовами и может выполняться повторно
+func TestBytecodeReuse(t *testing.T) {
+	program, err := CompileBytecode(mustParse(t, benchmarkFormula))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, price := range []float64{50, 150, 50} {
+		ctx := NewContext()
+		ctx.Variables = map[string]float64{"price": price, "qty": 2, "discount": 0.5, "tax": 0.1}
+		want := evalFormula(t, benchmarkFormula, ctx.Variables)
+		if got, err := program.Run(ctx); err != nil || got != want {
+			t.Errorf("price %v: Run = %v, %v, want %v", price, got, err, want)
+		}
+	}
+}
+
+func BenchmarkBytecodeRun(b *testing.B) {
+	program, err := CompileBytecode(mustParse(b, benchmarkFormula))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"price": 150, "qty": 3, "discount": 0.1, "tax": 0.2}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := program.Run(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTreeEvaluate(b *testing.B) {
+	node := mustParse(b, benchmarkFormula)
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"price": 150, "qty": 3, "discount": 0.1, "tax": 0.2}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := node.Evaluate(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
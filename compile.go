@@ -0,0 +1,322 @@
+package formula
+
+import (
+	"fmt"
+	"math"
+)
+
+// Evaluator - скомпилированная формула, см. Compile
+type Evaluator func(ctx *Context) (float64, error)
+
+// Compile один раз разбирает операторы дерева и возвращает замыкание, которое
+// вычисляет формулу без обхода узлов через интерфейс и без сравнения строк
+// операторов на каждом вычислении. Результаты и ошибки совпадают с
+// node.Evaluate(ctx); функции и переменные, как и там, ищутся в контексте при
+// каждом вычислении. Замыкание не изменяется при вызове, поэтому его можно
+// использовать одновременно из нескольких горутин.
+func Compile(node ASTNode) (Evaluator, error) {
+	if node == nil {
+		return nil, fmt.Errorf("cannot compile nil node")
+	}
+	evaluate, depth := compileNode(node), treeDepth(node)
+	return func(ctx *Context) (float64, error) {
+		if err := ctx.checkDepth(depth); err != nil {
+			return 0, err
+		}
+		return evaluate(ctx)
+	}, nil
+}
+
+func compileNode(node ASTNode) Evaluator {
+	switch n := node.(type) {
+	case nil:
+		// Evaluate для такого дерева завершилось бы паникой
+		return func(*Context) (float64, error) {
+			return 0, fmt.Errorf("missing node")
+		}
+
+	case *LiteralNode:
+		value := n.Value
+		return func(*Context) (float64, error) {
+			return value, nil
+		}
+
+	case *VariableNode:
+		name := n.Name
+		return func(ctx *Context) (float64, error) {
+			return ctx.variableValue(name)
+		}
+
+	case *OperationNode:
+		return compileOperation(n)
+
+	case *ComparisonNode:
+		return compileComparison(n)
+
+	case *LogicalNode:
+		return compileLogical(n)
+
+	case *ConditionalNode:
+		return compileConditional(n)
+
+	case *SwitchNode:
+		return compileSwitch(n)
+
+	case *UnaryNode:
+		return compileUnary(n)
+
+	case *FunctionNode:
+		return compileFunction(n)
+
+	default:
+		// Узлы других пакетов и служебные узлы вычисляются как есть
+		return node.Evaluate
+	}
+}
+
+func compileOperation(n *OperationNode) Evaluator {
+	left, right := compileNode(n.Left), compileNode(n.Right)
+
+	operator := n.Operator
+	var apply func(l, r float64) float64
+	switch operator {
+	case "+":
+		apply = func(l, r float64) float64 { return l + r }
+	case "-":
+		apply = func(l, r float64) float64 { return l - r }
+	case "*":
+		apply = func(l, r float64) float64 { return l * r }
+	default:
+		// Операторы с проверками (деление на ноль, MaxExponent, ModMode)
+		return func(ctx *Context) (float64, error) {
+			l, err := left(ctx)
+			if err != nil {
+				return 0, err
+			}
+			r, err := right(ctx)
+			if err != nil {
+				return 0, err
+			}
+			return evalOperation(operator, l, r, ctx)
+		}
+	}
+
+	return func(ctx *Context) (float64, error) {
+		l, err := left(ctx)
+		if err != nil {
+			return 0, err
+		}
+		r, err := right(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if ctx != nil && (ctx.FixedScale != nil || ctx.AccountingScale != nil) {
+			return evalOperation(operator, l, r, ctx)
+		}
+		return apply(l, r), nil
+	}
+}
+
+func compileComparison(n *ComparisonNode) Evaluator {
+	left, right := compileNode(n.Left), compileNode(n.Right)
+	operator := n.Operator
+
+	var compare func(l, r float64) bool
+	switch operator {
+	case "=":
+		compare = func(l, r float64) bool { return l == r }
+	case "!=", "<>":
+		compare = func(l, r float64) bool { return l != r }
+	case ">":
+		compare = func(l, r float64) bool { return l > r }
+	case "<":
+		compare = func(l, r float64) bool { return l < r }
+	case ">=":
+		compare = func(l, r float64) bool { return l >= r }
+	case "<=":
+		compare = func(l, r float64) bool { return l <= r }
+	}
+
+	return func(ctx *Context) (float64, error) {
+		l, err := left(ctx)
+		if err != nil {
+			if ctx.lenientComparison(err) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		r, err := right(ctx)
+		if err != nil {
+			if ctx.lenientComparison(err) {
+				return 0, nil
+			}
+			return 0, err
+		}
+
+		if compare == nil || (ctx != nil && ctx.Fuzzy != nil) {
+			return evalComparison(operator, l, r, ctx)
+		}
+		if compare(l, r) {
+			return 1, nil
+		}
+		return 0, nil
+	}
+}
+
+func compileLogical(n *LogicalNode) Evaluator {
+	left, right := compileNode(n.Left), compileNode(n.Right)
+	operator := n.Operator
+	isAnd, isOr := operator == "AND", operator == "OR"
+
+	return func(ctx *Context) (float64, error) {
+		l, err := left(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		if ctx == nil || ctx.Fuzzy == nil {
+			switch {
+			case isOr && l != 0:
+				return 1, nil
+			case isAnd && l == 0:
+				return 0, nil
+			}
+		}
+
+		r, err := right(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return evalLogical(operator, l, r, ctx)
+	}
+}
+
+func compileConditional(n *ConditionalNode) Evaluator {
+	condition, then := compileNode(n.Condition), compileNode(n.Then)
+	var otherwise Evaluator
+	if n.Else != nil {
+		otherwise = compileNode(n.Else)
+	}
+	strict := n.StrictElse
+
+	return func(ctx *Context) (float64, error) {
+		c, err := condition(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		holds := ctx.conditionHolds(c)
+		if ctx != nil && ctx.trace != nil {
+			ctx.trace.recordDecision(n, c, holds)
+		}
+
+		switch {
+		case holds:
+			return then(ctx)
+		case otherwise != nil:
+			return otherwise(ctx)
+		case strict:
+			return 0, fmt.Errorf("condition is false: %w", ErrMissingElse)
+		}
+		return 0, nil
+	}
+}
+
+func compileSwitch(n *SwitchNode) Evaluator {
+	subject := compileNode(n.Subject)
+	cases, values := make([]Evaluator, len(n.Cases)), make([]Evaluator, len(n.Values))
+	for i := range n.Cases {
+		cases[i], values[i] = compileNode(n.Cases[i]), compileNode(n.Values[i])
+	}
+	otherwise := func(*Context) (float64, error) { return 0, nil }
+	if n.Default != nil {
+		otherwise = compileNode(n.Default)
+	}
+
+	return func(ctx *Context) (float64, error) {
+		s, err := subject(ctx)
+		if err != nil {
+			if ctx.lenientComparison(err) {
+				return otherwise(ctx)
+			}
+			return 0, err
+		}
+
+		for i, match := range cases {
+			m, err := match(ctx)
+			if err != nil {
+				if ctx.lenientComparison(err) {
+					continue
+				}
+				return 0, err
+			}
+			equal, err := evalComparison("=", s, m, ctx)
+			if err != nil {
+				return 0, err
+			}
+			if ctx.conditionHolds(equal) {
+				return values[i](ctx)
+			}
+		}
+		return otherwise(ctx)
+	}
+}
+
+func compileUnary(n *UnaryNode) Evaluator {
+	operand := compileNode(n.Operand)
+
+	switch n.Operator {
+	case "-":
+		return func(ctx *Context) (float64, error) {
+			value, err := operand(ctx)
+			if err != nil {
+				return 0, err
+			}
+			return -value, nil
+		}
+	case "+":
+		return operand
+	case "abs":
+		return func(ctx *Context) (float64, error) {
+			value, err := operand(ctx)
+			if err != nil {
+				return 0, err
+			}
+			return math.Abs(value), nil
+		}
+	}
+
+	operator := n.Operator
+	return func(ctx *Context) (float64, error) {
+		value, err := operand(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return evalUnary(operator, value, ctx)
+	}
+}
+
+func compileFunction(n *FunctionNode) Evaluator {
+	name := n.Name
+	args := make([]Evaluator, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = compileNode(arg)
+	}
+
+	return func(ctx *Context) (float64, error) {
+		fn, err := ctx.functionByName(name)
+		if err != nil {
+			return 0, err
+		}
+
+		values := make([]float64, len(args))
+		for i, arg := range args {
+			value, err := arg(ctx)
+			if err != nil {
+				return 0, err
+			}
+			values[i] = value
+		}
+		return fn(values)
+	}
+}
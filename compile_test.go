@@ -0,0 +1,104 @@
+package formula
+
+import (
+	"errors"
+	"testing"
+)
+
+// Скомпилированная формула должна давать те же результаты и ошибки, что и Evaluate
+func TestCompileMatchesEvaluate(t *testing.T) {
+	for _, formula := range nodeKindFormulas {
+		node := mustParse(t, formula)
+		compiled, err := Compile(node)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", formula, err)
+		}
+		for _, vars := range nodeKindVarSets {
+			ctx := NewContext()
+			ctx.Variables = vars
+			want, wantErr := node.Evaluate(ctx)
+			got, err := compiled(ctx)
+			if (err == nil) != (wantErr == nil) || (err != nil && err.Error() != wantErr.Error()) {
+				t.Errorf("%s with %v: error %v, Evaluate error %v", formula, vars, err, wantErr)
+				continue
+			}
+			if got != want {
+				t.Errorf("%s with %v = %v, Evaluate = %v", formula, vars, got, want)
+			}
+		}
+	}
+}
+
+// Ошибки сохраняют цепочку, поэтому errors.Is работает так же, как для Evaluate
+func TestCompileErrorsMatchEvaluate(t *testing.T) {
+	strict := mustParse(t, "IF(a > 5, 1)")
+	strict.(*ConditionalNode).StrictElse = true
+
+	tests := []struct {
+		name   string
+		node   ASTNode
+		target error
+		setup  func(*Context)
+	}{
+		{"missing variable", mustParse(t, "a + missing"), ErrNotFound, nil},
+		{"missing else", strict, ErrMissingElse, nil},
+		{"max depth", mustParse(t, "((a + 1) + 1) + 1"), ErrMaxDepth, func(ctx *Context) { ctx.MaxDepth = 2 }},
+	}
+	for _, tt := range tests {
+		compiled, err := Compile(tt.node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newContext := func() *Context {
+			ctx := NewContext()
+			ctx.Variables = map[string]float64{"a": 1}
+			if tt.setup != nil {
+				tt.setup(ctx)
+			}
+			return ctx
+		}
+		_, wantErr := tt.node.Evaluate(newContext())
+		_, err = compiled(newContext())
+		if !errors.Is(err, tt.target) || !errors.Is(wantErr, tt.target) {
+			t.Errorf("%s: Compile error %v, Evaluate error %v, want both to wrap %v", tt.name, err, wantErr, tt.target)
+		}
+		if err != nil && wantErr != nil && err.Error() != wantErr.Error() {
+			t.Errorf("%s: Compile error %q, Evaluate error %q", tt.name, err, wantErr)
+		}
+	}
+
+	if _, err := Compile(nil); err == nil {
+		t.Error("Compile(nil): expected error")
+	}
+}
+
+// deepFormula - формула средней глубины с условиями, функциями и арифметикой
+const deepFormula = "IF(a > 10, ((a + 1) * (b - 2) / (c + 3) - a * b % 7) ^ 2, " +
+	"IF(b > a, max(a, b, c) * 2 + |c - a|, (a - b) * (b - c) * (c - a))) + (a + b + c) / 3 - -(a * 0.5)"
+
+func BenchmarkCompiledDeep(b *testing.B) {
+	compiled, err := Compile(mustParse(b, deepFormula))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"a": 12, "b": 4, "c": 9}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := compiled(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEvaluateDeep(b *testing.B) {
+	node := mustParse(b, deepFormula)
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"a": 12, "b": 4, "c": 9}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := node.Evaluate(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
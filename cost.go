@@ -0,0 +1,81 @@
+package formula
+
+import "strings"
+
+// Веса операций для EstimateCost
+const (
+	costCheap     = 1 // переменные, +, -, сравнения, логические и унарные операции
+	costModerate  = 2 // *, /, %
+	costFunction  = 3 // вызов обычной функции
+	costExpensive = 5 // ^ и тяжелые функции (sqrt, log, exp, ...)
+)
+
+// expensiveFunctions - функции, вычисление которых заметно дороже арифметики
+var expensiveFunctions = map[string]bool{
+	"sqrt": true, "log": true, "log10": true, "exp": true, "pow": true,
+	"growth": true, "sin": true, "cos": true, "tan": true,
+}
+
+// EstimateCost возвращает статическую оценку стоимости вычисления формулы без
+// ее выполнения: сумму весов всех операций. Для условных выражений учитывается
+// условие и самая дорогая из веток, так как выполняется только одна из них;
+// для SWITCH - выражение, все варианты и самое дорогое из значений.
+// Оценка предназначена для сравнения формул между собой, а не для измерения времени.
+func EstimateCost(node ASTNode) int {
+	switch n := node.(type) {
+	case nil:
+		return 0
+
+	case *LiteralNode:
+		return 0
+
+	case *VariableNode:
+		return costCheap
+
+	case *OperationNode:
+		weight := costCheap
+		switch n.Operator {
+		case "*", "/", "//", "%":
+			weight = costModerate
+		case "^", "**":
+			weight = costExpensive
+		}
+		return weight + EstimateCost(n.Left) + EstimateCost(n.Right)
+
+	case *ConditionalNode:
+		branch := EstimateCost(n.Then)
+		if otherwise := EstimateCost(n.Else); otherwise > branch {
+			branch = otherwise
+		}
+		return costCheap + EstimateCost(n.Condition) + branch
+
+	case *SwitchNode:
+		total := costCheap + EstimateCost(n.Subject)
+		branch := EstimateCost(n.Default)
+		for i := range n.Cases {
+			total += costCheap + EstimateCost(n.Cases[i])
+			if value := EstimateCost(n.Values[i]); value > branch {
+				branch = value
+			}
+		}
+		return total + branch
+
+	case *FunctionNode:
+		weight := costFunction
+		if expensiveFunctions[strings.ToLower(n.Name)] {
+			weight = costExpensive
+		}
+		for _, arg := range n.Args {
+			weight += EstimateCost(arg)
+		}
+		return weight
+
+	default:
+		// Сравнения, логические и унарные операции
+		total := costCheap
+		for _, child := range children(node) {
+			total += EstimateCost(child)
+		}
+		return total
+	}
+}
@@ -0,0 +1,30 @@
+package formula
+
+import "testing"
+
+func TestEstimateCost(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    int
+	}{
+		{"42", 0},
+		{"a + b + c", 5},
+		{"a * b", 4},
+		{"sqrt(a) ^ 2 + sqrt(b)", 18},
+		{"max(a, b)", 5},
+		// Учитывается только самая дорогая ветка условия
+		{"IF(a > 1, sqrt(a), b)", 9},
+		{"IF(a > 1, b, sqrt(a))", 9},
+	}
+	for _, tt := range tests {
+		if got := EstimateCost(mustParse(t, tt.formula)); got != tt.want {
+			t.Errorf("EstimateCost(%q) = %d, want %d", tt.formula, got, tt.want)
+		}
+	}
+
+	cheap := EstimateCost(mustParse(t, "a + b + c + d"))
+	expensive := EstimateCost(mustParse(t, "sqrt(a) + sqrt(b) ^ 2 + log(c) ^ d"))
+	if expensive <= cheap {
+		t.Errorf("EstimateCost of sqrt/^ formula = %d, want more than additive formula %d", expensive, cheap)
+	}
+}
@@ -0,0 +1,225 @@
+package formula
+
+import (
+	"fmt"
+	"math"
+)
+
+// interval - отрезок [lo, hi] возможных значений узла
+type interval struct {
+	lo, hi float64
+}
+
+var (
+	unboundedInterval = interval{math.Inf(-1), math.Inf(1)}
+	falseInterval     = interval{0, 0}
+	trueInterval      = interval{1, 1}
+	booleanInterval   = interval{0, 1}
+)
+
+// DeadBranches находит ветки IF, которые не могут быть выбраны при заданных
+// границах переменных, и возвращает их описания. bounds задает для переменной
+// отрезок [min, max]; переменные без границ считаются неограниченными.
+// Например, при score из [0, 100] условие score > 200 никогда не выполняется,
+// и ветка THEN помечается как недостижимая. Оценка консервативна: ветка
+// считается мертвой, только если это следует из интервальной арифметики.
+func DeadBranches(node ASTNode, bounds map[string][2]float64) []string {
+	var dead []string
+	findDeadBranches(node, bounds, &dead)
+	return dead
+}
+
+func findDeadBranches(node ASTNode, bounds map[string][2]float64, dead *[]string) {
+	conditional, ok := node.(*ConditionalNode)
+	if !ok {
+		for _, child := range children(node) {
+			findDeadBranches(child, bounds, dead)
+		}
+		return
+	}
+
+	findDeadBranches(conditional.Condition, bounds, dead)
+
+	condition := evalInterval(conditional.Condition, bounds)
+	description := StringLocalized(conditional.Condition, LanguageEnglish)
+	switch {
+	case condition == falseInterval:
+		*dead = append(*dead, fmt.Sprintf("THEN branch of IF %s is unreachable: condition is never true", description))
+		findDeadBranches(conditional.Else, bounds, dead)
+	case !condition.contains(0):
+		if conditional.Else != nil {
+			*dead = append(*dead, fmt.Sprintf("ELSE branch of IF %s is unreachable: condition is always true", description))
+		}
+		findDeadBranches(conditional.Then, bounds, dead)
+	default:
+		findDeadBranches(conditional.Then, bounds, dead)
+		findDeadBranches(conditional.Else, bounds, dead)
+	}
+}
+
+// evalInterval вычисляет отрезок возможных значений узла
+func evalInterval(node ASTNode, bounds map[string][2]float64) interval {
+	switch n := node.(type) {
+	case *LiteralNode:
+		return interval{n.Value, n.Value}
+
+	case *VariableNode:
+		if b, ok := bounds[n.Name]; ok {
+			return interval{b[0], b[1]}
+		}
+		return unboundedInterval
+
+	case *UnaryNode:
+		operand := evalInterval(n.Operand, bounds)
+		switch n.Operator {
+		case "-":
+			return interval{-operand.hi, -operand.lo}
+		case "+":
+			return operand
+		case "abs":
+			if operand.lo >= 0 {
+				return operand
+			}
+			if operand.hi <= 0 {
+				return interval{-operand.hi, -operand.lo}
+			}
+			return interval{0, math.Max(-operand.lo, operand.hi)}
+		case "NOT":
+			switch {
+			case operand == falseInterval:
+				return trueInterval
+			case !operand.contains(0):
+				return falseInterval
+			}
+			return booleanInterval
+		}
+		return unboundedInterval
+
+	case *OperationNode:
+		return operationInterval(n.Operator, evalInterval(n.Left, bounds), evalInterval(n.Right, bounds))
+
+	case *ComparisonNode:
+		return comparisonInterval(n.Operator, evalInterval(n.Left, bounds), evalInterval(n.Right, bounds))
+
+	case *LogicalNode:
+		left := evalInterval(n.Left, bounds)
+		right := evalInterval(n.Right, bounds)
+		leftTrue, leftFalse := !left.contains(0), left == falseInterval
+		rightTrue, rightFalse := !right.contains(0), right == falseInterval
+		switch n.Operator {
+		case "AND":
+			if leftFalse || rightFalse {
+				return falseInterval
+			}
+			if leftTrue && rightTrue {
+				return trueInterval
+			}
+		case "OR":
+			if leftTrue || rightTrue {
+				return trueInterval
+			}
+			if leftFalse && rightFalse {
+				return falseInterval
+			}
+		}
+		return booleanInterval
+
+	case *ConditionalNode:
+		condition := evalInterval(n.Condition, bounds)
+		otherwise := falseInterval // без ELSE ложное условие дает 0
+		if n.Else != nil {
+			otherwise = evalInterval(n.Else, bounds)
+		}
+		switch {
+		case condition == falseInterval:
+			return otherwise
+		case !condition.contains(0):
+			return evalInterval(n.Then, bounds)
+		}
+		return evalInterval(n.Then, bounds).hull(otherwise)
+
+	case *SwitchNode:
+		result := falseInterval // без Default и совпадений SWITCH дает 0
+		if n.Default != nil {
+			result = evalInterval(n.Default, bounds)
+		}
+		for _, value := range n.Values {
+			result = result.hull(evalInterval(value, bounds))
+		}
+		return result
+
+	default:
+		return unboundedInterval
+	}
+}
+
+// operationInterval применяет арифметический оператор к отрезкам
+func operationInterval(operator string, left, right interval) interval {
+	switch operator {
+	case "+":
+		return interval{left.lo + right.lo, left.hi + right.hi}
+	case "-":
+		return interval{left.lo - right.hi, left.hi - right.lo}
+	case "*":
+		return spanOf(left.lo*right.lo, left.lo*right.hi, left.hi*right.lo, left.hi*right.hi)
+	case "/":
+		if right.contains(0) {
+			return unboundedInterval
+		}
+		return spanOf(left.lo/right.lo, left.lo/right.hi, left.hi/right.lo, left.hi/right.hi)
+	case "//":
+		quotient := operationInterval("/", left, right)
+		return interval{math.Floor(quotient.lo), math.Floor(quotient.hi)}
+	}
+	return unboundedInterval
+}
+
+// comparisonInterval определяет, всегда ли сравнение истинно, всегда ложно или неизвестно
+func comparisonInterval(operator string, left, right interval) interval {
+	var always, never bool
+	switch operator {
+	case ">":
+		always, never = left.lo > right.hi, left.hi <= right.lo
+	case ">=":
+		always, never = left.lo >= right.hi, left.hi < right.lo
+	case "<":
+		always, never = left.hi < right.lo, left.lo >= right.hi
+	case "<=":
+		always, never = left.hi <= right.lo, left.lo > right.hi
+	case "=":
+		always = left.lo == left.hi && right.lo == right.hi && left.lo == right.lo
+		never = left.hi < right.lo || right.hi < left.lo
+	case "!=", "<>":
+		never = left.lo == left.hi && right.lo == right.hi && left.lo == right.lo
+		always = left.hi < right.lo || right.hi < left.lo
+	}
+
+	switch {
+	case always:
+		return trueInterval
+	case never:
+		return falseInterval
+	}
+	return booleanInterval
+}
+
+// spanOf возвращает наименьший отрезок, содержащий все значения
+func spanOf(values ...float64) interval {
+	result := interval{math.Inf(1), math.Inf(-1)}
+	for _, v := range values {
+		if math.IsNaN(v) {
+			return unboundedInterval
+		}
+		result.lo = math.Min(result.lo, v)
+		result.hi = math.Max(result.hi, v)
+	}
+	return result
+}
+
+func (i interval) contains(value float64) bool {
+	return i.lo <= value && value <= i.hi
+}
+
+func (i interval) hull(other interval) interval {
+	return interval{math.Min(i.lo, other.lo), math.Max(i.hi, other.hi)}
+}
@@ -0,0 +1,28 @@
+package formula
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeadBranches(t *testing.T) {
+	bounds := map[string][2]float64{"score": {0, 100}}
+	tests := []struct {
+		formula string
+		want    []string
+	}{
+		{"IF(score > 200, 1, 2)", []string{"THEN branch of IF score > 200 is unreachable: condition is never true"}},
+		{"IF(score >= 0, 1, 2)", []string{"ELSE branch of IF score >= 0 is unreachable: condition is always true"}},
+		// Без ELSE нечего помечать, даже если условие всегда истинно
+		{"IF(score >= 0, 1)", nil},
+		{"IF(score > 50, 1, 2)", nil},
+		// Переменные без границ не ограничены
+		{"IF(bonus > 200, 1, 2)", nil},
+		{"IF(score * 2 > 150, IF(score < -1, 1, 2), 3)", []string{"THEN branch of IF score < -1 is unreachable: condition is never true"}},
+	}
+	for _, tt := range tests {
+		if got := DeadBranches(mustParse(t, tt.formula), bounds); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DeadBranches(%q) = %q, want %q", tt.formula, got, tt.want)
+		}
+	}
+}
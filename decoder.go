@@ -1,38 +1,68 @@
 package formula
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"math"
+	"math/rand"
+	"strconv"
 )
 
 // NodeData используется для десериализации JSON
 type NodeData struct {
-	Type      NodeType          `json:"type"`
-	Value     *float64          `json:"value,omitempty"`
-	Name      *string           `json:"name,omitempty"`
-	Operator  *string           `json:"operator,omitempty"`
-	Left      json.RawMessage   `json:"left,omitempty"`
-	Right     json.RawMessage   `json:"right,omitempty"`
-	Condition json.RawMessage   `json:"condition,omitempty"`
-	Then      json.RawMessage   `json:"then,omitempty"`
-	Else      json.RawMessage   `json:"else,omitempty"`
-	Args      []json.RawMessage `json:"args,omitempty"`
+	Type       NodeType          `json:"type"`
+	Value      json.RawMessage   `json:"value,omitempty"`
+	Name       *string           `json:"name,omitempty"`
+	Operator   *string           `json:"operator,omitempty"`
+	Left       json.RawMessage   `json:"left,omitempty"`
+	Right      json.RawMessage   `json:"right,omitempty"`
+	Operand    json.RawMessage   `json:"operand,omitempty"`
+	Condition  json.RawMessage   `json:"condition,omitempty"`
+	Then       json.RawMessage   `json:"then,omitempty"`
+	Else       json.RawMessage   `json:"else,omitempty"`
+	Args       []json.RawMessage `json:"args,omitempty"`
+	Subject    json.RawMessage   `json:"subject,omitempty"`
+	Cases      []json.RawMessage `json:"cases,omitempty"`
+	Values     []json.RawMessage `json:"values,omitempty"`
+	Default    json.RawMessage   `json:"default,omitempty"`
+	Keyword    *string           `json:"keyword,omitempty"`
+	StrictElse *bool             `json:"strict_else,omitempty"`
+}
+
+// DecodeOptions настраивает десериализацию дерева из JSON
+type DecodeOptions struct {
+	// StrictElse помечает условия без ветки ELSE как строгие: если условие
+	// ложно, вычисление возвращает ErrMissingElse вместо неявного 0
+	StrictElse bool
+
+	// DisallowUnknownFields запрещает поля, которых нет в NodeData: опечатка
+	// вроде "oprator" приводит к ошибке с именем поля, а не теряется молча
+	DisallowUnknownFields bool
 }
 
 // UnmarshalJSON десериализует JSON в ASTNode
 func UnmarshalASTNode(data []byte) (ASTNode, error) {
+	return UnmarshalASTNodeWithOptions(data, DecodeOptions{})
+}
+
+// UnmarshalASTNodeWithOptions десериализует JSON в ASTNode с заданными параметрами
+func UnmarshalASTNodeWithOptions(data []byte, options DecodeOptions) (ASTNode, error) {
 	var nodeData NodeData
-	if err := json.Unmarshal(data, &nodeData); err != nil {
+	if err := decodeNodeData(data, &nodeData, options); err != nil {
 		return nil, err
 	}
 
 	switch nodeData.Type {
 	case NodeTypeLiteral:
-		if nodeData.Value == nil {
+		if len(nodeData.Value) == 0 || string(nodeData.Value) == "null" {
 			return nil, fmt.Errorf("literal node missing value")
 		}
-		return &LiteralNode{Value: *nodeData.Value}, nil
+		value, err := decodeLiteralValue(nodeData.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &LiteralNode{Value: value}, nil
 
 	case NodeTypeVariable:
 		if nodeData.Name == nil {
@@ -45,12 +75,12 @@ func UnmarshalASTNode(data []byte) (ASTNode, error) {
 			return nil, fmt.Errorf("operation node missing operator")
 		}
 
-		left, err := UnmarshalASTNode(nodeData.Left)
+		left, err := UnmarshalASTNodeWithOptions(nodeData.Left, options)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing left operand: %v", err)
 		}
 
-		right, err := UnmarshalASTNode(nodeData.Right)
+		right, err := UnmarshalASTNodeWithOptions(nodeData.Right, options)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing right operand: %v", err)
 		}
@@ -66,12 +96,12 @@ func UnmarshalASTNode(data []byte) (ASTNode, error) {
 			return nil, fmt.Errorf("comparison node missing operator")
 		}
 
-		left, err := UnmarshalASTNode(nodeData.Left)
+		left, err := UnmarshalASTNodeWithOptions(nodeData.Left, options)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing left operand: %v", err)
 		}
 
-		right, err := UnmarshalASTNode(nodeData.Right)
+		right, err := UnmarshalASTNodeWithOptions(nodeData.Right, options)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing right operand: %v", err)
 		}
@@ -82,13 +112,57 @@ func UnmarshalASTNode(data []byte) (ASTNode, error) {
 			Right:    right,
 		}, nil
 
+	case NodeTypeLogical:
+		if nodeData.Operator == nil {
+			return nil, fmt.Errorf("logical node missing operator")
+		}
+
+		left, err := UnmarshalASTNodeWithOptions(nodeData.Left, options)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing left operand: %v", err)
+		}
+
+		right, err := UnmarshalASTNodeWithOptions(nodeData.Right, options)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing right operand: %v", err)
+		}
+
+		node := &LogicalNode{
+			Operator: *nodeData.Operator,
+			Left:     left,
+			Right:    right,
+		}
+		if nodeData.Keyword != nil {
+			node.Keyword = *nodeData.Keyword
+		}
+		return node, nil
+
+	case NodeTypeUnary:
+		if nodeData.Operator == nil {
+			return nil, fmt.Errorf("unary node missing operator")
+		}
+
+		operand, err := UnmarshalASTNodeWithOptions(nodeData.Operand, options)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing operand: %v", err)
+		}
+
+		node := &UnaryNode{
+			Operator: *nodeData.Operator,
+			Operand:  operand,
+		}
+		if nodeData.Keyword != nil {
+			node.Keyword = *nodeData.Keyword
+		}
+		return node, nil
+
 	case NodeTypeConditional:
-		condition, err := UnmarshalASTNode(nodeData.Condition)
+		condition, err := UnmarshalASTNodeWithOptions(nodeData.Condition, options)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing condition: %v", err)
 		}
 
-		then, err := UnmarshalASTNode(nodeData.Then)
+		then, err := UnmarshalASTNodeWithOptions(nodeData.Then, options)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing then branch: %v", err)
 		}
@@ -97,17 +171,53 @@ func UnmarshalASTNode(data []byte) (ASTNode, error) {
 			Condition: condition,
 			Then:      then,
 		}
+		if nodeData.Keyword != nil {
+			node.Keyword = *nodeData.Keyword
+		}
 
 		if len(nodeData.Else) > 0 {
-			elseNode, err := UnmarshalASTNode(nodeData.Else)
+			elseNode, err := UnmarshalASTNodeWithOptions(nodeData.Else, options)
 			if err != nil {
 				return nil, fmt.Errorf("error parsing else branch: %v", err)
 			}
 			node.Else = elseNode
+		} else {
+			node.StrictElse = options.StrictElse || (nodeData.StrictElse != nil && *nodeData.StrictElse)
 		}
 
 		return node, nil
 
+	case NodeTypeSwitch:
+		if len(nodeData.Cases) == 0 || len(nodeData.Cases) != len(nodeData.Values) {
+			return nil, fmt.Errorf("switch node needs matching non-empty cases and values, got %d and %d",
+				len(nodeData.Cases), len(nodeData.Values))
+		}
+
+		subject, err := UnmarshalASTNodeWithOptions(nodeData.Subject, options)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing subject: %v", err)
+		}
+
+		node := &SwitchNode{
+			Subject: subject,
+			Cases:   make([]ASTNode, len(nodeData.Cases)),
+			Values:  make([]ASTNode, len(nodeData.Values)),
+		}
+		for i := range nodeData.Cases {
+			if node.Cases[i], err = UnmarshalASTNodeWithOptions(nodeData.Cases[i], options); err != nil {
+				return nil, fmt.Errorf("error parsing case %d: %v", i, err)
+			}
+			if node.Values[i], err = UnmarshalASTNodeWithOptions(nodeData.Values[i], options); err != nil {
+				return nil, fmt.Errorf("error parsing value %d: %v", i, err)
+			}
+		}
+		if len(nodeData.Default) > 0 {
+			if node.Default, err = UnmarshalASTNodeWithOptions(nodeData.Default, options); err != nil {
+				return nil, fmt.Errorf("error parsing default: %v", err)
+			}
+		}
+		return node, nil
+
 	case NodeTypeFunction:
 		if nodeData.Name == nil {
 			return nil, fmt.Errorf("function node missing name")
@@ -115,7 +225,7 @@ func UnmarshalASTNode(data []byte) (ASTNode, error) {
 
 		args := make([]ASTNode, len(nodeData.Args))
 		for i, argData := range nodeData.Args {
-			arg, err := UnmarshalASTNode(argData)
+			arg, err := UnmarshalASTNodeWithOptions(argData, options)
 			if err != nil {
 				return nil, fmt.Errorf("error parsing function argument %d: %v", i, err)
 			}
@@ -132,6 +242,43 @@ func UnmarshalASTNode(data []byte) (ASTNode, error) {
 	}
 }
 
+// decodeNodeData читает JSON одного узла; в строгом режиме неизвестные
+// поля и данные после объекта считаются ошибкой
+func decodeNodeData(data []byte, nodeData *NodeData, options DecodeOptions) error {
+	if !options.DisallowUnknownFields {
+		return json.Unmarshal(data, nodeData)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(nodeData); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("unexpected data after node JSON")
+	}
+	return nil
+}
+
+// decodeLiteralValue читает значение литерала, заданное числом или строкой
+// с числом: некоторые клиенты передают числа как "2.5"
+func decodeLiteralValue(data json.RawMessage) (float64, error) {
+	var number float64
+	if err := json.Unmarshal(data, &number); err == nil {
+		return number, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return 0, fmt.Errorf("literal value must be a number or a numeric string, got %s", data)
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("literal value %q is not a number", text)
+	}
+	return number, nil
+}
+
 // Helper функция для создания контекста
 func NewContext() *Context {
 	ctx := &Context{
@@ -191,5 +338,312 @@ func NewContext() *Context {
 		return sum, nil
 	}
 
+	// Число истинных (ненулевых) аргументов: count(a > 1, b > 2, c > 3)
+	// считает выполненные условия. NaN истинным не считается.
+	ctx.Functions["count"] = func(args []float64) (float64, error) {
+		count := 0.0
+		for _, arg := range args {
+			if arg != 0 && !math.IsNaN(arg) {
+				count++
+			}
+		}
+		return count, nil
+	}
+
+	// Округление: round(x) - до целого, round(x, n) - до n знаков после
+	// запятой (n < 0 округляет до десятков, сотен...). Половина округляется
+	// от нуля: round(2.5) = 3, round(-2.675, 2) = -2.68
+	ctx.Functions["round"] = func(args []float64) (float64, error) {
+		switch len(args) {
+		case 1:
+			return math.Round(args[0]), nil
+		case 2:
+			places := args[1]
+			if places != math.Trunc(places) {
+				return 0, fmt.Errorf("round precision must be an integer, got %v", places)
+			}
+			if math.Abs(places) > 15 {
+				return 0, fmt.Errorf("round precision must be between -15 and 15, got %v", places)
+			}
+			return roundToScale(args[0], int(places)), nil
+		default:
+			return 0, fmt.Errorf("round requires 1 or 2 arguments")
+		}
+	}
+
+	for name, fn := range map[string]func(float64) float64{"floor": math.Floor, "ceil": math.Ceil, "trunc": math.Trunc} {
+		name, fn := name, fn
+		ctx.Functions[name] = func(args []float64) (float64, error) {
+			if len(args) != 1 {
+				return 0, fmt.Errorf("%s requires exactly 1 argument", name)
+			}
+			return fn(args[0]), nil
+		}
+	}
+
+	// Логарифмы и экспонента: log(x) - натуральный логарифм, log(x, base) -
+	// логарифм по основанию base
+	ctx.Functions["log"] = func(args []float64) (float64, error) {
+		switch len(args) {
+		case 1:
+			if args[0] <= 0 {
+				return 0, fmt.Errorf("log of non-positive number")
+			}
+			return math.Log(args[0]), nil
+		case 2:
+			if args[0] <= 0 {
+				return 0, fmt.Errorf("log of non-positive number")
+			}
+			if args[1] <= 0 || args[1] == 1 {
+				return 0, fmt.Errorf("log base must be positive and not equal to 1")
+			}
+			return math.Log(args[0]) / math.Log(args[1]), nil
+		default:
+			return 0, fmt.Errorf("log requires 1 or 2 arguments")
+		}
+	}
+
+	ctx.Functions["log10"] = func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("log10 requires exactly 1 argument")
+		}
+		if args[0] <= 0 {
+			return 0, fmt.Errorf("log10 of non-positive number")
+		}
+		return math.Log10(args[0]), nil
+	}
+
+	ctx.Functions["exp"] = func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("exp requires exactly 1 argument")
+		}
+		return math.Exp(args[0]), nil
+	}
+
+	// pow(x, y) равносильна x ^ y и так же учитывает ctx.MaxExponent
+	ctx.Functions["pow"] = func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("pow requires exactly 2 arguments")
+		}
+		if args[0] < 0 && args[1] != math.Trunc(args[1]) {
+			return 0, fmt.Errorf("pow of negative number with fractional exponent")
+		}
+		return applyOperator("^", args[0], args[1], ctx)
+	}
+
+	// Относительное изменение (new - old) / old
+	ctx.Functions["pctchange"] = func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("pctchange requires exactly 2 arguments")
+		}
+		if args[0] == 0 {
+			return 0, fmt.Errorf("pctchange of zero base value")
+		}
+		return (args[1] - args[0]) / args[0], nil
+	}
+
+	// Среднегодовой (сложный) темп роста между первым и последним значением
+	ctx.Functions["growth"] = func(args []float64) (float64, error) {
+		if len(args) < 2 {
+			return 0, fmt.Errorf("growth requires at least 2 arguments")
+		}
+		first, last := args[0], args[len(args)-1]
+		if first == 0 {
+			return 0, fmt.Errorf("growth of zero base value")
+		}
+		ratio := last / first
+		if ratio < 0 {
+			return 0, fmt.Errorf("growth of values with different signs")
+		}
+		return math.Pow(ratio, 1/float64(len(args)-1)) - 1, nil
+	}
+
+	// Проверки на NaN и бесконечность. Сравнения с NaN по IEEE 754 всегда
+	// ложны (NaN = NaN дает 0), поэтому это единственный надежный способ обнаружить NaN
+	ctx.Functions["isnan"] = func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("isnan requires exactly 1 argument")
+		}
+		if math.IsNaN(args[0]) {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	ctx.Functions["isfinite"] = func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("isfinite requires exactly 1 argument")
+		}
+		if math.IsNaN(args[0]) || math.IsInf(args[0], 0) {
+			return 0, nil
+		}
+		return 1, nil
+	}
+
+	// Трехстороннее сравнение для формул-компараторов: -1, 0 или 1
+	ctx.Functions["cmp"] = func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("cmp requires exactly 2 arguments")
+		}
+		if math.IsNaN(args[0]) || math.IsNaN(args[1]) {
+			return 0, fmt.Errorf("cmp of NaN value")
+		}
+		return sign(args[0] - args[1]), nil
+	}
+
+	ctx.Functions["sign"] = func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("sign requires exactly 1 argument")
+		}
+		if math.IsNaN(args[0]) {
+			return 0, fmt.Errorf("sign of NaN value")
+		}
+		return sign(args[0]), nil
+	}
+
+	// Сравнение с допуском: 1, если |a - b| <= tol, иначе 0
+	ctx.Functions["approx"] = func(args []float64) (float64, error) {
+		if len(args) != 3 {
+			return 0, fmt.Errorf("approx requires exactly 3 arguments")
+		}
+		if args[2] < 0 {
+			return 0, fmt.Errorf("approx tolerance must not be negative")
+		}
+		if math.Abs(args[0]-args[1]) <= args[2] {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	// Перевод между градусами и радианами
+	ctx.Functions["deg2rad"] = func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("deg2rad requires exactly 1 argument")
+		}
+		return args[0] * math.Pi / 180, nil
+	}
+
+	ctx.Functions["rad2deg"] = func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("rad2deg requires exactly 1 argument")
+		}
+		return args[0] * 180 / math.Pi, nil
+	}
+
+	// Тригонометрические функции учитывают ctx.AngleMode: прямые принимают
+	// угол, обратные возвращают угол в заданных единицах
+	for name, fn := range map[string]func(float64) float64{"sin": math.Sin, "cos": math.Cos, "tan": math.Tan} {
+		fn := fn
+		ctx.Functions[name] = angleFunction(ctx, name, func(x float64) float64 {
+			return fn(ctx.toRadians(x))
+		})
+	}
+	for name, fn := range map[string]func(float64) float64{"asin": math.Asin, "acos": math.Acos, "atan": math.Atan} {
+		fn := fn
+		ctx.Functions[name] = angleFunction(ctx, name, func(x float64) float64 {
+			return ctx.fromRadians(fn(x))
+		})
+	}
+
+	// atan2(y, x) - угол точки (x, y) с учетом четверти, от -180 до 180 градусов
+	// (от -pi до pi радиан)
+	ctx.Functions["atan2"] = func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("atan2 requires exactly 2 arguments")
+		}
+		return ctx.fromRadians(math.Atan2(args[0], args[1])), nil
+	}
+
+	// Случайные числа: результат меняется между вызовами, поэтому функции
+	// помечаются как недетерминированные
+	ctx.Functions["rand"] = func(args []float64) (float64, error) {
+		if len(args) != 0 {
+			return 0, fmt.Errorf("rand takes no arguments")
+		}
+		return ctx.random().Float64(), nil
+	}
+
+	// Случайное целое число из [a, b] включительно
+	ctx.Functions["randbetween"] = func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("randbetween requires exactly 2 arguments")
+		}
+		low, high := math.Ceil(args[0]), math.Floor(args[1])
+		if math.IsNaN(low) || math.IsInf(low, 0) || math.IsNaN(high) || math.IsInf(high, 0) {
+			return 0, fmt.Errorf("randbetween requires finite bounds")
+		}
+		if low > high {
+			return 0, fmt.Errorf("randbetween requires the lower bound not to exceed the upper bound")
+		}
+		// Число вариантов high - low + 1 должно помещаться в int64 для Int63n
+		span := high - low
+		if span >= 1<<63 {
+			return 0, fmt.Errorf("randbetween range %v..%v is too wide", low, high)
+		}
+		return low + float64(ctx.random().Int63n(int64(span)+1)), nil
+	}
+
+	ctx.MarkNonDeterministic("rand")
+	ctx.MarkNonDeterministic("randbetween")
+
 	return ctx
 }
+
+// globalRand использует общий источник math/rand, если Context.Rand не задан
+var globalRand = rand.New(lockedSource{})
+
+// lockedSource - rand.Source поверх потокобезопасных функций пакета math/rand
+type lockedSource struct{}
+
+func (lockedSource) Int63() int64 { return rand.Int63() }
+func (lockedSource) Seed(int64)   {}
+
+// random возвращает источник случайных чисел контекста
+func (c *Context) random() *rand.Rand {
+	if c.Rand != nil {
+		return c.Rand
+	}
+	return globalRand
+}
+
+// angleFunction оборачивает тригонометрическую функцию одного аргумента
+func angleFunction(ctx *Context, name string, fn func(float64) float64) func([]float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("%s requires exactly 1 argument", name)
+		}
+		result := fn(args[0])
+		if math.IsNaN(result) && !math.IsNaN(args[0]) {
+			return 0, fmt.Errorf("%s argument %v is out of domain", name, args[0])
+		}
+		return result, nil
+	}
+}
+
+// toRadians переводит угол из единиц контекста в радианы
+func (c *Context) toRadians(angle float64) float64 {
+	if c.AngleMode == AngleDegrees {
+		return angle * math.Pi / 180
+	}
+	return angle
+}
+
+// fromRadians переводит угол в радианах в единицы контекста
+func (c *Context) fromRadians(angle float64) float64 {
+	if c.AngleMode == AngleDegrees {
+		return angle * 180 / math.Pi
+	}
+	return angle
+}
+
+// sign возвращает -1, 0 или 1 в зависимости от знака x
+func sign(x float64) float64 {
+	switch {
+	case x < 0:
+		return -1
+	case x > 0:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,502 @@
+package formula
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestFunctionNamesIgnoreCase(t *testing.T) {
+	vars := map[string]float64{"a": 2, "b": 1, "c": 5, "x": 16}
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"SUM(a > 1, b > 2, c > 3)", 2},
+		{"COUNT(a > 1, b > 2, c > 3)", 2},
+		{"count(a > 1, b > 2, c > 3)", 2},
+		{"PCTCHANGE(50, 75)", 0.5},
+		{"Sqrt(x)", 4},
+		{"MAX(a, c) + Min(a, c)", 7},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, vars); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+}
+
+func TestCustomFunctionIgnoresCase(t *testing.T) {
+	ctx := &Context{}
+	ctx.SetFunction("myDouble", func(args []float64) (float64, error) {
+		return 2 * args[0], nil
+	})
+	for _, name := range []string{"myDouble", "MYDOUBLE", "mydouble"} {
+		value, err := (&FunctionNode{Name: name, Args: []ASTNode{&LiteralNode{Value: 3}}}).Evaluate(ctx)
+		if err != nil || value != 6 {
+			t.Errorf("%s(3) = %v, %v; want 6", name, value, err)
+		}
+	}
+
+	if _, err := (&FunctionNode{Name: "missing"}).Evaluate(ctx); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing(): error %v does not wrap ErrNotFound", err)
+	}
+}
+
+func TestNonDeterministicIgnoresCase(t *testing.T) {
+	ctx := NewContext()
+	if IsDeterministic(mustParse(t, "RAND() + 1"), ctx) {
+		t.Error("RAND() is reported deterministic")
+	}
+	if !IsDeterministic(mustParse(t, "SQRT(4) + 1"), ctx) {
+		t.Error("SQRT(4) is reported non-deterministic")
+	}
+}
+
+func TestCountFunction(t *testing.T) {
+	value := evalFormula(t, "count(1, 0, -2, 0.5)", nil)
+	if value != 3 {
+		t.Errorf("count(1, 0, -2, 0.5) = %v, want 3", value)
+	}
+	if value := evalFormula(t, "count()", nil); value != 0 || math.Signbit(value) {
+		t.Errorf("count() = %v, want 0", value)
+	}
+}
+
+// Один и тот же seed должен давать одну и ту же последовательность
+func TestRandSeededSequence(t *testing.T) {
+	node := mustParse(t, "rand() + randbetween(1, 100)")
+	sequence := func(seed int64) []float64 {
+		ctx := NewContext()
+		ctx.Rand = rand.New(rand.NewSource(seed))
+		values := make([]float64, 10)
+		for i := range values {
+			value, err := node.Evaluate(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values[i] = value
+		}
+		return values
+	}
+
+	first, second, other := sequence(42), sequence(42), sequence(7)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("seed 42 gave %v and %v", first, second)
+		}
+	}
+	same := true
+	for i := range first {
+		same = same && first[i] == other[i]
+	}
+	if same {
+		t.Errorf("seeds 42 and 7 gave the same sequence %v", first)
+	}
+}
+
+func TestRandBetweenBounds(t *testing.T) {
+	ctx := NewContext()
+	ctx.Rand = rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		value, err := mustParse(t, "randbetween(-2.5, 3.5)").Evaluate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value < -2 || value > 3 || value != math.Trunc(value) {
+			t.Fatalf("randbetween(-2.5, 3.5) = %v", value)
+		}
+	}
+
+	for _, formula := range []string{
+		"randbetween(-1e19, 1e19)",
+		"randbetween(0, 1e300)",
+		"randbetween(5, 1)",
+	} {
+		if _, err := mustParse(t, formula).Evaluate(ctx); err == nil {
+			t.Errorf("%s: expected error", formula)
+		}
+	}
+	randbetween := ctx.Functions["randbetween"]
+	for _, bounds := range [][]float64{{0, math.Inf(1)}, {math.Inf(-1), 0}, {math.NaN(), 1}} {
+		if _, err := randbetween(bounds); err == nil {
+			t.Errorf("randbetween%v: expected error", bounds)
+		}
+	}
+}
+
+func TestAngleMode(t *testing.T) {
+	ctx := NewContext()
+	ctx.AngleMode = AngleDegrees
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"sin(90)", 1},
+		{"cos(180)", -1},
+		{"asin(1)", 90},
+		{"deg2rad(180)", math.Pi},
+		{"rad2deg(PI / 2)", 90},
+	}
+	for _, tt := range tests {
+		got, err := mustParse(t, tt.formula).Evaluate(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.formula, err)
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s in degrees = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+
+	if got := evalFormula(t, "sin(90)", nil); math.Abs(got-math.Sin(90)) > 1e-12 {
+		t.Errorf("sin(90) in the default mode = %v, want %v (radians)", got, math.Sin(90))
+	}
+}
+
+// atan2(y, x) учитывает четверть точки и возвращает угол в единицах AngleMode
+func TestAtan2(t *testing.T) {
+	degrees := NewContext()
+	degrees.AngleMode = AngleDegrees
+	tests := []struct {
+		formula string
+		radians float64
+		degrees float64
+	}{
+		{"atan2(1, 1)", math.Pi / 4, 45},
+		{"atan2(1, -1)", 3 * math.Pi / 4, 135},
+		{"atan2(-1, -1)", -3 * math.Pi / 4, -135},
+		{"atan2(0, -1)", math.Pi, 180},
+		{"atan2(0, 0)", 0, 0},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, nil); math.Abs(got-tt.radians) > 1e-12 {
+			t.Errorf("%s in radians = %v, want %v", tt.formula, got, tt.radians)
+		}
+		got, err := mustParse(t, tt.formula).Evaluate(degrees)
+		if err != nil || math.Abs(got-tt.degrees) > 1e-9 {
+			t.Errorf("%s in degrees = %v, %v; want %v", tt.formula, got, err, tt.degrees)
+		}
+	}
+
+	for _, formula := range []string{"atan2(1)", "atan2()", "atan2(1, 2, 3)"} {
+		_, err := mustParse(t, formula).Evaluate(NewContext())
+		if err == nil || !strings.Contains(err.Error(), "atan2 requires exactly 2 arguments") {
+			t.Errorf("%s: error %v, want arity error", formula, err)
+		}
+	}
+}
+
+func TestPctChangeAndGrowth(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"pctchange(100, 125)", 0.25},
+		{"pctchange(200, 150)", -0.25},
+		{"pctchange(-50, -25)", -0.5},
+		{"growth(100, 121)", 0.21},
+		{"growth(100, 110, 121)", 0.1},
+		{"growth(100, 100, 100)", 0},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, nil); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+
+	for _, formula := range []string{"pctchange(0, 10)", "growth(0, 10)", "growth(10)", "growth(-10, 10)", "pctchange(1)"} {
+		if _, err := mustParse(t, formula).Evaluate(NewContext()); err == nil {
+			t.Errorf("%s: expected error", formula)
+		}
+	}
+}
+
+func TestRoundingFunctions(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"round(3.14159, 2)", 3.14},
+		{"round(2.5)", 3},
+		{"round(-2.675, 2)", -2.68},
+		{"round(1234, -2)", 1200},
+		{"floor(2.9)", 2},
+		{"floor(-2.1)", -3},
+		{"ceil(2.1)", 3},
+		{"ceil(-2.9)", -2},
+		{"trunc(2.9)", 2},
+		{"trunc(-2.9)", -2},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, nil); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+
+	for _, formula := range []string{
+		"round()", "round(1, 2, 3)", "round(1, 0.5)", "round(1, 16)",
+		"floor()", "floor(1, 2)", "ceil()", "ceil(1, 2)", "trunc()", "trunc(1, 2)",
+	} {
+		if _, err := mustParse(t, formula).Evaluate(NewContext()); err == nil {
+			t.Errorf("%s: expected error", formula)
+		}
+	}
+}
+
+func TestLogExpPow(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"log(exp(1))", 1},
+		{"log(8, 2)", 3},
+		{"log10(1000)", 3},
+		{"exp(0)", 1},
+		{"pow(2, 10)", 1024},
+		{"pow(-2, 3)", -8},
+		{"pow(4, 0.5)", 2},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, nil); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+
+	for _, formula := range []string{
+		"log(0)", "log(-1)", "log(8, 1)", "log(8, 0)", "log(-8, 2)", "log()",
+		"log10(0)", "log10(-5)", "log10(1, 2)",
+		"exp()", "exp(1, 2)",
+		"pow(-8, 0.5)", "pow(2)", "pow(1, 2, 3)",
+	} {
+		if _, err := mustParse(t, formula).Evaluate(NewContext()); err == nil {
+			t.Errorf("%s: expected error", formula)
+		}
+	}
+
+	// exp определена для всех чисел: переполнение дает +Inf, а не ошибку
+	if got := evalFormula(t, "exp(1000)", nil); !math.IsInf(got, 1) {
+		t.Errorf("exp(1000) = %v, want +Inf", got)
+	}
+	if got := evalFormula(t, "exp(-1000)", nil); got != 0 {
+		t.Errorf("exp(-1000) = %v, want 0", got)
+	}
+
+	ctx := NewContext()
+	ctx.MaxExponent = 100
+	if _, err := mustParse(t, "pow(2, 1000)").Evaluate(ctx); err == nil {
+		t.Error("pow(2, 1000) with MaxExponent 100: expected error")
+	}
+}
+
+// NaN обнаруживается только через isnan/isfinite: по IEEE 754 любое
+// сравнение с NaN, кроме !=, ложно, в том числе NaN = NaN
+func TestNaNDetection(t *testing.T) {
+	vars := map[string]float64{"n": math.NaN(), "inf": math.Inf(1), "x": 1}
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"isnan(n)", 1},
+		{"isnan(x)", 0},
+		{"isnan(inf)", 0},
+		{"isfinite(x)", 1},
+		{"isfinite(n)", 0},
+		{"isfinite(inf)", 0},
+		{"isfinite(-inf)", 0},
+		{"n = n", 0},
+		{"n != n", 1},
+		{"n <> n", 1},
+		{"n > 0", 0},
+		{"n <= 0", 0},
+		{"IF(isnan(n * 2), 1, 2)", 1},
+	}
+	for _, tt := range tests {
+		node := mustParse(t, tt.formula)
+		paths := evalPaths(t, node)
+		for name, eval := range paths {
+			ctx := NewContext()
+			ctx.Variables = vars
+			got, err := eval(ctx)
+			if err != nil {
+				t.Fatalf("%s(%q): %v", name, tt.formula, err)
+			}
+			if got != tt.want {
+				t.Errorf("%s(%q) = %v, want %v", name, tt.formula, got, tt.want)
+			}
+		}
+	}
+
+	ctx := NewContext()
+	if _, err := mustParse(t, "isnan(1, 2)").Evaluate(ctx); err == nil {
+		t.Error("isnan(1, 2): expected an argument count error")
+	}
+}
+
+func TestCmpAndSign(t *testing.T) {
+	tests := []struct {
+		a, b float64
+		want float64
+	}{
+		{1, 2, -1},
+		{2, 2, 0},
+		{3, 2, 1},
+		{-0.5, 0.5, -1},
+		{math.Inf(1), 1e308, 1},
+	}
+	for _, tt := range tests {
+		vars := map[string]float64{"a": tt.a, "b": tt.b}
+		if got := evalFormula(t, "cmp(a, b)", vars); got != tt.want {
+			t.Errorf("cmp(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := evalFormula(t, "sign(a - b)", vars); got != tt.want {
+			t.Errorf("sign(%v - %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"n": math.NaN()}
+	for _, formula := range []string{"cmp(n, 1)", "cmp(1, n)", "sign(n)", "cmp(1)", "sign(1, 2)"} {
+		if _, err := mustParse(t, formula).Evaluate(ctx); err == nil {
+			t.Errorf("%s: expected error", formula)
+		}
+	}
+}
+
+func TestApprox(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"approx(0.1 + 0.2, 0.3, 1e-9)", 1},
+		{"approx(1, 1.5, 0.5)", 1},
+		{"approx(1.5, 1, 0.5)", 1},
+		{"approx(1, 1.6, 0.5)", 0},
+		{"approx(1, 1, 0)", 1},
+		{"approx(1, 1.0001, 0)", 0},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, nil); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+
+	// Допуск действует только внутри approx и не меняет обычное сравнение
+	if got := evalFormula(t, "0.1 + 0.2 = 0.3", nil); got != 0 {
+		t.Errorf("0.1 + 0.2 = 0.3 = %v, want 0", got)
+	}
+
+	ctx := NewContext()
+	for _, formula := range []string{"approx(1, 1, -0.1)", "approx(1, 1)"} {
+		if _, err := mustParse(t, formula).Evaluate(ctx); err == nil {
+			t.Errorf("%s: expected error", formula)
+		}
+	}
+}
+
+func TestUnmarshalStrictElse(t *testing.T) {
+	data := []byte(`{"type": "conditional",
+		"condition": {"type": "comparison", "operator": ">", "left": {"type": "variable", "name": "a"}, "right": {"type": "variable", "name": "b"}},
+		"then": {"type": "literal", "value": 5}}`)
+	vars := map[string]float64{"a": 1, "b": 2}
+
+	// По умолчанию ложное условие без ELSE дает 0, как и при разборе текста
+	lenient, err := UnmarshalASTNode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewContext()
+	ctx.Variables = vars
+	if got, err := lenient.Evaluate(ctx); err != nil || got != 0 {
+		t.Errorf("lenient IF(a > b, 5) = %v, %v, want 0", got, err)
+	}
+
+	strict, err := UnmarshalASTNodeWithOptions(data, DecodeOptions{StrictElse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := evalPaths(t, strict)
+	for name, eval := range paths {
+		if _, err := eval(ctx); !errors.Is(err, ErrMissingElse) {
+			t.Errorf("%s strict IF(a > b, 5) error = %v, want ErrMissingElse", name, err)
+		}
+	}
+	ctx.Variables = map[string]float64{"a": 3, "b": 2}
+	if got, err := strict.Evaluate(ctx); err != nil || got != 5 {
+		t.Errorf("strict IF(a > b, 5) with a > b = %v, %v, want 5", got, err)
+	}
+
+	// Строгость можно задать и для отдельного узла
+	node, err := UnmarshalASTNode([]byte(`{"type": "conditional", "strict_else": true,
+		"condition": {"type": "literal", "value": 0}, "then": {"type": "literal", "value": 1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.Evaluate(NewContext()); !errors.Is(err, ErrMissingElse) {
+		t.Errorf("strict_else node error = %v, want ErrMissingElse", err)
+	}
+}
+
+func TestUnmarshalLiteralValue(t *testing.T) {
+	tests := []struct {
+		data string
+		want float64
+	}{
+		{`{"type":"literal","value":2.5}`, 2.5},
+		{`{"type":"literal","value":"2.5"}`, 2.5},
+		{`{"type":"literal","value":"-1e3"}`, -1000},
+		{`{"type":"literal","value":0}`, 0},
+	}
+	for _, tt := range tests {
+		node, err := UnmarshalASTNode([]byte(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.data, err)
+		}
+		if literal, ok := node.(*LiteralNode); !ok || literal.Value != tt.want {
+			t.Errorf("%s = %#v, want LiteralNode{%v}", tt.data, node, tt.want)
+		}
+	}
+
+	errorTests := []struct {
+		data string
+		want string
+	}{
+		{`{"type":"literal","value":"abc"}`, `literal value "abc" is not a number`},
+		{`{"type":"literal","value":true}`, "literal value must be a number or a numeric string, got true"},
+		{`{"type":"literal"}`, "literal node missing value"},
+		{`{"type":"literal","value":null}`, "literal node missing value"},
+	}
+	for _, tt := range errorTests {
+		_, err := UnmarshalASTNode([]byte(tt.data))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s: error %v, want %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalDisallowUnknownFields(t *testing.T) {
+	data := []byte(`{"type":"operation","oprator":"+","left":{"type":"literal","value":1},"right":{"type":"literal","value":2}}`)
+
+	// Без строгого режима опечатка теряется, и узел ломается по другой причине
+	if _, err := UnmarshalASTNode(data); err == nil || strings.Contains(err.Error(), "oprator") {
+		t.Errorf("lenient decode error = %v, want an error not naming the field", err)
+	}
+
+	strict := DecodeOptions{DisallowUnknownFields: true}
+	_, err := UnmarshalASTNodeWithOptions(data, strict)
+	if err == nil || !strings.Contains(err.Error(), `"oprator"`) {
+		t.Errorf("strict decode error = %v, want the unknown field named", err)
+	}
+
+	// Лишнее поле во вложенном узле тоже обнаруживается
+	nested := []byte(`{"type":"unary","operator":"-","operand":{"type":"variable","name":"a","value2":1}}`)
+	if _, err := UnmarshalASTNodeWithOptions(nested, strict); err == nil || !strings.Contains(err.Error(), `"value2"`) {
+		t.Errorf("strict decode of nested node error = %v, want the unknown field named", err)
+	}
+
+	valid := []byte(`{"type":"operation","operator":"+","left":{"type":"literal","value":1},"right":{"type":"variable","name":"a"}}`)
+	if _, err := UnmarshalASTNodeWithOptions(valid, strict); err != nil {
+		t.Errorf("strict decode of a valid node: %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package formula
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var (
+	ErrNotDifferentiable = errors.New("not differentiable")
+)
+
+// Derivative символьно дифференцирует формулу по переменной variable и
+// возвращает новое дерево. Поддерживаются +, -, *, /, ^ с постоянным
+// показателем или постоянным основанием, унарные операции, модуль и функции
+// sin, cos, tan (в радианах), exp, log (натуральный), sqrt и abs. Остальные
+// переменные считаются постоянными. Условия, сравнения и логические операции
+// не дифференцируются и приводят к ошибке ErrNotDifferentiable.
+// Результат упрощается: производная a*x^2 + b*x + c по x равна 2 * a * x + b.
+func Derivative(node ASTNode, variable string) (ASTNode, error) {
+	switch n := node.(type) {
+	case *LiteralNode:
+		return literal(0), nil
+
+	case *VariableNode:
+		if n.Name == variable {
+			return literal(1), nil
+		}
+		return literal(0), nil
+
+	case *UnaryNode:
+		d, err := Derivative(n.Operand, variable)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Operator {
+		case "+":
+			return d, nil
+		case "-":
+			return negate(d), nil
+		case "abs":
+			return product(call("sign", n.Operand), d), nil
+		}
+		return nil, fmt.Errorf("%w: unary operator %s", ErrNotDifferentiable, n.Operator)
+
+	case *OperationNode:
+		return derivativeOperation(n, variable)
+
+	case *FunctionNode:
+		return derivativeFunction(n, variable)
+
+	default:
+		return nil, fmt.Errorf("%w: %s node", ErrNotDifferentiable, node.GetType())
+	}
+}
+
+func derivativeOperation(n *OperationNode, variable string) (ASTNode, error) {
+	left, err := Derivative(n.Left, variable)
+	if err != nil {
+		return nil, err
+	}
+	right, err := Derivative(n.Right, variable)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.Operator {
+	case "+":
+		return sum(left, right), nil
+	case "-":
+		return difference(left, right), nil
+	case "*":
+		// (uv)' = u'v + uv'
+		return sum(product(left, n.Right), product(n.Left, right)), nil
+	case "/":
+		// (u/v)' = (u'v - uv') / v^2
+		return quotient(difference(product(left, n.Right), product(n.Left, right)), power(n.Right, literal(2))), nil
+	case "^", "**":
+		switch {
+		case isZero(right):
+			// (u^c)' = c * u^(c-1) * u'
+			return product(n.Right, power(n.Left, difference(n.Right, literal(1))), left), nil
+		case isZero(left):
+			// (a^v)' = a^v * log(a) * v'
+			return product(n, call("log", n.Left), right), nil
+		}
+		return nil, fmt.Errorf("%w: both base and exponent depend on '%s'", ErrNotDifferentiable, variable)
+	}
+	return nil, fmt.Errorf("%w: operator %s", ErrNotDifferentiable, n.Operator)
+}
+
+func derivativeFunction(n *FunctionNode, variable string) (ASTNode, error) {
+	if len(n.Args) != 1 {
+		return nil, fmt.Errorf("%w: function %s with %d arguments", ErrNotDifferentiable, n.Name, len(n.Args))
+	}
+	u := n.Args[0]
+	d, err := Derivative(u, variable)
+	if err != nil {
+		return nil, err
+	}
+
+	var outer ASTNode
+	switch strings.ToLower(n.Name) {
+	case "sin":
+		outer = call("cos", u)
+	case "cos":
+		outer = negate(call("sin", u))
+	case "tan":
+		outer = quotient(literal(1), power(call("cos", u), literal(2)))
+	case "exp":
+		outer = call("exp", u)
+	case "log", "ln":
+		outer = quotient(literal(1), u)
+	case "sqrt":
+		outer = quotient(literal(1), product(literal(2), call("sqrt", u)))
+	case "abs":
+		outer = call("sign", u)
+	default:
+		return nil, fmt.Errorf("%w: function %s", ErrNotDifferentiable, n.Name)
+	}
+	return product(outer, d), nil
+}
+
+// Конструкторы ниже сразу упрощают результат: сворачивают константы,
+// убирают слагаемые 0 и множители 1
+
+func literal(value float64) *LiteralNode {
+	return &LiteralNode{Value: value}
+}
+
+func call(name string, arg ASTNode) ASTNode {
+	return &FunctionNode{Name: name, Args: []ASTNode{arg}}
+}
+
+func literalValue(node ASTNode) (float64, bool) {
+	if l, ok := node.(*LiteralNode); ok {
+		return l.Value, true
+	}
+	return 0, false
+}
+
+func isZero(node ASTNode) bool {
+	value, ok := literalValue(node)
+	return ok && value == 0
+}
+
+func sum(left, right ASTNode) ASTNode {
+	l, lok := literalValue(left)
+	r, rok := literalValue(right)
+	switch {
+	case lok && rok:
+		return literal(l + r)
+	case lok && l == 0:
+		return right
+	case rok && r == 0:
+		return left
+	}
+	return &OperationNode{Operator: "+", Left: left, Right: right}
+}
+
+func difference(left, right ASTNode) ASTNode {
+	l, lok := literalValue(left)
+	r, rok := literalValue(right)
+	switch {
+	case lok && rok:
+		return literal(l - r)
+	case rok && r == 0:
+		return left
+	case lok && l == 0:
+		return negate(right)
+	}
+	return &OperationNode{Operator: "-", Left: left, Right: right}
+}
+
+func negate(node ASTNode) ASTNode {
+	if value, ok := literalValue(node); ok {
+		return literal(-value)
+	}
+	if u, ok := node.(*UnaryNode); ok && u.Operator == "-" {
+		return u.Operand
+	}
+	return &UnaryNode{Operator: "-", Operand: node}
+}
+
+// product перемножает множители, собирая числовые коэффициенты в начало:
+// product(a, product(2, x)) дает 2 * a * x
+func product(factors ...ASTNode) ASTNode {
+	coefficient := 1.0
+	var terms []ASTNode
+	var collect func(ASTNode)
+	collect = func(node ASTNode) {
+		if value, ok := literalValue(node); ok {
+			coefficient *= value
+			return
+		}
+		if op, ok := node.(*OperationNode); ok && op.Operator == "*" {
+			collect(op.Left)
+			collect(op.Right)
+			return
+		}
+		terms = append(terms, node)
+	}
+	for _, factor := range factors {
+		collect(factor)
+	}
+
+	if coefficient == 0 || len(terms) == 0 {
+		return literal(coefficient)
+	}
+
+	var result ASTNode
+	if coefficient != 1 {
+		result = literal(coefficient)
+	}
+	for _, term := range terms {
+		if result == nil {
+			result = term
+			continue
+		}
+		result = &OperationNode{Operator: "*", Left: result, Right: term}
+	}
+	return result
+}
+
+func quotient(left, right ASTNode) ASTNode {
+	l, lok := literalValue(left)
+	r, rok := literalValue(right)
+	switch {
+	case lok && l == 0:
+		return literal(0)
+	case rok && r == 1:
+		return left
+	case lok && rok && r != 0:
+		return literal(l / r)
+	}
+	return &OperationNode{Operator: "/", Left: left, Right: right}
+}
+
+func power(base, exponent ASTNode) ASTNode {
+	b, bok := literalValue(base)
+	e, eok := literalValue(exponent)
+	switch {
+	case eok && e == 0:
+		return literal(1)
+	case eok && e == 1:
+		return base
+	case bok && eok:
+		return literal(math.Pow(b, e))
+	}
+	return &OperationNode{Operator: "^", Left: base, Right: exponent}
+}
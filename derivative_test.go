@@ -0,0 +1,71 @@
+package formula
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestDerivativeQuadratic(t *testing.T) {
+	d, err := Derivative(mustParse(t, "a*x^2 + b*x + c"), "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(d); got != "2 * a * x + b" {
+		t.Errorf("d/dx a*x^2 + b*x + c = %s, want 2 * a * x + b", got)
+	}
+
+	// По другим переменным дифференцируется так же, остальные считаются постоянными
+	d, err = Derivative(mustParse(t, "a*x^2 + b*x + c"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(d); got != "x ^ 2" {
+		t.Errorf("d/da a*x^2 + b*x + c = %s, want x ^ 2", got)
+	}
+}
+
+// Производная сверяется с разностной: (f(x+h) - f(x-h)) / 2h
+func TestDerivativeMatchesFiniteDifference(t *testing.T) {
+	formulas := []string{
+		"a*x^2 + b*x + c", "sin(x) * exp(x)", "x / (x + 1)", "2 ^ x", "sqrt(x)",
+		"log(x) - cos(x)", "-x^3", "|x - 5|", "tan(x / 4) + y", "(x + 1) ^ -2",
+	}
+	vars := map[string]float64{"a": 1.5, "b": -2, "c": 4, "y": 9}
+	const h = 1e-6
+
+	for _, formula := range formulas {
+		node := mustParse(t, formula)
+		d, err := Derivative(node, "x")
+		if err != nil {
+			t.Fatalf("Derivative(%q): %v", formula, err)
+		}
+		for _, x := range []float64{0.5, 1.7, 3} {
+			at := func(value float64) float64 {
+				vars["x"] = value
+				return evalFormula(t, fmt.Sprint(node), vars)
+			}
+			want := (at(x+h) - at(x-h)) / (2 * h)
+			vars["x"] = x
+			got := evalFormula(t, fmt.Sprint(d), vars)
+			if math.Abs(got-want) > 1e-5*math.Max(1, math.Abs(want)) {
+				t.Errorf("d/dx %s at x=%v = %v (%s), want %v", formula, x, got, d, want)
+			}
+		}
+	}
+}
+
+func TestDerivativeErrors(t *testing.T) {
+	for _, formula := range []string{"IF(x > 1, x, 0)", "x > 1", "x > 1 AND x < 2", "max(x, 1)", "x ^ x"} {
+		if _, err := Derivative(mustParse(t, formula), "x"); !errors.Is(err, ErrNotDifferentiable) {
+			t.Errorf("Derivative(%q) error = %v, want ErrNotDifferentiable", formula, err)
+		}
+	}
+
+	// Постоянная относительно x формула дифференцируется без ошибки
+	d, err := Derivative(mustParse(t, "y * 3"), "x")
+	if err != nil || fmt.Sprint(d) != "0" {
+		t.Errorf("d/dx y * 3 = %v, %v, want 0", d, err)
+	}
+}
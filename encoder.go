@@ -0,0 +1,159 @@
+package formula
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// MarshalASTNode сериализует дерево в JSON того же формата, который читает
+// UnmarshalASTNode: {"type": "operation", "operator": "+", "left": ..., "right": ...}.
+// Дерево, полученное разбором текста, можно сохранить и затем восстановить.
+// NaN и бесконечности в литералах не представимы в JSON и дают ошибку.
+func MarshalASTNode(node ASTNode) ([]byte, error) {
+	data, err := encodeNode(node)
+	if err != nil {
+		return nil, err
+	}
+	return marshalUnescaped(data)
+}
+
+// marshalUnescaped сериализует значение как json.Marshal, но оставляет
+// операторы > и < как есть, без экранирования \u003e
+func marshalUnescaped(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func encodeNode(node ASTNode) (*NodeData, error) {
+	if node == nil {
+		return nil, fmt.Errorf("cannot marshal nil node")
+	}
+	data := &NodeData{Type: node.GetType()}
+
+	switch n := node.(type) {
+	case *LiteralNode:
+		if math.IsNaN(n.Value) || math.IsInf(n.Value, 0) {
+			return nil, fmt.Errorf("literal value %v cannot be represented in JSON", n.Value)
+		}
+		data.Value = json.RawMessage(strconv.FormatFloat(n.Value, 'g', -1, 64))
+
+	case *VariableNode:
+		data.Name = &n.Name
+
+	case *OperationNode:
+		return encodeBinary(data, n.Operator, n.Left, n.Right)
+
+	case *ComparisonNode:
+		return encodeBinary(data, n.Operator, n.Left, n.Right)
+
+	case *LogicalNode:
+		if n.Keyword != "" {
+			data.Keyword = &n.Keyword
+		}
+		return encodeBinary(data, n.Operator, n.Left, n.Right)
+
+	case *UnaryNode:
+		data.Operator = &n.Operator
+		operand, err := encodeChild(n.Operand)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding operand: %v", err)
+		}
+		data.Operand = operand
+		if n.Keyword != "" {
+			data.Keyword = &n.Keyword
+		}
+
+	case *ConditionalNode:
+		var err error
+		if data.Condition, err = encodeChild(n.Condition); err != nil {
+			return nil, fmt.Errorf("error encoding condition: %v", err)
+		}
+		if data.Then, err = encodeChild(n.Then); err != nil {
+			return nil, fmt.Errorf("error encoding then branch: %v", err)
+		}
+		if n.Else != nil {
+			if data.Else, err = encodeChild(n.Else); err != nil {
+				return nil, fmt.Errorf("error encoding else branch: %v", err)
+			}
+		} else if n.StrictElse {
+			data.StrictElse = &n.StrictElse
+		}
+		if n.Keyword != "" {
+			data.Keyword = &n.Keyword
+		}
+
+	case *SwitchNode:
+		var err error
+		if data.Subject, err = encodeChild(n.Subject); err != nil {
+			return nil, fmt.Errorf("error encoding subject: %v", err)
+		}
+		data.Cases = make([]json.RawMessage, len(n.Cases))
+		data.Values = make([]json.RawMessage, len(n.Values))
+		for i := range n.Cases {
+			if data.Cases[i], err = encodeChild(n.Cases[i]); err != nil {
+				return nil, fmt.Errorf("error encoding case %d: %v", i, err)
+			}
+			if data.Values[i], err = encodeChild(n.Values[i]); err != nil {
+				return nil, fmt.Errorf("error encoding value %d: %v", i, err)
+			}
+		}
+		if n.Default != nil {
+			if data.Default, err = encodeChild(n.Default); err != nil {
+				return nil, fmt.Errorf("error encoding default: %v", err)
+			}
+		}
+
+	case *FunctionNode:
+		data.Name = &n.Name
+		data.Args = make([]json.RawMessage, len(n.Args))
+		for i, arg := range n.Args {
+			encoded, err := encodeChild(arg)
+			if err != nil {
+				return nil, fmt.Errorf("error encoding function argument %d: %v", i, err)
+			}
+			data.Args[i] = encoded
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported node type: %T", node)
+	}
+	return data, nil
+}
+
+func encodeBinary(data *NodeData, operator string, left, right ASTNode) (*NodeData, error) {
+	data.Operator = &operator
+
+	var err error
+	if data.Left, err = encodeChild(left); err != nil {
+		return nil, fmt.Errorf("error encoding left operand: %v", err)
+	}
+	if data.Right, err = encodeChild(right); err != nil {
+		return nil, fmt.Errorf("error encoding right operand: %v", err)
+	}
+	return data, nil
+}
+
+func encodeChild(node ASTNode) (json.RawMessage, error) {
+	return MarshalASTNode(node)
+}
+
+// Методы MarshalJSON позволяют сериализовать узлы через json.Marshal
+// в формате UnmarshalASTNode
+
+func (n *LiteralNode) MarshalJSON() ([]byte, error)     { return MarshalASTNode(n) }
+func (n *VariableNode) MarshalJSON() ([]byte, error)    { return MarshalASTNode(n) }
+func (n *OperationNode) MarshalJSON() ([]byte, error)   { return MarshalASTNode(n) }
+func (n *ComparisonNode) MarshalJSON() ([]byte, error)  { return MarshalASTNode(n) }
+func (n *LogicalNode) MarshalJSON() ([]byte, error)     { return MarshalASTNode(n) }
+func (n *ConditionalNode) MarshalJSON() ([]byte, error) { return MarshalASTNode(n) }
+func (n *SwitchNode) MarshalJSON() ([]byte, error)      { return MarshalASTNode(n) }
+func (n *UnaryNode) MarshalJSON() ([]byte, error)       { return MarshalASTNode(n) }
+func (n *FunctionNode) MarshalJSON() ([]byte, error)    { return MarshalASTNode(n) }
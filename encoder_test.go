@@ -0,0 +1,83 @@
+package formula
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalASTNode(t *testing.T) {
+	data, err := MarshalASTNode(mustParse(t, "IF(x>1, 2, 3)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"conditional",` +
+		`"condition":{"type":"comparison","operator":">","left":{"type":"variable","name":"x"},"right":{"type":"literal","value":1}},` +
+		`"then":{"type":"literal","value":2},"else":{"type":"literal","value":3},"keyword":"IF"}`
+	if string(data) != want {
+		t.Errorf("MarshalASTNode =\n%s\nwant\n%s", data, want)
+	}
+
+	// json.Marshal использует методы MarshalJSON узлов, но экранирует '>'
+	viaJSON, err := json.Marshal(mustParse(t, "IF(x>1, 2, 3)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, expected interface{}
+	if err := json.Unmarshal(viaJSON, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("json.Marshal =\n%s\nwant\n%s", viaJSON, want)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	formulas := []string{
+		"IF(x>1, 2, 3)", "IF(x > 1, 2)", "a + b * -c", "|a - b| // 2 % 3 ^ 0.5",
+		"NOT a OR b AND c", "a <> b", "max(a, sqrt(b), 1e-9) - min(a)",
+		"ЕСЛИ a > b И c ТОГДА 1 ИНАЧЕ 2", "`total amount` * 0.1",
+		"SWITCH(a, 1, 10, 2, 20)", "SWITCH(a + b, 1, 10, 0)",
+	}
+	for _, formula := range formulas {
+		original := mustParse(t, formula)
+		data, err := MarshalASTNode(original)
+		if err != nil {
+			t.Fatalf("MarshalASTNode(%q): %v", formula, err)
+		}
+		decoded, err := UnmarshalASTNode(data)
+		if err != nil {
+			t.Fatalf("UnmarshalASTNode(%s): %v", data, err)
+		}
+		if !reflect.DeepEqual(decoded, original) {
+			t.Errorf("%s: decoded tree %s differs from %s", formula, decoded, original)
+		}
+	}
+
+	strict := mustParse(t, "IF(x > 1, 2)")
+	strict.(*ConditionalNode).StrictElse = true
+	data, err := MarshalASTNode(strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := UnmarshalASTNode(data); err != nil || !decoded.(*ConditionalNode).StrictElse {
+		t.Errorf("StrictElse lost in round trip: %s", data)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	for _, node := range []ASTNode{
+		nil,
+		&LiteralNode{Value: math.NaN()},
+		&OperationNode{Operator: "+", Left: &LiteralNode{Value: 1}, Right: &LiteralNode{Value: math.Inf(1)}},
+		&OperationNode{Operator: "+", Left: &LiteralNode{Value: 1}},
+	} {
+		if data, err := MarshalASTNode(node); err == nil {
+			t.Errorf("MarshalASTNode(%#v) = %s, want error", node, data)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package formula
+
+import (
+	"errors"
+	"strings"
+)
+
+// FormatError форматирует ошибку для вывода в терминал: текст ошибки,
+// формула и строка с символом '^' под позицией ошибки, как у компилятора Go:
+//
+//	validation error at position 4: недопустимая последовательность операторов
+//	    a + */ b
+//	        ^
+//
+// Позиция берется из ошибки и считается в рунах исходной формулы. Если ошибка
+// не содержит позиции, возвращается текст ошибки и формула без указателя.
+func FormatError(formula string, err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(err.Error())
+	b.WriteString("\n    ")
+	b.WriteString(formula)
+
+	pos, ok := errorPosition(err)
+	if !ok {
+		return b.String()
+	}
+
+	runes := []rune(formula)
+	if pos > len(runes) {
+		pos = len(runes)
+	}
+
+	b.WriteString("\n    ")
+	// Табуляции переносятся в строку указателя, чтобы '^' оказался под нужным символом
+	for _, r := range runes[:pos] {
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	b.WriteRune('^')
+
+	return b.String()
+}
+
+// errorPosition извлекает позицию ошибки валидации или разбора в рунах
+func errorPosition(err error) (int, bool) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) && validationErr.Position >= 0 {
+		return validationErr.Position, true
+	}
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.Pos, true
+	}
+	return 0, false
+}
@@ -0,0 +1,48 @@
+package formula
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFormatErrorCaret(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    string
+	}{
+		{"a + * 2", "parse error at position 4: unexpected operator '*'\n    a + * 2\n        ^"},
+		// Позиция считается в рунах, поэтому кириллица не сдвигает указатель
+		{"цена + * 2", "parse error at position 7: unexpected operator '*'\n    цена + * 2\n           ^"},
+		{"(a + b", "parse error at position 6: expected ')' but got end of formula\n    (a + b\n          ^"},
+		// Табуляция переносится в строку указателя
+		{"a +\t* 2", "parse error at position 4: unexpected operator '*'\n    a +\t* 2\n       \t^"},
+	}
+	for _, tt := range tests {
+		_, err := NewSimpleParser().ParseString(tt.formula)
+		if err == nil {
+			t.Fatalf("%q: expected parse error", tt.formula)
+		}
+		if got := FormatError(tt.formula, err); got != tt.want {
+			t.Errorf("FormatError(%q) =\n%s\nwant\n%s", tt.formula, got, tt.want)
+		}
+	}
+}
+
+func TestFormatErrorValidation(t *testing.T) {
+	result := NewFormulaValidator().ValidateFormula("цена $ 2")
+	if len(result.Errors) == 0 {
+		t.Fatal("expected validation errors")
+	}
+	want := "validation error at position 5: недопустимый символ '$'\n    цена $ 2\n         ^"
+	if got := FormatError("цена $ 2", &result.Errors[0]); got != want {
+		t.Errorf("FormatError =\n%s\nwant\n%s", got, want)
+	}
+
+	// Ошибки без позиции выводятся без указателя
+	if got, want := FormatError("a / 0", errors.New("division by zero")), "division by zero\n    a / 0"; got != want {
+		t.Errorf("FormatError without position = %q, want %q", got, want)
+	}
+	if got := FormatError("a", nil); got != "" {
+		t.Errorf("FormatError(nil) = %q, want empty", got)
+	}
+}
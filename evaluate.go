@@ -0,0 +1,261 @@
+package formula
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrBooleanResult возвращается Evaluate, если формула дает логическое значение,
+// а контекст требует числовой результат
+var ErrBooleanResult = errors.New("formula result is boolean, numeric result required")
+
+// ErrMaxDepth возвращается при вычислении, если глубина дерева превышает Context.MaxDepth
+var ErrMaxDepth = errors.New("maximum evaluation depth exceeded")
+
+// Evaluate вычисляет формулу с учетом проверок контекста, которых нет в
+// node.Evaluate (RequireNumericResult), и записывает диапазоны значений в
+// ctx.Ranges. Для вычисления без этих проверок достаточно node.Evaluate(ctx).
+func Evaluate(node ASTNode, ctx *Context) (float64, error) {
+	if ctx != nil && ctx.RequireNumericResult && isBooleanResult(node) {
+		return 0, fmt.Errorf("%w: %s", ErrBooleanResult, StringLocalized(node, LanguageEnglish))
+	}
+	if ctx != nil && ctx.Ranges != nil {
+		return ctx.Ranges.evaluate(node, ctx)
+	}
+	return node.Evaluate(ctx)
+}
+
+// EvaluateWithContext вычисляет формулу так же, как Evaluate, но прерывает
+// вычисление, когда stdctx отменен или истек его срок: тогда возвращается
+// stdctx.Err(). Отмена проверяется перед каждым вызовом функции и
+// периодически при обходе дерева. Функция, которая уже выполняется, не
+// прерывается, поэтому долгие функции должны сами ограничивать свое время.
+// ec не изменяется и может быть nil.
+func EvaluateWithContext(stdctx context.Context, node ASTNode, ec *Context) (float64, error) {
+	if err := stdctx.Err(); err != nil {
+		return 0, err
+	}
+
+	cancellable := Context{}
+	if ec != nil {
+		cancellable = *ec
+	}
+	cancellable.cancel = &cancellation{ctx: stdctx}
+	return Evaluate(node, &cancellable)
+}
+
+// cancelCheckInterval - число узлов между проверками отмены
+const cancelCheckInterval = 64
+
+// cancellation отслеживает отмену вычисления, запущенного EvaluateWithContext
+type cancellation struct {
+	ctx   context.Context
+	steps int
+}
+
+// interrupted возвращает ошибку контекста, если вычисление отменено. Без
+// force контекст проверяется только на каждом cancelCheckInterval-м узле.
+func (c *Context) interrupted(force bool) error {
+	if c == nil || c.cancel == nil {
+		return nil
+	}
+	c.cancel.steps++
+	if !force && c.cancel.steps%cancelCheckInterval != 0 {
+		return nil
+	}
+	return c.cancel.ctx.Err()
+}
+
+// enter вызывается узлом с дочерними узлами перед их вычислением. При
+// MaxDepth > 0 первый такой узел проверяет глубину своего дерева и
+// возвращает копию контекста с отметкой depthChecked, с которой вычисляется
+// остальное дерево: сам контекст вызывающего кода не изменяется, и его можно
+// использовать одновременно из нескольких горутин. Без ограничения и в уже
+// проверенном дереве возвращается c.
+func (c *Context) enter(node ASTNode) (*Context, error) {
+	if c == nil || c.MaxDepth <= 0 || c.depthChecked {
+		return c, nil
+	}
+	return c.enterDepth(treeDepth(node))
+}
+
+// enterDepth работает как enter для дерева известной глубины depth. Его
+// используют обертки над деревом (IncrementalEvaluator, RangeCollector):
+// глубина проверяется по исходному дереву, так как children не видит узлов
+// под обертками.
+func (c *Context) enterDepth(depth int) (*Context, error) {
+	if c == nil || c.MaxDepth <= 0 || c.depthChecked {
+		return c, nil
+	}
+	if err := c.checkDepth(depth); err != nil {
+		return nil, err
+	}
+	checked := *c
+	checked.depthChecked = true
+	return &checked, nil
+}
+
+// checkDepth возвращает ErrMaxDepth, если дерево глубины depth выходит за
+// Context.MaxDepth. В ошибке указывается только предел, чтобы текст совпадал
+// для всех способов вычисления.
+func (c *Context) checkDepth(depth int) error {
+	if c == nil || c.MaxDepth <= 0 || depth <= c.MaxDepth {
+		return nil
+	}
+	return fmt.Errorf("%w: limit %d", ErrMaxDepth, c.MaxDepth)
+}
+
+// treeDepth возвращает глубину дерева (лист имеет глубину 1). Обход идет
+// без рекурсии, поэтому сам не переполняет стек на глубоких деревьях.
+func treeDepth(node ASTNode) int {
+	type entry struct {
+		node  ASTNode
+		depth int
+	}
+	maxDepth := 0
+	stack := []entry{{node, 1}}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if e.node == nil {
+			continue
+		}
+		if e.depth > maxDepth {
+			maxDepth = e.depth
+		}
+		for _, child := range children(e.node) {
+			stack = append(stack, entry{child, e.depth + 1})
+		}
+	}
+	return maxDepth
+}
+
+// isBooleanResult сообщает, что узел всегда дает логическое значение 0 или 1
+func isBooleanResult(node ASTNode) bool {
+	switch n := node.(type) {
+	case *ComparisonNode, *LogicalNode:
+		return true
+	case *UnaryNode:
+		return n.Operator == "NOT"
+	case *ConditionalNode:
+		// Без ELSE ложное условие дает 0, что тоже логическое значение
+		return isBooleanResult(n.Then) && (n.Else == nil || isBooleanResult(n.Else))
+	case *SwitchNode:
+		for _, value := range n.Values {
+			if !isBooleanResult(value) {
+				return false
+			}
+		}
+		return n.Default == nil || isBooleanResult(n.Default)
+	default:
+		return false
+	}
+}
+
+// EvaluateBatch вычисляет формулу для каждого контекста из набора.
+// Ошибки отдельных вычислений не прерывают обработку: они собираются через
+// errors.Join, поэтому errors.Is(err, ErrNotFound) и errors.As работают,
+// если такая ошибка встретилась хотя бы в одном контексте.
+// Для контекстов с ошибкой соответствующий результат равен 0.
+func EvaluateBatch(node ASTNode, contexts []*Context) ([]float64, error) {
+	results := make([]float64, len(contexts))
+	var errs []error
+
+	for i, ctx := range contexts {
+		value, err := node.Evaluate(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("context %d: %w", i, err))
+			continue
+		}
+		results[i] = value
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// EvalOutcome содержит сведения о том, как был получен результат вычисления
+type EvalOutcome struct {
+	// ImplicitDefault равен true, если хотя бы одно условие без ветки ELSE
+	// оказалось ложным и вместо значения был подставлен 0. Явная ветка
+	// ELSE, возвращающая 0, этот флаг не устанавливает.
+	ImplicitDefault bool
+
+	// Variables - значения переменных формулы, найденных в контексте
+	Variables map[string]float64
+
+	// Branches - выбранные ветки условий в порядке вычисления
+	Branches []BranchTaken
+
+	// Warnings - предупреждения о результате, например о неявном 0
+	Warnings []string
+}
+
+// BranchTaken описывает ветку, выбранную в условном выражении
+type BranchTaken struct {
+	Condition string `json:"condition"` // условие в инфиксной записи
+	Branch    string `json:"branch"`    // "then", "else" или "none"
+}
+
+// EvaluateWithOutcome вычисляет формулу и сообщает, использовался ли неявный
+// результат 0 условия без ELSE, чтобы вызывающий код мог предупредить пользователя,
+// а также какие переменные и ветки участвовали в вычислении
+func EvaluateWithOutcome(node ASTNode, ctx *Context) (float64, EvalOutcome, error) {
+	result, trace, err := EvaluateWithTrace(node, ctx)
+
+	outcome := EvalOutcome{Variables: make(map[string]float64)}
+	for _, d := range trace.Decisions {
+		condition := formatter{}.format(d.Node.Condition)
+		outcome.Branches = append(outcome.Branches, BranchTaken{Condition: condition, Branch: d.Branch})
+		if d.Branch == "none" {
+			outcome.ImplicitDefault = true
+			outcome.Warnings = append(outcome.Warnings,
+				fmt.Sprintf("условие %s ложно, ветки ELSE нет: использовано значение 0", condition))
+		}
+	}
+	for _, name := range CollectVariables(node) {
+		if value, ok, lookupErr := ctx.lookupVariable(name); ok && lookupErr == nil {
+			outcome.Variables[name] = value
+		}
+	}
+	return result, outcome, err
+}
+
+// ResultJSON формирует самодокументированный ответ API с результатом
+// вычисления и сведениями из EvaluateWithOutcome:
+//
+//	{"result": 4, "variables": {"score": 85}, "branches": [{"condition": "score >= 90", "branch": "else"}, ...],
+//	 "warnings": [], "implicit_default": false}
+//
+// NaN и бесконечность не представимы в JSON и дают ошибку.
+func ResultJSON(value float64, outcome EvalOutcome) ([]byte, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("result %v cannot be represented in JSON", value)
+	}
+
+	response := struct {
+		Result          float64            `json:"result"`
+		Variables       map[string]float64 `json:"variables"`
+		Branches        []BranchTaken      `json:"branches"`
+		Warnings        []string           `json:"warnings"`
+		ImplicitDefault bool               `json:"implicit_default"`
+	}{
+		Result:          value,
+		Variables:       outcome.Variables,
+		Branches:        outcome.Branches,
+		Warnings:        outcome.Warnings,
+		ImplicitDefault: outcome.ImplicitDefault,
+	}
+	// Пустые списки выводятся как [] и {}, а не null
+	if response.Variables == nil {
+		response.Variables = map[string]float64{}
+	}
+	if response.Branches == nil {
+		response.Branches = []BranchTaken{}
+	}
+	if response.Warnings == nil {
+		response.Warnings = []string{}
+	}
+	return marshalUnescaped(response)
+}
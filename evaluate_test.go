@@ -0,0 +1,449 @@
+package formula
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deepSum строит дерево 1 + (1 + (... + 1)) глубины depth + 1
+func deepSum(depth int) ASTNode {
+	var node ASTNode = &LiteralNode{Value: 1}
+	for i := 0; i < depth; i++ {
+		node = &OperationNode{Operator: "+", Left: &LiteralNode{Value: 1}, Right: node}
+	}
+	return node
+}
+
+func TestMaxDepth(t *testing.T) {
+	node := deepSum(5000)
+	paths := evalPaths(t, node)
+	paths["formula.Evaluate"] = func(ctx *Context) (float64, error) { return Evaluate(node, ctx) }
+	paths["EvaluateWithTrace"] = func(ctx *Context) (float64, error) {
+		value, _, err := EvaluateWithTrace(node, ctx)
+		return value, err
+	}
+
+	for name, eval := range paths {
+		ctx := NewContext()
+		ctx.MaxDepth = 100
+		if _, err := eval(ctx); !errors.Is(err, ErrMaxDepth) {
+			t.Errorf("%s with MaxDepth 100: error %v, want ErrMaxDepth", name, err)
+		}
+
+		ctx.MaxDepth = 0
+		if got, err := eval(ctx); err != nil || got != 5001 {
+			t.Errorf("%s without a limit = %v, %v; want 5001", name, got, err)
+		}
+	}
+}
+
+// Дерево глубины ровно MaxDepth вычисляется, на единицу глубже - нет
+func TestMaxDepthBoundary(t *testing.T) {
+	for _, formula := range []string{"1 + 2 * (3 - x)", "max(1, abs(x) + 2)", "IF(x > 1, -x, rand())"} {
+		node := mustParse(t, formula)
+		depth := treeDepth(node)
+		for name, eval := range evalPaths(t, node) {
+			ctx := NewContext()
+			ctx.Variables = map[string]float64{"x": 5}
+			ctx.MaxDepth = depth
+			if _, err := eval(ctx); err != nil {
+				t.Errorf("%s: %s with MaxDepth %d: %v", name, formula, depth, err)
+			}
+			ctx.MaxDepth = depth - 1
+			if _, err := eval(ctx); !errors.Is(err, ErrMaxDepth) {
+				t.Errorf("%s: %s with MaxDepth %d: error %v, want ErrMaxDepth", name, formula, depth-1, err)
+			}
+		}
+	}
+}
+
+// Глубина проверяется по всему дереву, а не по выбранным веткам: формула,
+// которая при этих значениях не доходит до глубоких веток, отвергается так же,
+// как в Compile и байт-коде
+func TestMaxDepthCountsUntakenBranches(t *testing.T) {
+	node := mustParse(t, "IFS(a > 1, 1, b > 1, 2, TRUE, 3)")
+	for name, eval := range evalPaths(t, node) {
+		ctx := NewContext()
+		ctx.Variables = map[string]float64{"a": 2, "b": 0}
+		ctx.MaxDepth = 3
+		if got, err := eval(ctx); !errors.Is(err, ErrMaxDepth) {
+			t.Errorf("%s with MaxDepth 3 = %v, %v; want ErrMaxDepth", name, got, err)
+		}
+		ctx.MaxDepth = treeDepth(node)
+		if got, err := eval(ctx); err != nil || got != 1 {
+			t.Errorf("%s with MaxDepth %d = %v, %v; want 1", name, ctx.MaxDepth, got, err)
+		}
+	}
+}
+
+// Вычисление с MaxDepth только читает контекст, поэтому один контекст можно
+// использовать из нескольких горутин (проверяется также go test -race)
+func TestMaxDepthSharedContext(t *testing.T) {
+	node := deepSum(50)
+	ctx := NewContext()
+	ctx.MaxDepth = treeDepth(node)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := node.Evaluate(ctx); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent Evaluate with a shared context: %v", err)
+	}
+}
+
+// quotaError - ошибка пользовательской функции для проверки errors.As
+type quotaError struct{ limit float64 }
+
+func (e *quotaError) Error() string { return "quota exceeded" }
+
+func TestEvaluateBatchJoinsErrors(t *testing.T) {
+	node := mustParse(t, "quota(a) + b")
+	newContext := func(vars map[string]float64) *Context {
+		ctx := NewContext()
+		ctx.Functions["quota"] = func(args []float64) (float64, error) {
+			if args[0] > 10 {
+				return 0, &quotaError{limit: 10}
+			}
+			return args[0], nil
+		}
+		ctx.Variables = vars
+		return ctx
+	}
+
+	results, err := EvaluateBatch(node, []*Context{
+		newContext(map[string]float64{"a": 1, "b": 2}),
+		newContext(map[string]float64{"a": 1}),
+		newContext(map[string]float64{"a": 20, "b": 2}),
+	})
+	if len(results) != 3 || results[0] != 3 || results[1] != 0 || results[2] != 0 {
+		t.Errorf("results = %v, want [3 0 0]", results)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("errors.Is(%v, ErrNotFound) = false", err)
+	}
+	var quota *quotaError
+	if !errors.As(err, &quota) || quota.limit != 10 {
+		t.Errorf("errors.As(%v, *quotaError) failed", err)
+	}
+
+	if _, err := EvaluateBatch(node, []*Context{newContext(map[string]float64{"a": 1, "b": 1})}); err != nil {
+		t.Errorf("batch without failures: %v", err)
+	}
+}
+
+func TestEvaluateWithOutcomeImplicitDefault(t *testing.T) {
+	tests := []struct {
+		formula  string
+		a, b     float64
+		want     float64
+		implicit bool
+	}{
+		{"IF(a > b, 5)", 1, 2, 0, true},
+		{"IF(a > b, 5)", 3, 2, 5, false},
+		// Явная ветка ELSE, возвращающая 0, флаг не устанавливает
+		{"IF(a > b, 5, 0)", 1, 2, 0, false},
+		{"IF(a > b, 5, IF(a = b, 1))", 1, 2, 0, true},
+	}
+	for _, tt := range tests {
+		ctx := NewContext()
+		ctx.Variables = map[string]float64{"a": tt.a, "b": tt.b}
+		got, outcome, err := EvaluateWithOutcome(mustParse(t, tt.formula), ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.formula, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s with a=%v, b=%v = %v, want %v", tt.formula, tt.a, tt.b, got, tt.want)
+		}
+		if outcome.ImplicitDefault != tt.implicit {
+			t.Errorf("%s with a=%v, b=%v: ImplicitDefault = %v, want %v", tt.formula, tt.a, tt.b, outcome.ImplicitDefault, tt.implicit)
+		}
+		if tt.implicit && len(outcome.Warnings) == 0 {
+			t.Errorf("%s: expected a warning about the implicit 0", tt.formula)
+		}
+		if !tt.implicit && len(outcome.Warnings) != 0 {
+			t.Errorf("%s: unexpected warnings %v", tt.formula, outcome.Warnings)
+		}
+	}
+}
+
+func TestRequireNumericResult(t *testing.T) {
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"a": 3, "b": 2}
+	ctx.RequireNumericResult = true
+
+	for _, formula := range []string{"a > b", "a = b", "a > 1 AND b > 1", "NOT a", "IF(a > b, a > 1, b > 1)"} {
+		if _, err := Evaluate(mustParse(t, formula), ctx); !errors.Is(err, ErrBooleanResult) {
+			t.Errorf("%s: error %v, want ErrBooleanResult", formula, err)
+		}
+	}
+
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"a + b", 5},
+		{"IF(a > b, a, b)", 3},
+		{"(a > b) * 10", 10},
+		{"IF(a > b, a > 1, 7)", 1},
+	}
+	for _, tt := range tests {
+		if got, err := Evaluate(mustParse(t, tt.formula), ctx); err != nil || got != tt.want {
+			t.Errorf("%s = %v, %v, want %v", tt.formula, got, err, tt.want)
+		}
+	}
+
+	// Без флага сравнение по-прежнему дает 0 или 1
+	ctx.RequireNumericResult = false
+	if got, err := Evaluate(mustParse(t, "a > b"), ctx); err != nil || got != 1 {
+		t.Errorf("a > b without RequireNumericResult = %v, %v, want 1", got, err)
+	}
+}
+
+// Строк в формулах нет, поэтому фильтр region = "EU" записан через
+// числовой код региона; region в контексте отсутствует
+func TestLenientComparisons(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"region = 1", 0},
+		{"region != 1", 0},
+		{"region + 1 > 0", 0},
+		{"region = 1 OR vip > 0", 1},
+		{"NOT (region = 1)", 1},
+		{"IF(region = 1, price * 0.5, price)", 100},
+		{"max(region, 1) > 0", 0},
+	}
+	errorTests := []string{"region * 2", "IF(region = 1, 1, region)", "sqrt(region)"}
+
+	vars := map[string]float64{"price": 100, "vip": 1}
+	for _, tt := range tests {
+		node := mustParse(t, tt.formula)
+		paths := evalPaths(t, node)
+		for name, eval := range paths {
+			ctx := NewContext()
+			ctx.Variables = vars
+			ctx.LenientComparisons = true
+			if got, err := eval(ctx); err != nil || got != tt.want {
+				t.Errorf("%s(%q) = %v, %v, want %v", name, tt.formula, got, err, tt.want)
+			}
+
+			// Без LenientComparisons отсутствующая переменная - ошибка
+			ctx.LenientComparisons = false
+			if _, err := eval(ctx); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s(%q) without LenientComparisons: error %v, want ErrNotFound", name, tt.formula, err)
+			}
+		}
+	}
+
+	// В арифметике вне сравнений отсутствующая переменная по-прежнему ошибка
+	for _, formula := range errorTests {
+		ctx := NewContext()
+		ctx.Variables = vars
+		ctx.LenientComparisons = true
+		if _, err := mustParse(t, formula).Evaluate(ctx); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: error %v, want ErrNotFound", formula, err)
+		}
+	}
+}
+
+func TestVariableLookupCustomization(t *testing.T) {
+	node := mustParse(t, "salary * 2")
+
+	// По умолчанию имена различаются по регистру
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"Salary": 100}
+	if _, err := node.Evaluate(ctx); !errors.Is(err, ErrNotFound) {
+		t.Errorf("default lookup error = %v, want ErrNotFound", err)
+	}
+
+	ctx.CaseInsensitive = true
+	if got, err := node.Evaluate(ctx); err != nil || got != 200 {
+		t.Errorf("CaseInsensitive = %v, %v, want 200", got, err)
+	}
+	// Точное совпадение имеет приоритет
+	ctx.Variables["salary"] = 10
+	if got, err := node.Evaluate(ctx); err != nil || got != 20 {
+		t.Errorf("CaseInsensitive with exact match = %v, %v, want 20", got, err)
+	}
+
+	// LookupVariable имеет приоритет над картой, а при отказе используется карта
+	ctx = NewContext()
+	ctx.Variables = map[string]float64{"salary": 100, "bonus": 5}
+	ctx.LookupVariable = func(name string) (float64, bool) {
+		if name == "salary" {
+			return 300, true
+		}
+		return 0, false
+	}
+	if got, err := mustParse(t, "salary + bonus").Evaluate(ctx); err != nil || got != 305 {
+		t.Errorf("LookupVariable = %v, %v, want 305", got, err)
+	}
+
+	compiled, err := Compile(mustParse(t, "salary + bonus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := compiled(ctx); err != nil || got != 305 {
+		t.Errorf("compiled LookupVariable = %v, %v, want 305", got, err)
+	}
+}
+
+func TestMissingVarPolicy(t *testing.T) {
+	node := mustParse(t, "A + B")
+	paths := evalPaths(t, node)
+
+	tests := []struct {
+		policy MissingVarPolicy
+		want   float64
+	}{
+		{ZeroOnMissing, 5},
+		{DefaultOnMissing, 12},
+	}
+	for name, eval := range paths {
+		ctx := NewContext()
+		ctx.Variables = map[string]float64{"A": 5}
+		ctx.DefaultVariable = 7
+		if _, err := eval(ctx); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s with ErrorOnMissing: error %v, want ErrNotFound", name, err)
+		}
+		for _, tt := range tests {
+			ctx.MissingVarPolicy = tt.policy
+			if got, err := eval(ctx); err != nil || got != tt.want {
+				t.Errorf("%s with policy %d = %v, %v, want %v", name, tt.policy, got, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"score": 85}
+	value, outcome, err := EvaluateWithOutcome(mustParse(t, gradeFormula), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ResultJSON(value, outcome)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"result":4,"variables":{"score":85},"branches":[` +
+		`{"condition":"score >= 90","branch":"else"},{"condition":"score >= 80","branch":"then"}],` +
+		`"warnings":[],"implicit_default":false}`
+	if string(data) != want {
+		t.Errorf("ResultJSON =\n%s\nwant\n%s", data, want)
+	}
+
+	// Неявный 0 отражается в предупреждениях и флаге
+	ctx.Variables = map[string]float64{"a": 1, "b": 2}
+	value, outcome, err = EvaluateWithOutcome(mustParse(t, "IF(a > b, 5)"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ResultJSON(value, outcome)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{"result":0,"variables":{"a":1,"b":2},"branches":[{"condition":"a > b","branch":"none"}],` +
+		`"warnings":["условие a > b ложно, ветки ELSE нет: использовано значение 0"],"implicit_default":true}`
+	if string(data) != want {
+		t.Errorf("ResultJSON =\n%s\nwant\n%s", data, want)
+	}
+
+	// Пустой результат выводит пустые списки, а не null
+	if data, err := ResultJSON(1, EvalOutcome{}); err != nil || string(data) != `{"result":1,"variables":{},"branches":[],"warnings":[],"implicit_default":false}` {
+		t.Errorf("ResultJSON of empty outcome = %s, %v", data, err)
+	}
+	if _, err := ResultJSON(math.NaN(), EvalOutcome{}); err == nil {
+		t.Error("ResultJSON(NaN): expected error")
+	}
+}
+
+// callChain строит дерево wait() + (wait() + (... + wait())) из count вызовов
+func callChain(count int) ASTNode {
+	var node ASTNode = &FunctionNode{Name: "wait"}
+	for i := 1; i < count; i++ {
+		node = &OperationNode{Operator: "+", Left: &FunctionNode{Name: "wait"}, Right: node}
+	}
+	return node
+}
+
+// Отмена во время вычисления прерывает его перед следующим вызовом функции:
+// первый вызов wait блокируется, пока контекст не будет отменен, и остальные
+// вызовы уже не выполняются
+func TestEvaluateWithContextCancel(t *testing.T) {
+	stdctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	ec := NewContext()
+	ec.SetFunction("wait", func([]float64) (float64, error) {
+		calls++
+		if calls == 1 {
+			go cancel()
+			<-stdctx.Done()
+		}
+		return 1, nil
+	})
+
+	_, err := EvaluateWithContext(stdctx, callChain(1000), ec)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Errorf("wait called %d times after cancellation, want 1", calls)
+	}
+	if ec.cancel != nil {
+		t.Error("EvaluateWithContext modified the caller's Context")
+	}
+
+	// Уже отмененный контекст не запускает вычисление
+	calls = 0
+	if _, err := EvaluateWithContext(stdctx, callChain(10), ec); !errors.Is(err, context.Canceled) || calls != 0 {
+		t.Errorf("canceled context: error %v after %d calls, want context.Canceled before any call", err, calls)
+	}
+}
+
+func TestEvaluateWithContextDeadline(t *testing.T) {
+	stdctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	ec := NewContext()
+	ec.SetFunction("wait", func([]float64) (float64, error) {
+		calls++
+		<-stdctx.Done()
+		return 1, nil
+	})
+
+	_, err := EvaluateWithContext(stdctx, callChain(1000), ec)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error %v, want context.DeadlineExceeded", err)
+	}
+	if calls != 1 {
+		t.Errorf("wait called %d times after the deadline, want 1", calls)
+	}
+
+	// Без отмены то же дерево вычисляется полностью
+	ec.SetFunction("wait", func([]float64) (float64, error) { return 1, nil })
+	if got, err := EvaluateWithContext(context.Background(), callChain(1000), ec); err != nil || got != 1000 {
+		t.Errorf("without cancellation = %v, %v; want 1000", got, err)
+	}
+}
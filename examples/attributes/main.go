@@ -30,7 +30,10 @@ func main() {
 		if err != nil {
 			fmt.Printf("❌ Ошибка: %v\n", err)
 		} else {
-			//fmt.Printf("✅ AST: %s\n", ast.String())
+			fmt.Printf("✅ AST: %s\n", ast)
+
+			// Только эти переменные нужно достать из репозитория
+			fmt.Printf("🔎 Переменные: %v\n", formula.CollectVariables(ast))
 
 			// Тестируем вычисление с примерными значениями
 			// подставляем переменные нужно сохранить просто в таблице
@@ -228,17 +228,19 @@ func FormulaHandler(w http.ResponseWriter, r *http.Request) {
 	ctx.Variables = request.Variables
 
 	// Вычисляем
-	result, err := node.Evaluate(ctx)
+	result, outcome, err := formula.EvaluateWithOutcome(node, ctx)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Evaluation error: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Возвращаем результат
-	response := map[string]interface{}{
-		"result": result,
+	// Возвращаем результат вместе с переменными, ветками и предупреждениями
+	response, err := formula.ResultJSON(result, outcome)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Evaluation error: %v", err), http.StatusBadRequest)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	w.Write(response)
 }
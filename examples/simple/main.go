@@ -30,7 +30,7 @@ func main() {
 		if err != nil {
 			fmt.Printf("❌ Ошибка: %v\n", err)
 		} else {
-			//fmt.Printf("✅ AST: %s\n", ast.String())
+			fmt.Printf("✅ AST: %s\n", ast)
 
 			// Тестируем вычисление с примерными значениями
 			variables := map[string]float64{
@@ -64,7 +64,7 @@ func main() {
 		if len(result.Warnings) > 0 {
 			fmt.Printf("  ⚠️  Предупреждения:\n")
 			for j, warning := range result.Warnings {
-				fmt.Printf("     %d. %s\n", j+1, warning)
+				fmt.Printf("     %d. [%s] %s\n", j+1, warning.Code, warning.Message)
 			}
 		}
 
@@ -90,7 +90,7 @@ func main() {
 		if len(result.Warnings) > 0 {
 			fmt.Printf("  ⚠️  Предупреждения:\n")
 			for j, warning := range result.Warnings {
-				fmt.Printf("     %d. %s\n", j+1, warning)
+				fmt.Printf("     %d. [%s] %s\n", j+1, warning.Code, warning.Message)
 			}
 		}
 
@@ -0,0 +1,218 @@
+package formula
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Уровни приоритета от самого слабого к самому сильному связыванию.
+// Соответствуют уровням разбора в Parser.
+const (
+	precedenceConditional    = iota // IF ... THEN ... ELSE
+	precedenceOr                    // OR / ИЛИ
+	precedenceAnd                   // AND / И
+	precedenceNot                   // NOT / НЕ
+	precedenceComparison            // = != > < >= <=
+	precedenceAdditive              // + -
+	precedenceMultiplicative        // * / %
+	precedencePower                 // ^ **
+	precedenceUnary                 // унарные + и -
+	precedenceAtom                  // литералы, переменные, вызовы функций
+)
+
+// Precedence возвращает силу связывания корневого оператора узла:
+// чем больше значение, тем сильнее связывание. Литералы, переменные и вызовы
+// функций имеют наибольший приоритет, условное выражение и OR - наименьший.
+func Precedence(node ASTNode) int {
+	switch n := node.(type) {
+	case *ConditionalNode:
+		return precedenceConditional
+	case *LogicalNode:
+		if n.Operator == "AND" {
+			return precedenceAnd
+		}
+		return precedenceOr
+	case *ComparisonNode:
+		return precedenceComparison
+	case *OperationNode:
+		return operatorPrecedence(n.Operator)
+	case *UnaryNode:
+		switch n.Operator {
+		case "abs":
+			return precedenceAtom // |x| ограничен чертами с обеих сторон
+		case "NOT":
+			return precedenceNot
+		}
+		return precedenceUnary
+	default:
+		return precedenceAtom
+	}
+}
+
+// operatorPrecedence возвращает приоритет арифметического оператора
+func operatorPrecedence(op string) int {
+	switch op {
+	case "+", "-":
+		return precedenceAdditive
+	case "^", "**":
+		return precedencePower
+	default:
+		return precedenceMultiplicative
+	}
+}
+
+// quoteIdentifier возвращает имя переменной в виде, пригодном для повторного разбора:
+// обычные имена (score2, user.age) остаются как есть, остальные заключаются в обратные
+// кавычки (обратная кавычка внутри имени удваивается)
+func quoteIdentifier(name string) string {
+	// Имя можно оставить как есть, если лексер прочитает его целиком как переменную
+	token := NewLexer(name).NextToken()
+	if token.Type == TokenVariable && token.Value == name && token.Pos == 0 && token.End == utf8.RuneCountInString(name) {
+		return name
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// Language определяет язык ключевых слов при выводе формулы
+type Language string
+
+const (
+	LanguageEnglish Language = "en"
+	LanguageRussian Language = "ru"
+)
+
+// keywordSet - ключевые слова одного языка
+type keywordSet struct {
+	If, Then, Else, And, Or, Not string
+}
+
+var languageKeywords = map[Language]keywordSet{
+	LanguageEnglish: {If: "IF", Then: "THEN", Else: "ELSE", And: "AND", Or: "OR", Not: "NOT"},
+	LanguageRussian: {If: "ЕСЛИ", Then: "ТОГДА", Else: "ИНАЧЕ", And: "И", Or: "ИЛИ", Not: "НЕ"},
+}
+
+// StringLocalized выводит формулу в инфиксной записи с ключевыми словами
+// выбранного языка независимо от того, на каком языке она была написана:
+// ЕСЛИ/ТОГДА/ИНАЧЕ, И/ИЛИ/НЕ или IF/THEN/ELSE, AND/OR/NOT.
+// Скобки расставляются только там, где этого требует приоритет операторов.
+func StringLocalized(node ASTNode, lang Language) string {
+	if _, ok := languageKeywords[lang]; !ok {
+		lang = LanguageEnglish
+	}
+	return formatter{lang: lang}.format(node)
+}
+
+// formatter выводит узлы в инфиксной записи. Если язык не задан,
+// ключевые слова выводятся на языке исходного написания (поле Keyword),
+// а для узлов без него - на английском.
+type formatter struct {
+	lang Language
+}
+
+func (f formatter) keywords(keyword string) keywordSet {
+	if f.lang == "" {
+		return languageKeywords[keywordLanguage(keyword)]
+	}
+	return languageKeywords[f.lang]
+}
+
+// keywordLanguage определяет язык по написанию ключевого слова
+func keywordLanguage(keyword string) Language {
+	upper := strings.ToUpper(keyword)
+	for lang, kw := range languageKeywords {
+		if upper == kw.If || upper == kw.And || upper == kw.Or || upper == kw.Not {
+			return lang
+		}
+	}
+	return LanguageEnglish
+}
+
+func (f formatter) format(node ASTNode) string {
+	switch n := node.(type) {
+	case *LiteralNode:
+		return formatNumber(n.Value)
+
+	case *VariableNode:
+		return quoteIdentifier(n.Name)
+
+	case *OperationNode:
+		precedence := operatorPrecedence(n.Operator)
+		if precedence == precedencePower {
+			// Степень правоассоциативна: 2 ^ 3 ^ 2 = 2 ^ (3 ^ 2)
+			return f.operand(n.Left, precedence+1) + " " + n.Operator + " " + f.operand(n.Right, precedence)
+		}
+		return f.operand(n.Left, precedence) + " " + n.Operator + " " + f.operand(n.Right, precedence+1)
+
+	case *ComparisonNode:
+		return f.operand(n.Left, precedenceComparison) + " " + n.Operator + " " + f.operand(n.Right, precedenceComparison+1)
+
+	case *LogicalNode:
+		precedence := Precedence(n)
+		keyword := f.keywords(n.Keyword).Or
+		if n.Operator == "AND" {
+			keyword = f.keywords(n.Keyword).And
+		}
+		return f.operand(n.Left, precedence) + " " + keyword + " " + f.operand(n.Right, precedence+1)
+
+	case *UnaryNode:
+		switch n.Operator {
+		case "abs":
+			return "|" + f.operand(n.Operand, precedenceOr) + "|"
+		case "NOT":
+			return f.keywords(n.Keyword).Not + " " + f.operand(n.Operand, precedenceNot)
+		}
+		return n.Operator + f.operand(n.Operand, precedenceAtom)
+
+	case *ConditionalNode:
+		kw := f.keywords(n.Keyword)
+		result := kw.If + " " + f.operand(n.Condition, precedenceOr) + " " + kw.Then + " " + f.operand(n.Then, precedenceOr)
+		if n.Else != nil {
+			result += " " + kw.Else + " " + f.operand(n.Else, precedenceOr)
+		}
+		return result
+
+	case *SwitchNode:
+		args := []string{f.operand(n.Subject, precedenceOr)}
+		for i := range n.Cases {
+			args = append(args, f.operand(n.Cases[i], precedenceOr), f.operand(n.Values[i], precedenceOr))
+		}
+		if n.Default != nil {
+			args = append(args, f.operand(n.Default, precedenceOr))
+		}
+		return "SWITCH(" + strings.Join(args, ", ") + ")"
+
+	case *FunctionNode:
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = f.operand(arg, precedenceOr)
+		}
+		return n.Name + "(" + strings.Join(args, ", ") + ")"
+
+	default:
+		return string(node.GetType())
+	}
+}
+
+// operand выводит дочерний узел, заключая его в скобки, если он связывает
+// слабее, чем требует позиция
+func (f formatter) operand(node ASTNode, minPrecedence int) string {
+	result := f.format(node)
+	if Precedence(node) < minPrecedence {
+		return "(" + result + ")"
+	}
+	return result
+}
+
+// Методы String выводят узел в инфиксной записи с минимально необходимыми
+// скобками. Результат можно снова разобрать парсером; ключевые слова
+// сохраняют язык исходной формулы.
+
+func (n *LiteralNode) String() string     { return formatter{}.format(n) }
+func (n *VariableNode) String() string    { return formatter{}.format(n) }
+func (n *OperationNode) String() string   { return formatter{}.format(n) }
+func (n *ComparisonNode) String() string  { return formatter{}.format(n) }
+func (n *LogicalNode) String() string     { return formatter{}.format(n) }
+func (n *ConditionalNode) String() string { return formatter{}.format(n) }
+func (n *SwitchNode) String() string      { return formatter{}.format(n) }
+func (n *UnaryNode) String() string       { return formatter{}.format(n) }
+func (n *FunctionNode) String() string    { return formatter{}.format(n) }
@@ -0,0 +1,147 @@
+package formula
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPrecedenceOrder(t *testing.T) {
+	// От самого слабого связывания к самому сильному
+	formulas := []string{
+		"IF a THEN b ELSE c",
+		"a OR b",
+		"a AND b",
+		"NOT a",
+		"a > b",
+		"a + b",
+		"a * b",
+		"a ^ b",
+		"-a",
+		"a",
+	}
+	for i := 1; i < len(formulas); i++ {
+		weaker, stronger := mustParse(t, formulas[i-1]), mustParse(t, formulas[i])
+		if Precedence(weaker) >= Precedence(stronger) {
+			t.Errorf("Precedence(%s) = %d, want less than Precedence(%s) = %d",
+				formulas[i-1], Precedence(weaker), formulas[i], Precedence(stronger))
+		}
+	}
+
+	atom := Precedence(mustParse(t, "a"))
+	for _, formula := range []string{"2", "max(a, b)", "|a - b|"} {
+		if got := Precedence(mustParse(t, formula)); got != atom {
+			t.Errorf("Precedence(%s) = %d, want %d like a variable", formula, got, atom)
+		}
+	}
+	if Precedence(mustParse(t, "a - b")) != Precedence(mustParse(t, "a + b")) ||
+		Precedence(mustParse(t, "a % b")) != Precedence(mustParse(t, "a / b")) {
+		t.Error("operators of one level have different precedences")
+	}
+}
+
+// Русская формула должна выводиться с русскими ключевыми словами
+func TestStringKeepsKeywordLanguage(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    string
+	}{
+		{"ЕСЛИ (a > 1 И b < 2) ТОГДА 1 ИНАЧЕ НЕ c", "ЕСЛИ a > 1 И b < 2 ТОГДА 1 ИНАЧЕ НЕ c"},
+		{"если a > 1 или b тогда 1", "ЕСЛИ a > 1 ИЛИ b ТОГДА 1"},
+		{"IF a > 1 OR b THEN 1 ELSE 2", "IF a > 1 OR b THEN 1 ELSE 2"},
+	}
+	for _, tt := range tests {
+		node := mustParse(t, tt.formula)
+		got := node.(fmt.Stringer).String()
+		if got != tt.want {
+			t.Errorf("String(%s) = %q, want %q", tt.formula, got, tt.want)
+		}
+		if reparsed := mustParse(t, got).(fmt.Stringer).String(); reparsed != got {
+			t.Errorf("round trip of %q gave %q", got, reparsed)
+		}
+	}
+
+	conditional := mustParse(t, "ЕСЛИ a ТОГДА 1").(*ConditionalNode)
+	if conditional.Keyword != "ЕСЛИ" {
+		t.Errorf("Keyword = %q, want ЕСЛИ", conditional.Keyword)
+	}
+}
+
+func TestStringLocalized(t *testing.T) {
+	node := mustParse(t, "IF (a > 1 AND b < 2) OR NOT c THEN 1 ELSE 2")
+	if got, want := StringLocalized(node, LanguageRussian), "ЕСЛИ a > 1 И b < 2 ИЛИ НЕ c ТОГДА 1 ИНАЧЕ 2"; got != want {
+		t.Errorf("Russian: %q, want %q", got, want)
+	}
+	if got, want := StringLocalized(node, LanguageEnglish), "IF a > 1 AND b < 2 OR NOT c THEN 1 ELSE 2"; got != want {
+		t.Errorf("English: %q, want %q", got, want)
+	}
+
+	russian := mustParse(t, "ЕСЛИ a > 1 ТОГДА 1 ИНАЧЕ 2")
+	if got, want := StringLocalized(russian, LanguageEnglish), "IF a > 1 THEN 1 ELSE 2"; got != want {
+		t.Errorf("Russian formula in English: %q, want %q", got, want)
+	}
+	if got, want := StringLocalized(russian, "de"), "IF a > 1 THEN 1 ELSE 2"; got != want {
+		t.Errorf("unknown language: %q, want English %q", got, want)
+	}
+}
+
+func TestStringMinimalParentheses(t *testing.T) {
+	tests := []struct {
+		node ASTNode
+		want string
+	}{
+		{&OperationNode{Operator: "+", Left: &VariableNode{Name: "A"}, Right: &OperationNode{
+			Operator: "*", Left: &VariableNode{Name: "B"}, Right: &LiteralNode{Value: 2}}}, "A + B * 2"},
+		{&OperationNode{Operator: "*", Left: &OperationNode{
+			Operator: "+", Left: &VariableNode{Name: "A"}, Right: &VariableNode{Name: "B"}}, Right: &LiteralNode{Value: 2}}, "(A + B) * 2"},
+		{&OperationNode{Operator: "-", Left: &VariableNode{Name: "A"}, Right: &OperationNode{
+			Operator: "-", Left: &VariableNode{Name: "B"}, Right: &VariableNode{Name: "C"}}}, "A - (B - C)"},
+		{&OperationNode{Operator: "-", Left: &OperationNode{
+			Operator: "-", Left: &VariableNode{Name: "A"}, Right: &VariableNode{Name: "B"}}, Right: &VariableNode{Name: "C"}}, "A - B - C"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(tt.node); got != tt.want {
+			t.Errorf("String = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+// Напечатанная формула разбирается в дерево, которое вычисляется так же,
+// как исходное, и печатается так же
+func TestStringRoundTrip(t *testing.T) {
+	formulas := []string{
+		"A + B * 2", "(A + B) * 2", "A - (B - C)", "A / (B * C)", "A // B % C",
+		"2 ^ 3 ^ C", "(2 ^ 3) ^ C", "-A ^ 2", "-(A ^ 2)", "A - -B", "|C - A| * 2",
+		"A > B = C", "(A > B) + 1", "NOT (A > B)", "NOT A OR B",
+		"A OR B AND C", "(A OR B) AND C", "A AND (B OR C)",
+		"IF(A > B, IF(C, 1, 2), 3) + 1", "IF A > 1 THEN A ELSE IF B > 1 THEN B ELSE C",
+		"IF(A > B, 5)", "ЕСЛИ A > B И C ТОГДА 1 ИНАЧЕ 2",
+		"max(A, B + 1, 3) - min(A * B, sqrt(C * C))", "round(A / 3, 2) + 1.5e-7",
+		"SWITCH(A, 3, 10, -1.5, 20, 30) * 2", "SWITCH(B > 1, 1, A, 0)",
+	}
+	varSets := []map[string]float64{
+		{"A": 3, "B": 2, "C": 7},
+		{"A": -1.5, "B": 0, "C": 4},
+		{"A": 0, "B": 5, "C": 1},
+	}
+	for _, formula := range formulas {
+		original := mustParse(t, formula)
+		printed := fmt.Sprint(original)
+		reparsed, err := NewSimpleParser().ParseString(printed)
+		if err != nil {
+			t.Errorf("%s printed as %s does not parse: %v", formula, printed, err)
+			continue
+		}
+		if again := fmt.Sprint(reparsed); again != printed {
+			t.Errorf("%s printed as %s, reprinted as %s", formula, printed, again)
+		}
+		for _, vars := range varSets {
+			ctx := NewContext()
+			ctx.Variables = vars
+			want, wantErr := original.Evaluate(ctx)
+			got, err := reparsed.Evaluate(ctx)
+			if (err == nil) != (wantErr == nil) || got != want {
+				t.Errorf("%s printed as %s with %v = %v, %v; original = %v, %v", formula, printed, vars, got, err, want, wantErr)
+			}
+		}
+	}
+}
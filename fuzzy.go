@@ -0,0 +1,104 @@
+package formula
+
+import (
+	"fmt"
+	"math"
+)
+
+// FuzzyLogic определяет, как AND и OR объединяют степени истинности
+type FuzzyLogic int
+
+const (
+	// FuzzyMinMax - логика Заде: AND = min(a, b), OR = max(a, b)
+	FuzzyMinMax FuzzyLogic = iota
+	// FuzzyProduct - вероятностная логика: AND = a*b, OR = a + b - a*b
+	FuzzyProduct
+)
+
+// FuzzyConfig включает нечеткий режим: сравнения возвращают степень
+// истинности из [0, 1] вместо 0 или 1, AND и OR объединяют такие степени,
+// а NOT возвращает дополнение 1 - x. IF выбирает ветку THEN, если степень
+// истинности условия не меньше Threshold, иначе ELSE: сигмоида никогда не
+// дает точно 0, поэтому проверка "не равно 0" выбирала бы THEN всегда.
+type FuzzyConfig struct {
+	// Width - ширина переходной зоны около порога в единицах сравниваемых
+	// величин: при Width = 10 сравнение 95 > 90 дает около 0.62.
+	// 0 равносильно Width = 1.
+	Width float64
+
+	// Membership переводит отклонение в пользу истинности (для a > b это
+	// (a - b) / Width) в степень истинности. По умолчанию - сигмоида
+	// 1 / (1 + e^-x), равная 0.5 на самом пороге.
+	Membership func(x float64) float64
+
+	Logic FuzzyLogic
+
+	// Threshold - степень истинности, с которой условие IF считается
+	// выполненным. 0 равносильно Threshold = 0.5, то есть порогу сравнения.
+	Threshold float64
+}
+
+// conditionHolds сообщает, выбирает ли условие IF со значением value ветку
+// THEN: в обычном режиме - любое ненулевое значение, в нечетком - степень
+// истинности не меньше FuzzyConfig.Threshold
+func (c *Context) conditionHolds(value float64) bool {
+	if c == nil || c.Fuzzy == nil {
+		return value != 0 // 0 считается false, все остальное true
+	}
+	threshold := c.Fuzzy.Threshold
+	if threshold == 0 {
+		threshold = 0.5
+	}
+	return value >= threshold
+}
+
+// membership возвращает степень истинности для отклонения в пользу истинности
+func (f *FuzzyConfig) membership(distance float64) float64 {
+	x := distance
+	if f.Width > 0 {
+		x = distance / f.Width
+	}
+	if f.Membership != nil {
+		return clampUnit(f.Membership(x))
+	}
+	return 1 / (1 + math.Exp(-x))
+}
+
+// compare вычисляет степень истинности сравнения. Равенство максимально
+// истинно при совпадении значений и убывает с ростом расстояния между ними.
+func (f *FuzzyConfig) compare(operator string, left, right float64) (float64, error) {
+	switch operator {
+	case ">", ">=":
+		return f.membership(left - right), nil
+	case "<", "<=":
+		return f.membership(right - left), nil
+	case "=":
+		return clampUnit(2 * f.membership(-math.Abs(left-right))), nil
+	case "!=", "<>":
+		return 1 - clampUnit(2*f.membership(-math.Abs(left-right))), nil
+	default:
+		return 0, fmt.Errorf("unknown comparison operator: %s", operator)
+	}
+}
+
+// combine объединяет степени истинности операндов AND или OR
+func (f *FuzzyConfig) combine(operator string, left, right float64) (float64, error) {
+	left, right = clampUnit(left), clampUnit(right)
+	switch {
+	case operator == "AND" && f.Logic == FuzzyProduct:
+		return left * right, nil
+	case operator == "AND":
+		return math.Min(left, right), nil
+	case operator == "OR" && f.Logic == FuzzyProduct:
+		return left + right - left*right, nil
+	case operator == "OR":
+		return math.Max(left, right), nil
+	default:
+		return 0, fmt.Errorf("unknown logical operator: %s", operator)
+	}
+}
+
+// clampUnit ограничивает значение отрезком [0, 1]
+func clampUnit(value float64) float64 {
+	return math.Max(0, math.Min(1, value))
+}
@@ -0,0 +1,80 @@
+package formula
+
+import (
+	"math"
+	"testing"
+)
+
+// fuzzyContext возвращает контекст в нечетком режиме с переменными vars
+func fuzzyContext(vars map[string]float64) *Context {
+	ctx := NewContext()
+	ctx.Variables = vars
+	ctx.Fuzzy = &FuzzyConfig{Width: 10}
+	return ctx
+}
+
+func TestFuzzyComparisonNearThreshold(t *testing.T) {
+	node := mustParse(t, "s > 90")
+	got, err := node.Evaluate(fuzzyContext(map[string]float64{"s": 95}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 1 / (1 + math.Exp(-0.5)); math.Abs(got-want) > 1e-12 {
+		t.Errorf("95 > 90 = %v, want %v", got, want)
+	}
+	if got <= 0.5 || got >= 1 {
+		t.Errorf("95 > 90 = %v, want a degree strictly between 0.5 and 1", got)
+	}
+}
+
+// IF должен выбирать ELSE, когда степень истинности условия ниже порога,
+// одинаково при обходе дерева, в Compile и в байт-коде
+func TestFuzzyIfNearThreshold(t *testing.T) {
+	node := mustParse(t, "IF(s > 100, 1, 2)")
+	paths := evalPaths(t, node)
+
+	tests := []struct {
+		s    float64
+		want float64
+	}{
+		{0, 2}, {99, 2}, {100, 1}, {101, 1}, {200, 1},
+	}
+	for _, tt := range tests {
+		vars := map[string]float64{"s": tt.s}
+		for name, eval := range paths {
+			got, err := eval(fuzzyContext(vars))
+			if err != nil {
+				t.Fatalf("%s with s=%v: %v", name, tt.s, err)
+			}
+			if got != tt.want {
+				t.Errorf("%s with s=%v = %v, want %v", name, tt.s, got, tt.want)
+			}
+		}
+	}
+
+	ctx := fuzzyContext(map[string]float64{"s": 101})
+	ctx.Fuzzy.Threshold = 0.9
+	if got, _ := node.Evaluate(ctx); got != 2 {
+		t.Errorf("Threshold 0.9 with s=101 = %v, want 2", got)
+	}
+}
+
+func TestFuzzyNot(t *testing.T) {
+	node := mustParse(t, "NOT (s > 90)")
+
+	vars := map[string]float64{"s": 95}
+	degree, err := mustParse(t, "s > 90").Evaluate(fuzzyContext(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := evalPaths(t, node)
+	for name, eval := range paths {
+		got, err := eval(fuzzyContext(vars))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want := 1 - degree; math.Abs(got-want) > 1e-12 {
+			t.Errorf("%s: NOT (95 > 90) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package formula
+
+import (
+	"strconv"
+	"strings"
+)
+
+// IncrementalEvaluator вычисляет формулу повторно после изменения отдельных
+// переменных, пересчитывая только поддеревья, зависящие от них. Результаты
+// остальных поддеревьев берутся из кэша. Одинаковые поддеревья (например,
+// три вызова sqrt(x) в разных местах формулы) делят один кэш и вычисляются
+// один раз.
+type IncrementalEvaluator struct {
+	root   ASTNode
+	depth  int                    // глубина исходного дерева для Context.MaxDepth
+	shared map[string]*cachedNode // кэш по структурному ключу поддерева
+	ctx    *Context
+
+	// dependents - узлы, непосредственно ссылающиеся на переменную
+	dependents map[string][]*cachedNode
+	size       int // число созданных cachedNode, из него берутся id
+	epoch      int // номер последнего сброса кэша, см. SetVariable
+}
+
+// cachedNode хранит последний результат вычисления узла
+type cachedNode struct {
+	node          ASTNode
+	id            int
+	parents       []*cachedNode // узлы, для которых этот узел дочерний
+	deterministic bool
+	valid         bool
+	value         float64
+	epoch         int // сброс кэша, при котором узел уже обработан
+}
+
+func (n *cachedNode) Evaluate(ctx *Context) (float64, error) {
+	if n.valid {
+		return n.value, nil
+	}
+
+	value, err := n.node.Evaluate(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if n.deterministic {
+		n.value, n.valid = value, true
+	}
+	return value, nil
+}
+
+func (n *cachedNode) GetType() NodeType {
+	return n.node.GetType()
+}
+
+// NewIncrementalEvaluator подготавливает формулу к инкрементальному вычислению
+// в контексте ctx. Переменные следует менять через SetVariable, иначе кэш не
+// узнает об изменении. Поддеревья с недетерминированными функциями не кэшируются.
+// Вычислитель не предназначен для одновременного использования из нескольких горутин.
+func NewIncrementalEvaluator(node ASTNode, ctx *Context) *IncrementalEvaluator {
+	e := &IncrementalEvaluator{
+		ctx:        ctx,
+		shared:     make(map[string]*cachedNode),
+		dependents: make(map[string][]*cachedNode),
+	}
+	e.root = e.wrap(node)
+	e.depth = treeDepth(node)
+	return e
+}
+
+// wrap строит копию дерева, в которой каждый внутренний узел кэширует результат.
+// Дерево обрабатывается снизу вверх за один проход: ключ узла составляется из
+// ключей листьев и id уже построенных дочерних cachedNode, а зависимости и
+// детерминированность берутся у дочерних узлов.
+func (e *IncrementalEvaluator) wrap(node ASTNode) ASTNode {
+	switch node.(type) {
+	case *LiteralNode, *VariableNode:
+		return node
+	}
+
+	head, arity, comparable := structuralHead(node)
+	if !comparable {
+		// Узлы других пакетов не кэшируются: их зависимости неизвестны
+		return node
+	}
+
+	var key strings.Builder
+	key.WriteString(head)
+	key.WriteByte('(')
+	deterministic := true
+	var cachedChildren []*cachedNode
+	var variables []string
+	parts := 0
+	wrapped := mapChildren(node, func(child ASTNode) ASTNode {
+		child = e.wrap(child)
+		if parts > 0 {
+			key.WriteByte(',')
+		}
+		parts++
+		switch c := child.(type) {
+		case *LiteralNode:
+			key.WriteString(strconv.FormatFloat(c.Value, 'g', -1, 64))
+		case *VariableNode:
+			key.WriteString(strconv.Quote(c.Name))
+			variables = append(variables, c.Name)
+		case *cachedNode:
+			key.WriteString("#" + strconv.Itoa(c.id))
+			deterministic = deterministic && c.deterministic
+			cachedChildren = append(cachedChildren, c)
+		default:
+			comparable, deterministic = false, false
+		}
+		return child
+	})
+	key.WriteByte(')')
+
+	// Пропущенный дочерний узел (nil) не отражается в ключе
+	comparable = comparable && parts == arity
+	if cached, ok := e.shared[key.String()]; ok && comparable {
+		return cached
+	}
+
+	if fn, ok := node.(*FunctionNode); ok && e.ctx.isNonDeterministic(fn.Name) {
+		deterministic = false
+	}
+	cached := &cachedNode{node: wrapped, id: e.size, deterministic: deterministic}
+	e.size++
+	for _, child := range cachedChildren {
+		child.parents = append(child.parents, cached)
+	}
+	for _, name := range variables {
+		e.dependents[name] = append(e.dependents[name], cached)
+	}
+	if comparable {
+		e.shared[key.String()] = cached
+	}
+	return cached
+}
+
+// structuralHead возвращает часть структурного ключа узла без дочерних узлов
+// и число дочерних узлов. Для узлов других пакетов ok равно false.
+func structuralHead(node ASTNode) (head string, arity int, ok bool) {
+	switch n := node.(type) {
+	case *OperationNode:
+		return "op " + n.Operator, 2, true
+	case *ComparisonNode:
+		return "cmp " + n.Operator, 2, true
+	case *LogicalNode:
+		return "logic " + n.Operator, 2, true
+	case *UnaryNode:
+		return "unary " + n.Operator, 1, true
+	case *ConditionalNode:
+		if n.Else == nil {
+			return "if " + strconv.FormatBool(n.StrictElse), 2, true
+		}
+		return "if " + strconv.FormatBool(n.StrictElse), 3, true
+	case *SwitchNode:
+		// Число вариантов в заголовке отличает SWITCH с Default от SWITCH с еще одним вариантом
+		head := "switch " + strconv.Itoa(len(n.Cases))
+		if n.Default == nil {
+			return head, 1 + 2*len(n.Cases), true
+		}
+		return head, 2 + 2*len(n.Cases), true
+	case *FunctionNode:
+		return "call " + strconv.Quote(n.Name), len(n.Args), true
+	default:
+		return "", 0, false
+	}
+}
+
+// Evaluate вычисляет формулу, используя закэшированные результаты.
+// Context.MaxDepth проверяется по исходному дереву.
+func (e *IncrementalEvaluator) Evaluate() (float64, error) {
+	ctx, err := e.ctx.enterDepth(e.depth)
+	if err != nil {
+		return 0, err
+	}
+	return e.root.Evaluate(ctx)
+}
+
+// SetVariable задает значение переменной и сбрасывает кэш зависящих от нее
+// поддеревьев. С Context.CaseInsensitive имя сопоставляется без учета
+// регистра, как при вычислении: SetVariable("X", ...) меняет уже заданное
+// значение "x" и сбрасывает поддеревья, использующие x или X.
+func (e *IncrementalEvaluator) SetVariable(name string, value float64) {
+	if e.ctx.Variables == nil {
+		e.ctx.Variables = make(map[string]float64)
+	}
+	if _, exact := e.ctx.Variables[name]; !exact && e.ctx.CaseInsensitive {
+		for key := range e.ctx.Variables {
+			if strings.EqualFold(key, name) {
+				name = key
+				break
+			}
+		}
+	}
+	if old, exists := e.ctx.Variables[name]; exists && old == value {
+		return
+	}
+	e.ctx.Variables[name] = value
+
+	// Сбрасываются узлы, ссылающиеся на переменную, и все их предки. Узел
+	// может быть общим для нескольких родителей, поэтому уже обработанные
+	// при этом сбросе узлы пропускаются.
+	e.epoch++
+	stack := append([]*cachedNode(nil), e.dependents[name]...)
+	if e.ctx.CaseInsensitive {
+		for key, nodes := range e.dependents {
+			if key != name && strings.EqualFold(key, name) {
+				stack = append(stack, nodes...)
+			}
+		}
+	}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if node.epoch == e.epoch {
+			continue
+		}
+		node.epoch = e.epoch
+		node.valid = false
+		stack = append(stack, node.parents...)
+	}
+}
@@ -0,0 +1,173 @@
+package formula
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// countingContext возвращает контекст, в котором sqrt считает свои вызовы
+func countingContext(calls *int) *Context {
+	ctx := NewContext()
+	ctx.Functions["sqrt"] = func(args []float64) (float64, error) {
+		*calls++
+		return math.Sqrt(args[0]), nil
+	}
+	return ctx
+}
+
+// Три одинаковых вызова sqrt(x) должны вычисляться один раз
+func TestIncrementalSharesIdenticalCalls(t *testing.T) {
+	calls := 0
+	ctx := countingContext(&calls)
+	ctx.Variables = map[string]float64{"x": 16, "y": 1}
+	e := NewIncrementalEvaluator(mustParse(t, "sqrt(x) + sqrt(x) * y + IF(y > 0, sqrt(x), 0)"), ctx)
+
+	if got, err := e.Evaluate(); err != nil || got != 12 {
+		t.Fatalf("Evaluate = %v, %v; want 12", got, err)
+	}
+	if calls != 1 {
+		t.Errorf("sqrt called %d times for three identical sqrt(x), want 1", calls)
+	}
+
+	e.SetVariable("x", 25)
+	if got, _ := e.Evaluate(); got != 15 {
+		t.Errorf("after x = 25: %v, want 15", got)
+	}
+	if calls != 2 {
+		t.Errorf("sqrt called %d times after changing x, want 2", calls)
+	}
+}
+
+// Константа, переопределенная переменной, сбрасывает кэш так же, как переменная
+func TestIncrementalConstantOverride(t *testing.T) {
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"r": 2}
+	e := NewIncrementalEvaluator(mustParse(t, "PI * r * r"), ctx)
+	if got, _ := e.Evaluate(); got != math.Pi*4 {
+		t.Fatalf("PI * r * r = %v", got)
+	}
+	e.SetVariable("PI", 3)
+	if got, _ := e.Evaluate(); got != 12 {
+		t.Errorf("with PI = 3: %v, want 12", got)
+	}
+}
+
+func BenchmarkNewIncrementalEvaluator(b *testing.B) {
+	node := deepSum(2000)
+	ctx := NewContext()
+	for i := 0; i < b.N; i++ {
+		NewIncrementalEvaluator(node, ctx)
+	}
+}
+
+// Изменение переменной не должно пересчитывать независящие от нее поддеревья
+func TestIncrementalRecomputesOnlyDependents(t *testing.T) {
+	calls := map[string]int{}
+	ctx := NewContext()
+	for _, name := range []string{"left", "right"} {
+		name := name
+		ctx.Functions[name] = func(args []float64) (float64, error) {
+			calls[name]++
+			return args[0] * 2, nil
+		}
+	}
+	ctx.Variables = map[string]float64{"a": 1, "b": 2}
+	e := NewIncrementalEvaluator(mustParse(t, "left(a + 1) + right(b * 3)"), ctx)
+
+	if got, err := e.Evaluate(); err != nil || got != 16 {
+		t.Fatalf("Evaluate = %v, %v; want 16", got, err)
+	}
+	e.SetVariable("a", 5)
+	if got, _ := e.Evaluate(); got != 24 {
+		t.Errorf("after a = 5: %v, want 24", got)
+	}
+	e.SetVariable("a", 5)
+	e.Evaluate()
+
+	if calls["left"] != 2 || calls["right"] != 1 {
+		t.Errorf("calls = %v, want left 2 and right 1", calls)
+	}
+
+	ctx.MarkNonDeterministic("right")
+	e = NewIncrementalEvaluator(mustParse(t, "left(a) + right(b)"), ctx)
+	e.Evaluate()
+	e.Evaluate()
+	if calls["right"] != 3 {
+		t.Errorf("non-deterministic right called %d times in total, want 3", calls["right"])
+	}
+}
+
+func BenchmarkIncrementalSetVariable(b *testing.B) {
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"x": 1, "y": 2}
+	node := &OperationNode{Operator: "+", Left: deepSum(500), Right: mustParse(b, "sqrt(x) * y")}
+	e := NewIncrementalEvaluator(node, ctx)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.SetVariable("x", float64(i))
+		if _, err := e.Evaluate(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIncrementalFullEvaluate(b *testing.B) {
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"x": 1, "y": 2}
+	node := &OperationNode{Operator: "+", Left: deepSum(500), Right: mustParse(b, "sqrt(x) * y")}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx.Variables["x"] = float64(i)
+		if _, err := node.Evaluate(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// MaxDepth проверяется по исходному дереву, а не по дереву кэширующих узлов
+func TestIncrementalMaxDepth(t *testing.T) {
+	node := mustParse(t, "((((a + 1) + 1) + 1) + 1) + 1")
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"a": 1}
+	ctx.MaxDepth = 3
+	e := NewIncrementalEvaluator(node, ctx)
+	if _, err := e.Evaluate(); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("MaxDepth 3: error %v, want ErrMaxDepth", err)
+	}
+
+	ctx.MaxDepth = treeDepth(node)
+	if got, err := e.Evaluate(); err != nil || got != 6 {
+		t.Errorf("MaxDepth %d = %v, %v; want 6", ctx.MaxDepth, got, err)
+	}
+}
+
+// С CaseInsensitive SetVariable сбрасывает кэш независимо от регистра имени
+func TestIncrementalCaseInsensitive(t *testing.T) {
+	ctx := NewContext()
+	ctx.CaseInsensitive = true
+	e := NewIncrementalEvaluator(mustParse(t, "x * 2 + Y"), ctx)
+
+	e.SetVariable("X", 1)
+	e.SetVariable("y", 10)
+	if got, err := e.Evaluate(); err != nil || got != 12 {
+		t.Fatalf("Evaluate = %v, %v; want 12", got, err)
+	}
+
+	e.SetVariable("x", 5)
+	if got, err := e.Evaluate(); err != nil || got != 20 {
+		t.Errorf("after SetVariable(x, 5) = %v, %v; want 20", got, err)
+	}
+	e.SetVariable("Y", 0)
+	if got, err := e.Evaluate(); err != nil || got != 10 {
+		t.Errorf("after SetVariable(Y, 0) = %v, %v; want 10", got, err)
+	}
+	// Формула использует x, а значение задается как X
+	e.SetVariable("X", 7)
+	if got, err := e.Evaluate(); err != nil || got != 14 {
+		t.Errorf("after SetVariable(X, 7) = %v, %v; want 14", got, err)
+	}
+	if len(ctx.Variables) != 2 {
+		t.Errorf("Variables = %v, want one entry per variable", ctx.Variables)
+	}
+}
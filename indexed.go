@@ -0,0 +1,190 @@
+package formula
+
+import (
+	"fmt"
+	"sync"
+)
+
+// IndexedFormula - формула, переменные которой привязаны к позициям в строке
+// значений. Подходит для пересчета одной формулы по множеству строк таблицы
+// без построения карты переменных для каждой строки.
+type IndexedFormula struct {
+	bound ASTNode // дерево со столбцами вместо переменных
+	width int
+	base  Context // копия контекста компиляции
+
+	// instances хранит скомпилированные экземпляры формулы, чтобы Eval не
+	// компилировал формулу и не копировал контекст при каждом вызове
+	instances sync.Pool
+}
+
+// indexedInstance - экземпляр формулы для одного вычисления за раз: его
+// столбцы читают значения из columns
+type indexedInstance struct {
+	eval    Evaluator
+	ctx     Context
+	columns *columnValues
+}
+
+// columnValues - источник значений столбцов текущего вычисления: строка row
+// для Eval или accessor и accessorRow для EvalRow
+type columnValues struct {
+	row         []float64
+	accessor    ColumnAccessor
+	accessorRow int
+}
+
+// CompileIndexed связывает переменные формулы с позициями в names: переменная
+// names[i] при вычислении берется из row[i]. Переменные, отсутствующие в names,
+// приводят к ошибке уже на этапе компиляции; константы E и PI доступны, если
+// столбцов с такими именами нет. Функции и настройки вычисления берутся из
+// NewContext(); другой контекст задается через CompileIndexedContext.
+func CompileIndexed(node ASTNode, names []string) (*IndexedFormula, error) {
+	return CompileIndexedContext(node, names, nil)
+}
+
+// CompileIndexedContext работает как CompileIndexed, но функции и настройки
+// вычисления (ModMode, MaxExponent, FixedScale, MaxDepth и т.д.) берутся из
+// копии ctx, сделанной при компиляции; nil означает NewContext().
+func CompileIndexedContext(node ASTNode, names []string, ctx *Context) (*IndexedFormula, error) {
+	if node == nil {
+		return nil, fmt.Errorf("cannot compile nil node")
+	}
+
+	columns := make(map[string]int, len(names))
+	for i, name := range names {
+		if _, exists := columns[name]; exists {
+			return nil, fmt.Errorf("duplicate column name '%s'", name)
+		}
+		columns[name] = i
+	}
+
+	bound, err := bindColumns(node, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx == nil {
+		ctx = NewContext()
+	}
+	f := &IndexedFormula{bound: bound, width: len(names), base: *ctx}
+	f.instances.New = func() interface{} {
+		return f.newInstance()
+	}
+	return f, nil
+}
+
+// newInstance компилирует экземпляр формулы со своими значениями столбцов
+// и копией контекста
+func (f *IndexedFormula) newInstance() *indexedInstance {
+	values := &columnValues{}
+	tree := attachColumns(f.bound, values)
+
+	// Дерево не nil, поэтому Compile не возвращает ошибку
+	eval, _ := Compile(tree)
+	return &indexedInstance{eval: eval, ctx: f.base, columns: values}
+}
+
+// bindColumns заменяет переменные из columns узлами, читающими значение по
+// позиции столбца
+func bindColumns(node ASTNode, columns map[string]int) (ASTNode, error) {
+	if v, ok := node.(*VariableNode); ok {
+		if index, ok := columns[v.Name]; ok {
+			return &columnNode{name: v.Name, index: index}, nil
+		}
+		if _, constant := constants[v.Name]; constant {
+			return v, nil
+		}
+		return nil, fmt.Errorf("variable '%s' is not bound to a column %w", v.Name, ErrNotFound)
+	}
+
+	var bindErr error
+	bound := mapChildren(node, func(child ASTNode) ASTNode {
+		result, err := bindColumns(child, columns)
+		if err != nil && bindErr == nil {
+			bindErr = err
+		}
+		return result
+	})
+	return bound, bindErr
+}
+
+// attachColumns возвращает копию дерева, столбцы которой читают значения из values
+func attachColumns(node ASTNode, values *columnValues) ASTNode {
+	if column, ok := node.(*columnNode); ok {
+		return &columnNode{name: column.name, index: column.index, values: values}
+	}
+	return mapChildren(node, func(child ASTNode) ASTNode {
+		return attachColumns(child, values)
+	})
+}
+
+// columnNode - переменная, привязанная к столбцу index строки IndexedFormula
+type columnNode struct {
+	name   string
+	index  int
+	values *columnValues
+}
+
+func (n *columnNode) Evaluate(ctx *Context) (float64, error) {
+	if n.values.accessor != nil {
+		return n.values.accessor.Value(n.name, n.values.accessorRow)
+	}
+	return n.values.row[n.index], nil
+}
+
+func (n *columnNode) GetType() NodeType {
+	return NodeTypeVariable
+}
+
+// Eval вычисляет формулу для строки значений, упорядоченных как names в CompileIndexed.
+// Eval безопасен для одновременного вызова из нескольких горутин.
+func (f *IndexedFormula) Eval(row []float64) (float64, error) {
+	if len(row) != f.width {
+		return 0, fmt.Errorf("row has %d values, expected %d", len(row), f.width)
+	}
+
+	instance := f.instances.Get().(*indexedInstance)
+	instance.columns.row = row
+	value, err := instance.eval(&instance.ctx)
+	instance.columns.row = nil
+	f.instances.Put(instance)
+	return value, err
+}
+
+// ColumnAccessor - колоночное хранилище: возвращает значение столбца name в
+// строке row. Позволяет вычислять формулу по столбцам любых числовых типов
+// ([]int32, []float64 и т.п.) без построения строки или карты переменных.
+type ColumnAccessor interface {
+	Value(name string, row int) (float64, error)
+}
+
+// ColumnFunc позволяет использовать функцию как ColumnAccessor:
+//
+//	prices := []int32{100, 250}
+//	accessor := ColumnFunc(func(name string, row int) (float64, error) {
+//		return float64(prices[row]), nil
+//	})
+type ColumnFunc func(name string, row int) (float64, error)
+
+func (f ColumnFunc) Value(name string, row int) (float64, error) {
+	return f(name, row)
+}
+
+// EvalRow вычисляет формулу для строки row колоночного хранилища accessor.
+// Значения запрашиваются только для переменных, которые понадобились при
+// вычислении; имена столбцов - names из CompileIndexed. Ошибка accessor
+// возвращается как ошибка вычисления. EvalRow безопасен для одновременного
+// вызова из нескольких горутин, если это допускает accessor.
+func (f *IndexedFormula) EvalRow(accessor ColumnAccessor, row int) (float64, error) {
+	if accessor == nil {
+		return 0, fmt.Errorf("column accessor is nil")
+	}
+
+	instance := f.instances.Get().(*indexedInstance)
+	instance.columns.accessor, instance.columns.accessorRow = accessor, row
+	value, err := instance.eval(&instance.ctx)
+	instance.columns.accessor = nil
+	f.instances.Put(instance)
+	return value, err
+}
@@ -0,0 +1,146 @@
+package formula
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestIndexedFormula(t *testing.T) {
+	node := mustParse(t, "IF(price > 100, price * qty * 0.9, price * qty) + PI * 0")
+	formula, err := CompileIndexed(node, []string{"qty", "price"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := [][]float64{{2, 50}, {3, 200}, {0, 500}}
+	for _, row := range rows {
+		got, err := formula.Eval(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := evalFormula(t, "IF(price > 100, price * qty * 0.9, price * qty) + PI * 0",
+			map[string]float64{"qty": row[0], "price": row[1]})
+		if got != want {
+			t.Errorf("Eval(%v) = %v, want %v", row, got, want)
+		}
+	}
+
+	if _, err := formula.Eval([]float64{1}); err == nil {
+		t.Error("short row: expected error")
+	}
+
+	values := map[string][]float64{"qty": {4}, "price": {10}}
+	accessor := ColumnFunc(func(name string, row int) (float64, error) {
+		return values[name][row], nil
+	})
+	if got, err := formula.EvalRow(accessor, 0); err != nil || got != 40 {
+		t.Errorf("EvalRow = %v, %v; want 40", got, err)
+	}
+}
+
+func TestCompileIndexedErrors(t *testing.T) {
+	if _, err := CompileIndexed(mustParse(t, "a + b"), []string{"a"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unbound variable: error %v, want ErrNotFound", err)
+	}
+	if _, err := CompileIndexed(mustParse(t, "a"), []string{"a", "a"}); err == nil {
+		t.Error("duplicate column: expected error")
+	}
+}
+
+// Настройки и функции контекста компиляции должны действовать при вычислении
+func TestCompileIndexedUsesContext(t *testing.T) {
+	ctx := NewContext()
+	ctx.Functions["double"] = func(args []float64) (float64, error) {
+		return 2 * args[0], nil
+	}
+	scale := 2
+	ctx.FixedScale = &scale
+
+	formula, err := CompileIndexedContext(mustParse(t, "double(x) / 3"), []string{"x"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := formula.Eval([]float64{1}); err != nil || got != 0.67 {
+		t.Errorf("double(1) / 3 with FixedScale 2 = %v, %v; want 0.67", got, err)
+	}
+
+	// Дерево глубже MaxDepth контекста отклоняется при вычислении
+	deep := &OperationNode{Operator: "+", Left: deepSum(5000), Right: &VariableNode{Name: "x"}}
+	ctx.MaxDepth = 100
+	limited, err := CompileIndexedContext(deep, []string{"x"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limited.Eval([]float64{1}); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("MaxDepth 100: error %v, want ErrMaxDepth", err)
+	}
+}
+
+// Значения строки не хранятся в Context: одновременные Eval и EvalRow не
+// видят строки друг друга, а контекст компиляции не меняется
+func TestIndexedFormulaConcurrent(t *testing.T) {
+	ctx := NewContext()
+	formula, err := CompileIndexedContext(mustParse(t, "qty * price + 1"), []string{"qty", "price"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			qty := float64(g)
+			accessor := ColumnFunc(func(name string, row int) (float64, error) {
+				if name == "qty" {
+					return qty, nil
+				}
+				return float64(row), nil
+			})
+			for i := 0; i < 200; i++ {
+				if got, err := formula.Eval([]float64{qty, float64(i)}); err != nil || got != qty*float64(i)+1 {
+					t.Errorf("Eval(%v, %v) = %v, %v", qty, i, got, err)
+					return
+				}
+				if got, err := formula.EvalRow(accessor, i); err != nil || got != qty*float64(i)+1 {
+					t.Errorf("EvalRow(qty %v, row %v) = %v, %v", qty, i, got, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if len(ctx.Variables) != 0 {
+		t.Errorf("compile context variables changed: %v", ctx.Variables)
+	}
+}
+
+const benchmarkFormula = "IF(price > 100, price * qty * (1 - discount), price * qty) + tax * price"
+
+func BenchmarkIndexedEval(b *testing.B) {
+	formula, err := CompileIndexed(mustParse(b, benchmarkFormula), []string{"price", "qty", "discount", "tax"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	row := []float64{150, 3, 0.1, 0.2}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := formula.Eval(row); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMapEval(b *testing.B) {
+	node := mustParse(b, benchmarkFormula)
+	ctx := NewContext()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx.Variables = map[string]float64{"price": 150, "qty": 3, "discount": 0.1, "tax": 0.2}
+		if _, err := node.Evaluate(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
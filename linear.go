@@ -0,0 +1,117 @@
+package formula
+
+import "math"
+
+// linearForm - линейная комбинация переменных: sum(coeffs[v] * v) + constant
+type linearForm struct {
+	coeffs   map[string]float64
+	constant float64
+}
+
+// LinearCoefficients раскладывает линейную формулу на коэффициенты при
+// переменных и свободный член: для "2*a + 3*b - 5" вернет {a: 2, b: 3}, -5.
+// Если формула не является линейной (произведение переменных, функции,
+// условия и т.п.), ok равен false.
+func LinearCoefficients(node ASTNode) (coeffs map[string]float64, constant float64, ok bool) {
+	form, ok := linearize(node)
+	if !ok {
+		return nil, 0, false
+	}
+
+	coeffs = make(map[string]float64, len(form.coeffs))
+	for name, coeff := range form.coeffs {
+		if coeff != 0 {
+			coeffs[name] = coeff
+		}
+	}
+	return coeffs, form.constant, true
+}
+
+func linearize(node ASTNode) (linearForm, bool) {
+	switch n := node.(type) {
+	case *LiteralNode:
+		return linearForm{constant: n.Value}, true
+
+	case *VariableNode:
+		return linearForm{coeffs: map[string]float64{n.Name: 1}}, true
+
+	case *UnaryNode:
+		operand, ok := linearize(n.Operand)
+		if !ok {
+			return linearForm{}, false
+		}
+		switch n.Operator {
+		case "-":
+			return operand.scale(-1), true
+		case "+":
+			return operand, true
+		}
+		return linearForm{}, false
+
+	case *OperationNode:
+		left, ok := linearize(n.Left)
+		if !ok {
+			return linearForm{}, false
+		}
+		right, ok := linearize(n.Right)
+		if !ok {
+			return linearForm{}, false
+		}
+
+		switch n.Operator {
+		case "+":
+			return left.add(right, 1), true
+		case "-":
+			return left.add(right, -1), true
+		case "*":
+			if left.isConstant() {
+				return right.scale(left.constant), true
+			}
+			if right.isConstant() {
+				return left.scale(right.constant), true
+			}
+		case "/":
+			if right.isConstant() && right.constant != 0 {
+				return left.scale(1 / right.constant), true
+			}
+		case "^", "**":
+			if left.isConstant() && right.isConstant() {
+				return linearForm{constant: math.Pow(left.constant, right.constant)}, true
+			}
+			if right.isConstant() && right.constant == 1 {
+				return left, true
+			}
+		}
+		return linearForm{}, false
+
+	default:
+		return linearForm{}, false
+	}
+}
+
+func (f linearForm) isConstant() bool {
+	for _, coeff := range f.coeffs {
+		if coeff != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f linearForm) scale(factor float64) linearForm {
+	result := linearForm{coeffs: make(map[string]float64, len(f.coeffs)), constant: f.constant * factor}
+	for name, coeff := range f.coeffs {
+		result.coeffs[name] = coeff * factor
+	}
+	return result
+}
+
+// add складывает (sign = 1) или вычитает (sign = -1) линейные формы
+func (f linearForm) add(other linearForm, sign float64) linearForm {
+	result := f.scale(1)
+	result.constant += sign * other.constant
+	for name, coeff := range other.coeffs {
+		result.coeffs[name] += sign * coeff
+	}
+	return result
+}
@@ -0,0 +1,128 @@
+package formula
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FormatSpec задает вид числа при выводе результата
+type FormatSpec struct {
+	// Decimals - число знаков после десятичного разделителя, значение
+	// округляется до них, половина - от нуля: 2.5 → 3, 2.675 → 2.68 при
+	// Decimals = 2. Нулевое значение, в том числе у FormatSpec{}, выводит целое
+	// число. Отрицательное значение - столько знаков, сколько нужно для
+	// точного представления числа
+	Decimals int
+	// Grouping разделяет разряды тысяч в целой части: 1234567 → 1 234 567
+	Grouping bool
+}
+
+// numberSymbols - десятичный разделитель и разделитель разрядов локали
+type numberSymbols struct {
+	decimal, group string
+}
+
+// localeSymbols - разделители по языку или по языку и региону. Поиск ведется
+// сначала по полному имени локали ("de-ch"), затем по языку ("de")
+var localeSymbols = map[string]numberSymbols{
+	"en":    {decimal: ".", group: ","},
+	"ru":    {decimal: ",", group: " "},
+	"uk":    {decimal: ",", group: " "},
+	"de":    {decimal: ",", group: "."},
+	"de-ch": {decimal: ".", group: "’"},
+	"fr":    {decimal: ",", group: " "},
+	"es":    {decimal: ",", group: "."},
+	"it":    {decimal: ",", group: "."},
+	"pt":    {decimal: ",", group: "."},
+	"nl":    {decimal: ",", group: "."},
+	"pl":    {decimal: ",", group: " "},
+}
+
+// FormatResult выводит результат вычисления с точкой в качестве десятичного
+// разделителя и запятой между разрядами: 1,234.56
+func FormatResult(value float64, spec FormatSpec) string {
+	return formatResult(value, spec, localeSymbols["en"])
+}
+
+// FormatResultLocale выводит результат вычисления с разделителями локали
+// locale: "de" дает 1.234,56, "ru" - 1 234,56 (с неразрывным пробелом),
+// "en" - 1,234.56. Локаль задается как "ru", "ru-RU" или "ru_RU" без учета
+// регистра; для неизвестной локали используются разделители "en". NaN и
+// бесконечности выводятся как NaN, +Inf и -Inf.
+func FormatResultLocale(value float64, spec FormatSpec, locale string) string {
+	return formatResult(value, spec, lookupLocale(locale))
+}
+
+// lookupLocale возвращает разделители локали
+func lookupLocale(locale string) numberSymbols {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if symbols, ok := localeSymbols[locale]; ok {
+		return symbols
+	}
+	language, _, _ := strings.Cut(locale, "-")
+	if symbols, ok := localeSymbols[language]; ok {
+		return symbols
+	}
+	return localeSymbols["en"]
+}
+
+func formatResult(value float64, spec FormatSpec, symbols numberSymbols) string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	precision := spec.Decimals
+	if precision < 0 {
+		precision = -1
+	} else if rounded := roundToScale(value, precision); !math.IsInf(rounded, 0) && !math.IsNaN(rounded) {
+		// FormatFloat округляет половину до четного и по двоичному
+		// представлению: 2.5 → "2", 2.675 → "2.67"
+		value = rounded
+	}
+	text := strconv.FormatFloat(value, 'f', precision, 64)
+
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+	integer, fraction, _ := strings.Cut(text, ".")
+
+	// После округления -0.001 превращается в "-0.00": знак у нуля не выводится
+	if negative && strings.Trim(integer+fraction, "0") == "" {
+		negative = false
+	}
+
+	if spec.Grouping {
+		integer = groupDigits(integer, symbols.group)
+	}
+
+	var b strings.Builder
+	if negative {
+		b.WriteByte('-')
+	}
+	b.WriteString(integer)
+	if fraction != "" {
+		b.WriteString(symbols.decimal)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
+
+// groupDigits разделяет цифры целой части на группы по три справа налево
+func groupDigits(digits, separator string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+
+	var b strings.Builder
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(separator)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
@@ -0,0 +1,48 @@
+package formula
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormatResultLocale(t *testing.T) {
+	spec := FormatSpec{Decimals: 2, Grouping: true}
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"en", "1,234,567.89"},
+		{"de", "1.234.567,89"},
+		{"ru-RU", "1\u00a0234\u00a0567,89"},
+		{"de_CH", "1’234’567.89"},
+		{"xx", "1,234,567.89"},
+	}
+	for _, tt := range tests {
+		if got := FormatResultLocale(1234567.891, spec, tt.locale); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestFormatResultRounding(t *testing.T) {
+	tests := []struct {
+		value float64
+		spec  FormatSpec
+		want  string
+	}{
+		{2.5, FormatSpec{}, "3"},
+		{-2.5, FormatSpec{}, "-3"},
+		{0.5, FormatSpec{}, "1"},
+		{2.675, FormatSpec{Decimals: 2}, "2.68"},
+		{1.005, FormatSpec{Decimals: 2}, "1.01"},
+		{-0.001, FormatSpec{Decimals: 2}, "0.00"},
+		{0.30000000000000004, FormatSpec{Decimals: -1}, "0.30000000000000004"},
+		{1e20, FormatSpec{Decimals: 2}, "100000000000000000000.00"},
+		{math.Inf(-1), FormatSpec{Decimals: 2}, "-Inf"},
+	}
+	for _, tt := range tests {
+		if got := FormatResult(tt.value, tt.spec); got != tt.want {
+			t.Errorf("FormatResult(%v, %+v) = %q, want %q", tt.value, tt.spec, got, tt.want)
+		}
+	}
+}
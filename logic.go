@@ -0,0 +1,112 @@
+package formula
+
+// NormalizeLogic приводит логическую часть формулы к нормальному виду:
+//   - NOT вносится внутрь AND и OR по законам де Моргана:
+//     NOT (A AND B) → NOT A OR NOT B;
+//   - двойное отрицание NOT NOT A убирается;
+//   - вложенные AND и OR одного вида выстраиваются в одну цепочку слева
+//     направо: A AND (B AND C) → A AND B AND C.
+//
+// Порядок операндов сохраняется, поэтому короткое замыкание и ошибки
+// вычисления не меняются. Сравнения не инвертируются: NOT (a > b) не равно
+// a <= b, если одно из значений NaN. NOT NOT A заменяется на A, только если A -
+// логическое значение или важна лишь его истинность (операнд AND, OR, NOT или
+// условие IF): NOT NOT 5 равно 1, а не 5. Результат совпадает с исходной
+// формулой при вычислении без Context.Fuzzy. Исходное дерево не изменяется.
+func NormalizeLogic(node ASTNode) ASTNode {
+	if node == nil {
+		return nil
+	}
+	return normalizeLogic(node, false)
+}
+
+// normalizeLogic нормализует узел; truth означает, что важна только
+// истинность значения узла, а не само значение
+func normalizeLogic(node ASTNode, truth bool) ASTNode {
+	switch n := node.(type) {
+	case *UnaryNode:
+		if n.Operator == "NOT" {
+			return negateLogic(n.Operand, n.Keyword, truth)
+		}
+
+	case *LogicalNode:
+		operands := []ASTNode{normalizeLogic(n.Left, true), normalizeLogic(n.Right, true)}
+		return chainLogic(n.Operator, n.Keyword, flattenOperands(n.Operator, operands))
+
+	case *ConditionalNode:
+		c := *n
+		c.Condition = normalizeLogic(n.Condition, true)
+		c.Then = normalizeChild(n.Then)
+		c.Else = normalizeChild(n.Else)
+		return &c
+	}
+
+	return mapChildren(node, normalizeChild)
+}
+
+func normalizeChild(node ASTNode) ASTNode {
+	if node == nil {
+		return nil
+	}
+	return normalizeLogic(node, false)
+}
+
+// negateLogic возвращает нормализованное отрицание узла; keyword - написание
+// NOT в исходной формуле
+func negateLogic(node ASTNode, keyword string, truth bool) ASTNode {
+	switch n := node.(type) {
+	case *UnaryNode:
+		if n.Operator == "NOT" && (truth || isBooleanResult(n.Operand)) {
+			return normalizeLogic(n.Operand, truth)
+		}
+
+	case *LogicalNode:
+		// Де Морган: отрицание меняет AND на OR и наоборот
+		operator, swapped := "OR", languageKeywords[keywordLanguage(n.Keyword)].Or
+		if n.Operator == "OR" {
+			operator, swapped = "AND", languageKeywords[keywordLanguage(n.Keyword)].And
+		}
+		if n.Keyword == "" {
+			swapped = ""
+		}
+		if keyword == "" && n.Keyword != "" {
+			keyword = languageKeywords[keywordLanguage(n.Keyword)].Not
+		}
+
+		var operands []ASTNode
+		for _, operand := range []ASTNode{n.Left, n.Right} {
+			operands = append(operands, negateLogic(operand, keyword, true))
+		}
+		return chainLogic(operator, swapped, flattenOperands(operator, operands))
+	}
+
+	return &UnaryNode{Operator: "NOT", Operand: normalizeLogic(node, true), Keyword: keyword}
+}
+
+// flattenOperands раскрывает операнды, которые сами являются цепочкой
+// operator, сохраняя порядок: [A AND B, C] → [A, B, C]
+func flattenOperands(operator string, operands []ASTNode) []ASTNode {
+	var result []ASTNode
+	var add func(node ASTNode)
+	add = func(node ASTNode) {
+		if n, ok := node.(*LogicalNode); ok && n.Operator == operator {
+			add(n.Left)
+			add(n.Right)
+			return
+		}
+		result = append(result, node)
+	}
+	for _, operand := range operands {
+		add(operand)
+	}
+	return result
+}
+
+// chainLogic строит цепочку operator слева направо
+func chainLogic(operator, keyword string, operands []ASTNode) ASTNode {
+	result := operands[0]
+	for _, operand := range operands[1:] {
+		result = &LogicalNode{Operator: operator, Left: result, Right: operand, Keyword: keyword}
+	}
+	return result
+}
@@ -0,0 +1,54 @@
+package formula
+
+import "testing"
+
+func TestNormalizeLogic(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    string
+	}{
+		{"NOT (A AND B)", "NOT A OR NOT B"},
+		{"NOT (A OR B)", "NOT A AND NOT B"},
+		{"NOT (A AND (B OR C))", "NOT A OR NOT B AND NOT C"},
+		{"NOT NOT (a > 1)", "a > 1"},
+		{"NOT NOT A AND B", "A AND B"},
+		{"IF(NOT NOT x, 1, 2)", "IF x THEN 1 ELSE 2"},
+		{"A AND (B AND C)", "A AND B AND C"},
+		{"НЕ (A И B)", "НЕ A ИЛИ НЕ B"},
+
+		// NOT NOT 5 равно 1, а не 5: двойное отрицание числа сохраняется
+		{"NOT NOT 5", "NOT NOT 5"},
+		{"NOT NOT x + 1", "NOT NOT x + 1"},
+	}
+	for _, tt := range tests {
+		node := mustParse(t, tt.formula)
+		before := formatter{}.format(node)
+		got := formatter{}.format(NormalizeLogic(node))
+		if got != tt.want {
+			t.Errorf("NormalizeLogic(%q) = %q, want %q", tt.formula, got, tt.want)
+		}
+		if after := (formatter{}).format(node); after != before {
+			t.Errorf("NormalizeLogic(%q) changed the source tree to %q", tt.formula, after)
+		}
+	}
+}
+
+// Нормализованная формула вычисляется так же, как исходная
+func TestNormalizeLogicPreservesValue(t *testing.T) {
+	formulas := []string{"NOT (A AND B)", "NOT (A OR NOT B)", "NOT NOT 5", "IF(NOT NOT A, 3, 4)", "NOT NOT B * 7"}
+	for _, formula := range formulas {
+		normalized := NormalizeLogic(mustParse(t, formula))
+		for _, vars := range []map[string]float64{{"A": 0, "B": 0}, {"A": 1, "B": 0}, {"A": 2, "B": 5}} {
+			got, err := normalized.Evaluate(&Context{Variables: vars})
+			if err != nil {
+				t.Fatalf("%v: %v", normalized, err)
+			}
+			if want := evalFormula(t, formula, vars); got != want {
+				t.Errorf("%v with %v = %v, want %v as for %q", normalized, vars, got, want, formula)
+			}
+		}
+	}
+	if NormalizeLogic(nil) != nil {
+		t.Error("NormalizeLogic(nil) != nil")
+	}
+}
@@ -1,9 +1,13 @@
 package formula
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode"
 )
 
@@ -24,52 +28,105 @@ const (
 	TokenElse
 	TokenOr
 	TokenAnd
+	TokenIllegal
+	TokenBar
+	TokenNot
+	TokenPipe
+	TokenTrue
+	TokenFalse
 )
 
 // Token represents a token in the formula
 type Token struct {
 	Type  TokenType
 	Value string
-	Pos   int
+	Pos   int // rune offset of the first character in the original input
+	End   int // rune offset just past the last character in the original input
+	Arity int // number of operands for operator and function tokens produced by ToRPN
 }
 
 // Lexer tokenizes the input formula
 type Lexer struct {
-	input string
-	pos   int
-	runes []rune
+	input   string
+	pos     int
+	runes   []rune
+	offsets []int // offsets[i] is the position of runes[i] in the original input
+	base    int   // position of the first non-space rune in the original input
+
+	// openers holds the '(' and opening '|' not closed yet, innermost last, and
+	// afterOperand reports that the last token completed an operand. Together
+	// they tell a closing bar from an opening one and "|>" from "|" ">"
+	openers      []rune
+	afterOperand bool
 }
 
 func NewLexer(input string) *Lexer {
-	// Don't remove ALL spaces - only trim and normalize
-	cleanInput := strings.TrimSpace(input)
-	// Replace multiple spaces with single space, then remove spaces around operators
-	cleanInput = normalizeSpaces(cleanInput)
+	// Don't remove ALL spaces - only trim and normalize, remembering
+	// where every kept rune came from so token positions match the input
+	runes, offsets, base := normalizeSpaces([]rune(input))
 	return &Lexer{
-		input: cleanInput,
-		pos:   0,
-		runes: []rune(cleanInput),
+		input:   string(runes),
+		pos:     0,
+		runes:   runes,
+		offsets: offsets,
+		base:    base,
 	}
 }
 
-// normalizeSpaces removes spaces around operators but keeps spaces between words and numbers
-func normalizeSpaces(input string) string {
-	// Keep spaces that separate letters from numbers
-	result := make([]rune, 0, len(input))
-	runes := []rune(input)
+// normalizeSpaces trims the input and removes spaces around operators but keeps
+// spaces between words and numbers. It also returns the original offset of every
+// kept rune and the offset of the first non-space rune.
+func normalizeSpaces(input []rune) ([]rune, []int, int) {
+	start, end := 0, len(input)
+	for start < end && unicode.IsSpace(input[start]) {
+		start++
+	}
+	for end > start && unicode.IsSpace(input[end-1]) {
+		end--
+	}
 
-	for i, r := range runes {
+	// Keep spaces that separate letters from numbers
+	result := make([]rune, 0, end-start)
+	offsets := make([]int, 0, end-start)
+	quoted := false
+
+	for i := start; i < end; i++ {
+		r := input[i]
+		if r == '`' {
+			quoted = !quoted
+		}
+		// Quoted identifiers are kept verbatim
+		if quoted && r == ' ' {
+			result = append(result, r)
+			offsets = append(offsets, i)
+			continue
+		}
 		if r == ' ' {
 			// Check if we should keep this space
-			if i > 0 && i < len(runes)-1 {
-				prev := runes[i-1]
-				next := runes[i+1]
+			if i > start && i < end-1 {
+				prev := input[i-1]
+				next := input[i+1]
 
 				// Keep space if it separates a letter from a number or vice versa
 				if (unicode.IsLetter(prev) && unicode.IsDigit(next)) ||
 					(unicode.IsDigit(prev) && unicode.IsLetter(next)) ||
 					(unicode.IsLetter(prev) && unicode.IsLetter(next)) {
 					result = append(result, r)
+					offsets = append(offsets, i)
+					continue
+				}
+			}
+			// Keep one space between operator characters that would otherwise
+			// join into a different operator: "a/ /b" is not "a//b", "a * *b"
+			// is not "a**b" and "|x| > y" is not a pipe
+			if i > start && input[i-1] != ' ' {
+				next := i + 1
+				for next < end && input[next] == ' ' {
+					next++
+				}
+				if next < end && joinsOperator(input[i-1], input[next]) {
+					result = append(result, r)
+					offsets = append(offsets, i)
 					continue
 				}
 			}
@@ -77,25 +134,91 @@ func normalizeSpaces(input string) string {
 			continue
 		}
 		result = append(result, r)
+		offsets = append(offsets, i)
+	}
+
+	return result, offsets, start
+}
+
+// joinsOperator reports whether two operator characters written next to each
+// other form a two-character operator that is not a pair of separate ones
+func joinsOperator(prev, next rune) bool {
+	switch string([]rune{prev, next}) {
+	case "//", "**", "|>":
+		return true
 	}
+	return false
+}
 
-	return string(result)
+// offset converts an index into the normalized runes to a position in the original input
+func (l *Lexer) offset(i int) int {
+	if i < len(l.offsets) {
+		return l.offsets[i]
+	}
+	if len(l.offsets) == 0 {
+		return l.base
+	}
+	return l.offsets[len(l.offsets)-1] + 1
+}
+
+// token builds a token spanning the normalized runes from start up to the current position
+func (l *Lexer) token(tokenType TokenType, value string, start int) Token {
+	pos := l.offset(start)
+	end := pos
+	if l.pos > start {
+		end = l.offset(l.pos-1) + 1
+	}
+	return Token{Type: tokenType, Value: value, Pos: pos, End: end}
 }
 
 func (l *Lexer) NextToken() Token {
+	token := l.scan()
+	switch token.Type {
+	case TokenParenOpen:
+		l.openers = append(l.openers, '(')
+	case TokenParenClose:
+		if n := len(l.openers); n > 0 && l.openers[n-1] == '(' {
+			l.openers = l.openers[:n-1]
+		}
+	}
+	if token.Type != TokenBar {
+		switch token.Type {
+		case TokenNumber, TokenVariable, TokenParenClose, TokenTrue, TokenFalse:
+			l.afterOperand = true
+		default:
+			l.afterOperand = false
+		}
+	}
+	return token
+}
+
+// inAbs reports whether the innermost open construct is a |x| bar
+func (l *Lexer) inAbs() bool {
+	return len(l.openers) > 0 && l.openers[len(l.openers)-1] == '|'
+}
+
+// spaceBefore reports whether the original input has whitespace right before
+// the current rune, including spaces dropped by normalizeSpaces
+func (l *Lexer) spaceBefore() bool {
+	return l.pos > 0 && (unicode.IsSpace(l.runes[l.pos-1]) || l.offsets[l.pos] > l.offsets[l.pos-1]+1)
+}
+
+// scan reads the next token
+func (l *Lexer) scan() Token {
 	// Skip whitespace
 	for l.pos < len(l.runes) && unicode.IsSpace(l.runes[l.pos]) {
 		l.pos++
 	}
 
 	if l.pos >= len(l.runes) {
-		return Token{TokenEOF, "", l.pos}
+		return l.token(TokenEOF, "", l.pos)
 	}
 
 	char := l.runes[l.pos]
 
-	// Numbers (including decimals)
-	if unicode.IsDigit(char) {
+	// Numbers (including decimals and malformed ones starting with a dot, like ..5)
+	if unicode.IsDigit(char) || (char == '.' && l.pos+1 < len(l.runes) &&
+		(unicode.IsDigit(l.runes[l.pos+1]) || l.runes[l.pos+1] == '.')) {
 		return l.readNumber()
 	}
 
@@ -104,38 +227,97 @@ func (l *Lexer) NextToken() Token {
 		return l.readIdentifier()
 	}
 
+	// Quoted identifiers like `total amount`
+	if char == '`' {
+		return l.readQuotedIdentifier()
+	}
+
 	// Single character tokens
 	switch char {
-	case '+', '-', '*', '/', '>', '<', '=', '!':
+	case '+', '-', '*', '/', '%', '^', '>', '<', '=', '!':
 		return l.readOperator()
 	case '(':
 		l.pos++
-		return Token{TokenParenOpen, "(", l.pos - 1}
+		return l.token(TokenParenOpen, "(", l.pos-1)
 	case ')':
 		l.pos++
-		return Token{TokenParenClose, ")", l.pos - 1}
+		return l.token(TokenParenClose, ")", l.pos-1)
 	case ',':
 		l.pos++
-		return Token{TokenComma, ",", l.pos - 1}
+		return l.token(TokenComma, ",", l.pos-1)
+	case '|':
+		// "|>" after a complete operand is the pipe operator. Inside bars it
+		// is a pipe only when written after a space, "|x |> sqrt|", so that
+		// "|x|>1" stays abs(x) > 1. Otherwise a bar after a complete operand
+		// closes the innermost open bar, and any other bar opens a new pair:
+		// ||x| - |y|| nests correctly.
+		pipe := l.afterOperand && l.pos+1 < len(l.runes) && l.runes[l.pos+1] == '>'
+		switch {
+		case pipe && (!l.inAbs() || l.spaceBefore()):
+			l.pos += 2
+			return l.token(TokenPipe, "|>", l.pos-2)
+		case l.afterOperand && l.inAbs():
+			l.openers = l.openers[:len(l.openers)-1]
+			l.afterOperand = true
+		default:
+			l.openers = append(l.openers, '|')
+			l.afterOperand = false
+		}
+		l.pos++
+		return l.token(TokenBar, "|", l.pos-1)
 	}
 
 	// Skip unknown characters
 	l.pos++
-	return l.NextToken()
+	return l.scan()
 }
 
+// readNumber reads a decimal number with an optional exponent. The letter E
+// directly after a number is disambiguated as follows:
+//   - followed by digits, optionally signed, it is an exponent: 2e3, 1.5E-7
+//   - followed by a letter it starts a new identifier: 2ELSE is 2 then ELSE,
+//     and "1 else 2" keeps the space, so ELSE is never read as an exponent
+//   - otherwise (2E, 2e+, 2E)) the number is malformed and TokenIllegal is returned
+//
+// A number with more than one dot (1.2.3, ..5) is malformed as well.
+//
+// The constant e is therefore written as a separate word: 2 ^ E, 2 * E.
 func (l *Lexer) readNumber() Token {
 	start := l.pos
 	for l.pos < len(l.runes) && (unicode.IsDigit(l.runes[l.pos]) || l.runes[l.pos] == '.') {
 		l.pos++
 	}
-	return Token{TokenNumber, string(l.runes[start:l.pos]), start}
+	if strings.Count(string(l.runes[start:l.pos]), ".") > 1 {
+		return l.token(TokenIllegal, string(l.runes[start:l.pos]), start)
+	}
+
+	if l.pos < len(l.runes) && (l.runes[l.pos] == 'e' || l.runes[l.pos] == 'E') {
+		digits := l.pos + 1
+		if digits < len(l.runes) && (l.runes[digits] == '+' || l.runes[digits] == '-') {
+			digits++
+		}
+		switch {
+		case digits < len(l.runes) && unicode.IsDigit(l.runes[digits]):
+			l.pos = digits
+			for l.pos < len(l.runes) && unicode.IsDigit(l.runes[l.pos]) {
+				l.pos++
+			}
+		case l.pos+1 < len(l.runes) && unicode.IsLetter(l.runes[l.pos+1]):
+			// An identifier follows the number
+		default:
+			l.pos = digits
+			return l.token(TokenIllegal, string(l.runes[start:l.pos]), start)
+		}
+	}
+
+	return l.token(TokenNumber, string(l.runes[start:l.pos]), start)
 }
 
 func (l *Lexer) readIdentifier() Token {
 	start := l.pos
-	// Read only letters and underscores for identifiers - no digits
-	for l.pos < len(l.runes) && (unicode.IsLetter(l.runes[l.pos]) || l.runes[l.pos] == '_') {
+	// Identifiers start with a letter and may continue with letters, digits and underscores
+	// (score2, log10, deg2rad). A dot followed by a letter joins path segments: user.profile.age
+	for l.pos < len(l.runes) && (l.isIdentifierRune(l.runes[l.pos]) || l.isPathDot()) {
 		l.pos++
 	}
 
@@ -145,29 +327,41 @@ func (l *Lexer) readIdentifier() Token {
 	// Check for Russian keywords
 	switch upperValue {
 	case "ЕСЛИ":
-		return Token{TokenIf, value, start}
+		return l.token(TokenIf, value, start)
 	case "ТОГДА":
-		return Token{TokenThen, value, start}
+		return l.token(TokenThen, value, start)
 	case "ИНАЧЕ":
-		return Token{TokenElse, value, start}
+		return l.token(TokenElse, value, start)
 	case "ИЛИ":
-		return Token{TokenOr, value, start}
+		return l.token(TokenOr, value, start)
 	case "И":
-		return Token{TokenAnd, value, start}
+		return l.token(TokenAnd, value, start)
+	case "НЕ":
+		return l.token(TokenNot, value, start)
+	case "ИСТИНА":
+		return l.token(TokenTrue, value, start)
+	case "ЛОЖЬ":
+		return l.token(TokenFalse, value, start)
 	}
 
 	// Check for English keywords
 	switch upperValue {
 	case "IF":
-		return Token{TokenIf, value, start}
+		return l.token(TokenIf, value, start)
 	case "THEN":
-		return Token{TokenThen, value, start}
+		return l.token(TokenThen, value, start)
 	case "ELSE":
-		return Token{TokenElse, value, start}
+		return l.token(TokenElse, value, start)
 	case "OR":
-		return Token{TokenOr, value, start}
+		return l.token(TokenOr, value, start)
 	case "AND":
-		return Token{TokenAnd, value, start}
+		return l.token(TokenAnd, value, start)
+	case "NOT":
+		return l.token(TokenNot, value, start)
+	case "TRUE":
+		return l.token(TokenTrue, value, start)
+	case "FALSE":
+		return l.token(TokenFalse, value, start)
 	}
 
 	// Check if it's a function (followed by parenthesis)
@@ -177,10 +371,44 @@ func (l *Lexer) readIdentifier() Token {
 		tempPos++
 	}
 	if tempPos < len(l.runes) && l.runes[tempPos] == '(' {
-		return Token{TokenFunction, value, start}
+		return l.token(TokenFunction, value, start)
+	}
+
+	return l.token(TokenVariable, value, start)
+}
+
+func (l *Lexer) isIdentifierRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// isPathDot reports whether the current rune is a dot separating segments of a dotted name
+func (l *Lexer) isPathDot() bool {
+	return l.runes[l.pos] == '.' && l.pos+1 < len(l.runes) && unicode.IsLetter(l.runes[l.pos+1])
+}
+
+// readQuotedIdentifier reads a backtick-delimited variable name. The name may contain
+// any characters; a doubled backtick inside the quotes stands for a literal backtick.
+func (l *Lexer) readQuotedIdentifier() Token {
+	start := l.pos
+	l.pos++ // consume opening backtick
+
+	var name []rune
+	for l.pos < len(l.runes) {
+		r := l.runes[l.pos]
+		if r == '`' {
+			if l.pos+1 < len(l.runes) && l.runes[l.pos+1] == '`' {
+				name = append(name, '`')
+				l.pos += 2
+				continue
+			}
+			l.pos++ // consume closing backtick
+			return l.token(TokenVariable, string(name), start)
+		}
+		name = append(name, r)
+		l.pos++
 	}
 
-	return Token{TokenVariable, value, start}
+	return l.token(TokenIllegal, string(l.runes[start:l.pos]), start)
 }
 
 func (l *Lexer) readOperator() Token {
@@ -190,166 +418,444 @@ func (l *Lexer) readOperator() Token {
 	if l.pos+1 < len(l.runes) {
 		twoChar := string(l.runes[l.pos : l.pos+2])
 		switch twoChar {
-		case ">=", "<=", "==", "!=":
+		case ">=", "<=", "==", "!=", "<>", "**", "//":
 			l.pos += 2
-			return Token{TokenOperator, twoChar, start}
+			return l.token(TokenOperator, twoChar, start)
 		}
 	}
 
 	l.pos++
-	return Token{TokenOperator, string(l.runes[start]), start}
+	return l.token(TokenOperator, string(l.runes[start]), start)
 }
 
 // Removed isDigit and isLetter functions - using unicode package instead
 
+// Span is a half-open range of rune offsets [Start, End) in the original input
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// ErrAmbiguousLogic is returned in strict logic mode when AND and OR are mixed
+// at the same level without parentheses
+var ErrAmbiguousLogic = errors.New("AMBIGUOUS_LOGIC")
+
+// ErrUnknownFunction is returned in strict function mode for calls to unregistered functions
+var ErrUnknownFunction = errors.New("unknown function")
+
+// ParseError is returned by the parser for every syntax error. Pos is the rune
+// offset in the original input of Token, the token at which the error was detected,
+// so a UI can highlight it. Sentinel errors such as ErrAmbiguousLogic are still
+// matched with errors.Is.
+type ParseError struct {
+	Message string
+	Pos     int
+	Token   Token
+	err     error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse error at position %d: %s", e.Pos, e.Message)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.err
+}
+
+// errorAt returns a ParseError at token; the format may wrap a sentinel error with %w
+func errorAt(token Token, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	return &ParseError{Message: err.Error(), Pos: token.Pos, Token: token, err: errors.Unwrap(err)}
+}
+
+// errorf returns a ParseError at the current token
+func (p *Parser) errorf(format string, args ...interface{}) error {
+	return errorAt(p.current, format, args...)
+}
+
+// wrapError prefixes a nested parse error with context, keeping the position
+// of the innermost failure
+func wrapError(err error, context string) error {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		wrapped := *parseErr
+		wrapped.Message = context + ": " + parseErr.Message
+		return &wrapped
+	}
+	return fmt.Errorf("%s: %w", context, err)
+}
+
+// ParserOptions configures optional parser behavior. The zero value keeps the default grammar.
+type ParserOptions struct {
+	// StrictLogic requires explicit parentheses when AND and OR are mixed at the
+	// same level: "A OR B AND C" is rejected, "A OR (B AND C)" is accepted
+	StrictLogic bool
+
+	// StrictFunctions rejects calls to functions missing from KnownFunctions at parse time.
+	// By default any name parses into a FunctionNode and is resolved during evaluation.
+	StrictFunctions bool
+
+	// KnownFunctions lists function names accepted in strict mode and after
+	// the pipe operator '|>'. nil means the functions registered by NewContext.
+	KnownFunctions []string
+
+	// ComparisonPrecedence sets whether comparisons bind tighter or looser than
+	// AND/OR/NOT. Arithmetic always binds tighter than comparisons.
+	ComparisonPrecedence ComparisonPrecedence
+
+	// FoldNegativeLiterals turns a unary minus applied directly to a number into a
+	// negative LiteralNode: "-5" becomes LiteralNode{-5} while "-A" and "-(5)" stay unary
+	FoldNegativeLiterals bool
+}
+
+// ComparisonPrecedence orders comparisons relative to logical operators
+type ComparisonPrecedence int
+
+const (
+	// ComparisonAboveLogic is the default, as in SQL and C: comparisons bind tighter
+	// than NOT, AND and OR, so "A = 1 OR B = 1" is "(A = 1) OR (B = 1)"
+	ComparisonAboveLogic ComparisonPrecedence = iota
+
+	// ComparisonBelowLogic follows Pascal: NOT, AND and OR bind tighter than
+	// comparisons, so "A = 1 OR B = 1" is "A = (1 OR B) = 1" and conditions
+	// must be parenthesized: "(A = 1) OR (B = 1)"
+	ComparisonBelowLogic
+)
+
 // Parser converts tokens to AST
 type Parser struct {
 	lexer   *Lexer
 	current Token
+	prevEnd int // end of the last consumed token
+	spans   map[ASTNode]Span
+	options ParserOptions
+	stats   ParseStats
+	// pending is a parenthesized operand already parsed by parseIfStatement;
+	// parseFactor returns it instead of reading a new factor
+	pending      ASTNode
+	pendingStart int
+}
+
+// ParseStats reports how much work a parse took, to find pathological formulas in large imports
+type ParseStats struct {
+	// Tokens is the number of tokens read from the lexer, not counting EOF
+	Tokens int
+	// Nodes is the number of AST nodes created
+	Nodes int
 }
 
 func NewParser(input string) *Parser {
+	return NewParserWithOptions(input, ParserOptions{})
+}
+
+// NewParserWithOptions creates a parser with non-default options
+func NewParserWithOptions(input string, options ParserOptions) *Parser {
 	lexer := NewLexer(input)
-	p := &Parser{lexer: lexer}
+	p := &Parser{lexer: lexer, spans: make(map[ASTNode]Span), options: options}
 	p.nextToken() // Initialize current token
 	return p
 }
 
 func (p *Parser) nextToken() {
+	p.prevEnd = p.current.End
 	p.current = p.lexer.NextToken()
+	if p.current.Type != TokenEOF {
+		p.stats.Tokens++
+	}
+}
+
+// track records the source span of a node that started at start and ends at the last consumed token
+func (p *Parser) track(node ASTNode, start int) ASTNode {
+	p.spans[node] = Span{Start: start, End: p.prevEnd}
+	p.stats.Nodes++
+	return node
+}
+
+// Stats returns the work done by this parser so far
+func (p *Parser) Stats() ParseStats {
+	return p.stats
+}
+
+// Span returns the source span of a node produced by this parser
+func (p *Parser) Span(node ASTNode) (Span, bool) {
+	span, ok := p.spans[node]
+	return span, ok
 }
 
 func (p *Parser) Parse() (ASTNode, error) {
-	return p.parseExpression()
+	node, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	// A bar left over after the expression has no opening pair: "|x||"
+	if p.current.Type == TokenBar {
+		return nil, p.errorf("unmatched '|'")
+	}
+	return node, nil
 }
 
-// parseExpression handles the top-level expression
+// parseExpression handles the top-level expression.
+// IF statements are parsed as factors rather than returned as the whole
+// expression, so "IF(c, a, b) + 1" keeps the addition instead of stopping
+// after the IF, and "max(IF(c, a, b) + 1, 0)" parses at all.
 func (p *Parser) parseExpression() (ASTNode, error) {
-	// Check for IF statement at the beginning
-	if p.current.Type == TokenIf {
-		return p.parseIfStatement()
+	start := p.startPos()
+	var node ASTNode
+	var err error
+	if p.options.ComparisonPrecedence == ComparisonBelowLogic {
+		node, err = p.parseComparison()
+	} else {
+		node, err = p.parseLogicalOr()
 	}
-	return p.parseLogicalOr()
+	if err != nil {
+		return nil, err
+	}
+	return p.parsePipe(node, start)
+}
+
+// parsePipe handles the pipe operator, which binds looser than any other:
+// "x |> sqrt |> abs" is desugared into abs(sqrt(x)). Inside |x| bars the pipe
+// is written after a space, "|x |> sqrt|", or parenthesized, "|(x |> sqrt)|",
+// since "|x|>y" compares abs(x) with y.
+// The name after '|>' must be a known function (see KnownFunctions), so a
+// variable in its place is a parse error rather than a failed call later.
+// A pipe stage ends the expression: only another '|>', the end of the input,
+// ')', ',' or a closing bar may follow it, so "x |> sqrt + 1" is an error
+// rather than sqrt(x) with "+ 1" dropped.
+func (p *Parser) parsePipe(node ASTNode, start int) (ASTNode, error) {
+	for p.current.Type == TokenPipe {
+		p.nextToken() // consume '|>'
+
+		if p.current.Type != TokenVariable {
+			return nil, p.errorf("expected function name after '|>', got %s", describeToken(p.current))
+		}
+		name := p.current.Value
+		if !p.isKnownFunction(name) {
+			return nil, p.errorf("%w: '%s' after '|>'", ErrUnknownFunction, name)
+		}
+		p.nextToken() // consume function name
+
+		node = p.track(&FunctionNode{Name: name, Args: []ASTNode{node}}, start)
+
+		switch p.current.Type {
+		case TokenPipe, TokenEOF, TokenParenClose, TokenComma, TokenBar:
+		default:
+			return nil, p.errorf("unexpected %s after '|> %s'", describeToken(p.current), name)
+		}
+	}
+	return node, nil
+}
+
+// startPos returns the position where the operand being parsed starts: the
+// pending parenthesized operand if there is one, otherwise the current token
+func (p *Parser) startPos() int {
+	if p.pending != nil {
+		return p.pendingStart
+	}
+	return p.current.Pos
 }
 
 // parseIfStatement handles ЕСЛИ...ТОГДА...ИНАЧЕ construction
+// as well as the IF(condition, then, else) function form
 func (p *Parser) parseIfStatement() (ASTNode, error) {
 	if p.current.Type != TokenIf {
-		return nil, fmt.Errorf("expected IF/ЕСЛИ")
+		return nil, p.errorf("expected IF/ЕСЛИ")
 	}
+	start := p.current.Pos
+	keyword := p.current.Value
 	p.nextToken() // consume IF/ЕСЛИ
 
+	// "IF (" opens either the argument list of IF(condition, then, else) or a
+	// parenthesized operand at the start of an IF ... THEN condition, as in
+	// "IF (a + b) * 2 > c THEN". The parenthesized expression is parsed once
+	// and the form is decided by the token after it.
+	if p.current.Type == TokenParenOpen {
+		parenStart := p.current.Pos
+		p.nextToken() // consume '('
+		first, err := p.parseExpression()
+		if err != nil {
+			return nil, wrapError(err, "error parsing IF condition")
+		}
+		if p.current.Type == TokenComma {
+			return p.parseIfArguments(start, keyword, first)
+		}
+		if p.current.Type != TokenParenClose {
+			return nil, p.errorf("expected ')' but got %s", describeToken(p.current))
+		}
+		p.nextToken() // consume ')'
+		p.pending, p.pendingStart = first, parenStart
+	}
+
 	// Parse condition
-	condition, err := p.parseLogicalOr()
+	condition, err := p.parseExpression()
 	if err != nil {
-		return nil, fmt.Errorf("error parsing IF condition: %v", err)
+		return nil, wrapError(err, "error parsing IF condition")
 	}
 
+	// Suggestions use the keywords of the language the IF was written in
+	kw := languageKeywords[keywordLanguage(keyword)]
+
 	if p.current.Type != TokenThen {
-		return nil, fmt.Errorf("expected THEN/ТОГДА after IF condition")
+		return nil, p.errorf("expected %s after %s condition, got %s; write %s <condition> %s <value>",
+			kw.Then, keyword, describeToken(p.current), kw.If, kw.Then)
 	}
 	p.nextToken() // consume THEN/ТОГДА
 
 	// Parse then branch
-	thenNode, err := p.parseLogicalOr()
+	thenNode, err := p.parseExpression()
 	if err != nil {
-		return nil, fmt.Errorf("error parsing IF then branch: %v", err)
+		return nil, wrapError(err, "error parsing IF then branch")
+	}
+
+	if startsOperand(p.current) {
+		return nil, p.errorf("expected %s before %s; write %s <condition> %s <value> %s <value>",
+			kw.Else, describeToken(p.current), kw.If, kw.Then, kw.Else)
 	}
 
 	var elseNode ASTNode
 	if p.current.Type == TokenElse {
 		p.nextToken() // consume ELSE/ИНАЧЕ
-		elseNode, err = p.parseLogicalOr()
+		elseNode, err = p.parseExpression()
 		if err != nil {
-			return nil, fmt.Errorf("error parsing IF else branch: %v", err)
+			return nil, wrapError(err, "error parsing IF else branch")
 		}
 	}
 
-	return &ConditionalNode{
+	return p.track(&ConditionalNode{
 		Condition: condition,
 		Then:      thenNode,
 		Else:      elseNode,
-	}, nil
+		Keyword:   keyword,
+	}, start), nil
 }
 
 // parseLogicalOr handles OR/ИЛИ operators
 func (p *Parser) parseLogicalOr() (ASTNode, error) {
-	left, err := p.parseLogicalAnd()
+	start := p.startPos()
+	left, leftHasAnd, err := p.parseLogicalAnd()
 	if err != nil {
 		return nil, err
 	}
 
 	for p.current.Type == TokenOr {
+		orToken := p.current
+		keyword := p.current.Value
 		p.nextToken() // consume OR/ИЛИ
 
-		right, err := p.parseLogicalAnd()
+		right, rightHasAnd, err := p.parseLogicalAnd()
 		if err != nil {
 			return nil, err
 		}
 
-		left = &LogicalNode{
+		if p.options.StrictLogic && (leftHasAnd || rightHasAnd) {
+			return nil, errorAt(orToken, "%w: AND and OR are mixed without parentheses, e.g. write A OR (B AND C)", ErrAmbiguousLogic)
+		}
+
+		left = p.track(&LogicalNode{
 			Operator: "OR",
 			Left:     left,
 			Right:    right,
-		}
+			Keyword:  keyword,
+		}, start)
 	}
 
 	return left, nil
 }
 
-// parseLogicalAnd handles AND/И operators
-func (p *Parser) parseLogicalAnd() (ASTNode, error) {
-	left, err := p.parseComparison()
+// parseLogicalAnd handles AND/И operators and reports whether an unparenthesized AND was consumed
+func (p *Parser) parseLogicalAnd() (ASTNode, bool, error) {
+	start := p.startPos()
+	left, err := p.parseNot()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
+	hasAnd := false
 	for p.current.Type == TokenAnd {
+		keyword := p.current.Value
 		p.nextToken() // consume AND/И
+		hasAnd = true
 
-		right, err := p.parseComparison()
+		right, err := p.parseNot()
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 
-		left = &LogicalNode{
+		left = p.track(&LogicalNode{
 			Operator: "AND",
 			Left:     left,
 			Right:    right,
+			Keyword:  keyword,
+		}, start)
+	}
+
+	return left, hasAnd, nil
+}
+
+// parseNot handles the NOT/НЕ prefix. It binds tighter than AND but looser than
+// comparisons: "NOT a > b AND c" is "(NOT (a > b)) AND c"
+func (p *Parser) parseNot() (ASTNode, error) {
+	if p.current.Type != TokenNot || p.pending != nil {
+		if p.options.ComparisonPrecedence == ComparisonBelowLogic {
+			return p.parseAddSub()
 		}
+		return p.parseComparison()
 	}
 
-	return left, nil
+	start := p.current.Pos
+	keyword := p.current.Value
+	p.nextToken() // consume NOT/НЕ
+
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return p.track(&UnaryNode{Operator: "NOT", Operand: operand, Keyword: keyword}, start), nil
 }
 
 // parseComparison handles comparison operators (>, <, ==, etc.)
 func (p *Parser) parseComparison() (ASTNode, error) {
-	left, err := p.parseAddSub()
+	start := p.startPos()
+	left, err := p.parseComparisonOperand()
 	if err != nil {
 		return nil, err
 	}
 
 	for p.current.Type == TokenOperator && isComparisonOp(p.current.Value) {
 		op := p.current.Value
+		if op == "<>" {
+			op = "!=" // SQL-style inequality is stored in canonical form
+		}
 		p.nextToken()
 
-		right, err := p.parseAddSub()
+		right, err := p.parseComparisonOperand()
 		if err != nil {
 			return nil, err
 		}
 
-		left = &ComparisonNode{
+		left = p.track(&ComparisonNode{
 			Operator: op,
 			Left:     left,
 			Right:    right,
-		}
+		}, start)
 	}
 
 	return left, nil
 }
 
+// parseComparisonOperand parses an operand of a comparison: arithmetic by default,
+// a whole AND/OR expression when comparisons bind looser than logic
+func (p *Parser) parseComparisonOperand() (ASTNode, error) {
+	if p.options.ComparisonPrecedence == ComparisonBelowLogic {
+		return p.parseLogicalOr()
+	}
+	return p.parseAddSub()
+}
+
 // parseAddSub handles + and - operators
 func (p *Parser) parseAddSub() (ASTNode, error) {
+	start := p.startPos()
 	left, err := p.parseMulDiv()
 	if err != nil {
 		return nil, err
@@ -364,78 +870,132 @@ func (p *Parser) parseAddSub() (ASTNode, error) {
 			return nil, err
 		}
 
-		left = &OperationNode{
+		left = p.track(&OperationNode{
 			Operator: op,
 			Left:     left,
 			Right:    right,
-		}
+		}, start)
 	}
 
 	return left, nil
 }
 
-// parseMulDiv handles * and / operators
+// parseMulDiv handles *, /, // (floor division) and % operators
 func (p *Parser) parseMulDiv() (ASTNode, error) {
-	left, err := p.parseFactor()
+	start := p.startPos()
+	left, err := p.parsePower()
 	if err != nil {
 		return nil, err
 	}
 
-	for p.current.Type == TokenOperator && (p.current.Value == "*" || p.current.Value == "/") {
+	for p.current.Type == TokenOperator && (p.current.Value == "*" || p.current.Value == "/" || p.current.Value == "//" || p.current.Value == "%") {
 		op := p.current.Value
 		p.nextToken()
 
-		right, err := p.parseFactor()
+		right, err := p.parsePower()
 		if err != nil {
 			return nil, err
 		}
 
-		left = &OperationNode{
+		left = p.track(&OperationNode{
 			Operator: op,
 			Left:     left,
 			Right:    right,
-		}
+		}, start)
 	}
 
 	return left, nil
 }
 
+// parsePower handles ^ and ** operators. Exponentiation is right-associative,
+// so 2 ^ 3 ^ 2 is 2 ^ (3 ^ 2) = 512. Unary minus binds tighter: -2 ^ 2 is (-2) ^ 2.
+func (p *Parser) parsePower() (ASTNode, error) {
+	start := p.startPos()
+	base, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.current.Type == TokenOperator && (p.current.Value == "^" || p.current.Value == "**") {
+		op := p.current.Value
+		p.nextToken()
+
+		exponent, err := p.parsePower()
+		if err != nil {
+			return nil, err
+		}
+
+		return p.track(&OperationNode{
+			Operator: op,
+			Left:     base,
+			Right:    exponent,
+		}, start), nil
+	}
+
+	return base, nil
+}
+
 // parseFactor handles numbers, variables, functions, unary operators, and parenthesized expressions
 func (p *Parser) parseFactor() (ASTNode, error) {
+	if p.pending != nil {
+		node := p.pending
+		p.pending = nil
+		return node, nil
+	}
+
+	start := p.current.Pos
 	switch p.current.Type {
 	case TokenNumber:
 		value, err := strconv.ParseFloat(p.current.Value, 64)
 		if err != nil {
-			return nil, fmt.Errorf("invalid number: %s", p.current.Value)
+			return nil, p.errorf("invalid number: %s", p.current.Value)
 		}
 		p.nextToken()
-		return &LiteralNode{Value: value}, nil
+		return p.track(&LiteralNode{Value: value}, start), nil
 
 	case TokenVariable:
 		name := p.current.Value
 		p.nextToken()
-		return &VariableNode{Name: name}, nil
+		return p.track(&VariableNode{Name: name}, start), nil
+
+	case TokenTrue, TokenFalse:
+		// Boolean keywords are plain literals: TRUE/ИСТИНА is 1, FALSE/ЛОЖЬ is 0
+		value := 0.0
+		if p.current.Type == TokenTrue {
+			value = 1
+		}
+		p.nextToken()
+		return p.track(&LiteralNode{Value: value}, start), nil
 
 	case TokenFunction:
 		return p.parseFunction()
 
+	case TokenIf:
+		return p.parseIfStatement()
+
 	case TokenOperator:
 		// Handle unary operators (+ and -)
 		if p.current.Value == "+" || p.current.Value == "-" {
 			op := p.current.Value
 			p.nextToken()
+			bareNumber := p.current.Type == TokenNumber
 
 			operand, err := p.parseFactor()
 			if err != nil {
 				return nil, err
 			}
 
-			return &UnaryNode{
+			if literal, ok := operand.(*LiteralNode); ok && bareNumber && op == "-" && p.options.FoldNegativeLiterals {
+				delete(p.spans, literal)
+				return p.track(&LiteralNode{Value: -literal.Value}, start), nil
+			}
+
+			return p.track(&UnaryNode{
 				Operator: op,
 				Operand:  operand,
-			}, nil
+			}, start), nil
 		}
-		return nil, fmt.Errorf("unexpected operator: %s", p.current.Value)
+		return nil, p.errorf("unexpected operator %s", describeToken(p.current))
 
 	case TokenParenOpen:
 		p.nextToken() // consume '('
@@ -445,100 +1005,432 @@ func (p *Parser) parseFactor() (ASTNode, error) {
 		}
 
 		if p.current.Type != TokenParenClose {
-			return nil, fmt.Errorf("expected ')' but got %s", p.current.Value)
+			return nil, p.errorf("expected ')' but got %s", describeToken(p.current))
 		}
 		p.nextToken() // consume ')'
 		return node, nil
 
+	case TokenBar:
+		// |x| is absolute value. The lexer tells opening bars from closing ones,
+		// so ||x| - |y|| nests correctly and "|x|>1" does not lex as a pipe
+		p.nextToken() // consume opening '|'
+		operand, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.current.Type != TokenBar {
+			return nil, p.errorf("expected closing '|' but got %s", describeToken(p.current))
+		}
+		p.nextToken() // consume closing '|'
+		return p.track(&UnaryNode{
+			Operator: "abs",
+			Operand:  operand,
+		}, start), nil
+
+	case TokenIllegal:
+		if isMalformedNumber(p.current) {
+			return nil, p.errorf("malformed number %s", describeToken(p.current))
+		}
+		return nil, p.errorf("invalid token %s", describeToken(p.current))
+
 	default:
-		return nil, fmt.Errorf("unexpected token: %s", p.current.Value)
+		return nil, p.errorf("unexpected token %s", describeToken(p.current))
 	}
 }
 
-// parseFunction handles function calls like IF(condition, then, else)
+// parseFunction handles function calls: IF, IFS and SWITCH are parsed specially,
+// any other name becomes a FunctionNode. Argument counts are not checked here:
+// each function validates its arity when evaluated, so functions registered
+// in the Context after parsing still work.
 func (p *Parser) parseFunction() (ASTNode, error) {
+	name := p.current
 	funcName := p.current.Value
+	start := p.current.Pos
 	p.nextToken() // consume function name
 
 	if p.current.Type != TokenParenOpen {
-		return nil, fmt.Errorf("expected '(' after function name")
+		return nil, p.errorf("expected '(' after function name")
 	}
 	p.nextToken() // consume '('
 
 	// Handle specific functions
 	switch strings.ToUpper(funcName) {
 	case "IF", "ЕСЛИ":
-		return p.parseIfFunction()
-	default:
-		return nil, fmt.Errorf("unknown function: %s", funcName)
+		return p.parseIfFunction(start, funcName)
+	case "IFS":
+		return p.parseIfsFunction(name)
+	case "SWITCH":
+		return p.parseSwitchFunction(name)
+	}
+
+	if p.options.StrictFunctions && !p.isKnownFunction(funcName) {
+		return nil, errorAt(name, "%w: %s", ErrUnknownFunction, funcName)
+	}
+
+	// Other functions are resolved by name at evaluation time
+	args, err := p.parseArguments(funcName)
+	if err != nil {
+		return nil, err
+	}
+	return p.track(&FunctionNode{Name: funcName, Args: args}, start), nil
+}
+
+// isKnownFunction reports whether name is listed in KnownFunctions, or
+// registered in NewContext when KnownFunctions is nil. Case is ignored, as in
+// Context function lookup.
+func (p *Parser) isKnownFunction(name string) bool {
+	if p.options.KnownFunctions == nil {
+		return defaultFunctionNames()[strings.ToLower(name)]
+	}
+	for _, k := range p.options.KnownFunctions {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	defaultFunctions     map[string]bool
+	defaultFunctionsOnce sync.Once
+)
+
+// defaultFunctionNames returns the set of functions registered by NewContext.
+// It is built once and must not be modified.
+func defaultFunctionNames() map[string]bool {
+	defaultFunctionsOnce.Do(func() {
+		defaultFunctions = make(map[string]bool)
+		for name := range NewContext().Functions {
+			defaultFunctions[name] = true
+		}
+	})
+	return defaultFunctions
+}
+
+// isMalformedNumber reports whether an illegal token is a number with more than one dot
+func isMalformedNumber(token Token) bool {
+	return token.Type == TokenIllegal && strings.Count(token.Value, ".") > 1 &&
+		strings.Trim(token.Value, ".0123456789") == ""
+}
+
+// describeToken names a token for error messages
+func describeToken(token Token) string {
+	if token.Type == TokenEOF {
+		return "end of formula"
 	}
+	return fmt.Sprintf("'%s'", token.Value)
+}
+
+// startsOperand reports whether the token can only begin a new operand,
+// so it cannot directly follow a complete expression
+func startsOperand(token Token) bool {
+	switch token.Type {
+	case TokenNumber, TokenVariable, TokenFunction, TokenIf, TokenParenOpen, TokenTrue, TokenFalse:
+		return true
+	}
+	return false
 }
 
 // parseIfFunction handles IF(condition, then, else) function
-func (p *Parser) parseIfFunction() (ASTNode, error) {
+func (p *Parser) parseIfFunction(start int, keyword string) (ASTNode, error) {
 	// Parse condition
-	condition, err := p.parseLogicalOr()
+	condition, err := p.parseExpression()
 	if err != nil {
-		return nil, fmt.Errorf("error parsing IF condition: %v", err)
+		return nil, wrapError(err, "error parsing IF condition")
 	}
+	return p.parseIfArguments(start, keyword, condition)
+}
 
+// parseIfArguments parses the rest of IF(condition, then, else) after the condition
+func (p *Parser) parseIfArguments(start int, keyword string, condition ASTNode) (ASTNode, error) {
 	if p.current.Type != TokenComma {
-		return nil, fmt.Errorf("expected ',' after IF condition")
+		return nil, p.errorf("expected ',' after IF condition")
 	}
 	p.nextToken() // consume ','
 
 	// Parse then branch
-	thenNode, err := p.parseLogicalOr()
+	thenNode, err := p.parseExpression()
 	if err != nil {
-		return nil, fmt.Errorf("error parsing IF then branch: %v", err)
+		return nil, wrapError(err, "error parsing IF then branch")
 	}
 
 	var elseNode ASTNode
 	if p.current.Type == TokenComma {
 		p.nextToken() // consume ','
-		elseNode, err = p.parseLogicalOr()
+		elseNode, err = p.parseExpression()
 		if err != nil {
-			return nil, fmt.Errorf("error parsing IF else branch: %v", err)
+			return nil, wrapError(err, "error parsing IF else branch")
 		}
 	}
 
 	if p.current.Type != TokenParenClose {
-		return nil, fmt.Errorf("expected ')' to close IF function")
+		return nil, p.errorf("expected ')' to close IF function")
 	}
 	p.nextToken() // consume ')'
 
-	return &ConditionalNode{
+	return p.track(&ConditionalNode{
 		Condition: condition,
 		Then:      thenNode,
 		Else:      elseNode,
-	}, nil
+		Keyword:   keyword,
+	}, start), nil
+}
+
+// parseIfsFunction handles IFS(cond1, val1, cond2, val2, ..., default).
+// It desugars to nested conditionals; the trailing default is optional and
+// without it a call where no condition holds evaluates to 0, like an else-less IF.
+func (p *Parser) parseIfsFunction(name Token) (ASTNode, error) {
+	start := name.Pos
+	args, err := p.parseArguments("IFS")
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return nil, errorAt(name, "IFS requires at least one condition/value pair, got %d arguments", len(args))
+	}
+
+	pairs, defaultNode := splitPairs(args)
+	return p.buildConditionalChain(start, pairs, defaultNode), nil
+}
+
+// parseSwitchFunction handles SWITCH(expr, case1, val1, ..., default).
+// It produces a SwitchNode, so expr is evaluated once however many cases follow.
+func (p *Parser) parseSwitchFunction(name Token) (ASTNode, error) {
+	start := name.Pos
+	args, err := p.parseArguments("SWITCH")
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 3 {
+		return nil, errorAt(name, "SWITCH requires an expression and at least one case/value pair, got %d arguments", len(args))
+	}
+
+	pairs, defaultNode := splitPairs(args[1:])
+	node := &SwitchNode{Subject: args[0], Default: defaultNode}
+	for _, pair := range pairs {
+		node.Cases = append(node.Cases, pair[0])
+		node.Values = append(node.Values, pair[1])
+	}
+	return p.track(node, start), nil
+}
+
+// parseArguments parses a comma-separated argument list after the opening '(' up to and including ')'
+func (p *Parser) parseArguments(funcName string) ([]ASTNode, error) {
+	var args []ASTNode
+	if p.current.Type == TokenParenClose {
+		p.nextToken() // consume ')'
+		return args, nil
+	}
+
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, wrapError(err, fmt.Sprintf("error parsing %s argument %d", funcName, len(args)+1))
+		}
+		args = append(args, arg)
+
+		if p.current.Type != TokenComma {
+			break
+		}
+		p.nextToken() // consume ','
+	}
+
+	if p.current.Type != TokenParenClose {
+		return nil, p.errorf("expected ')' to close %s function", funcName)
+	}
+	p.nextToken() // consume ')'
+	return args, nil
+}
+
+// splitPairs splits arguments into (key, value) pairs and an optional trailing default
+func splitPairs(args []ASTNode) ([][2]ASTNode, ASTNode) {
+	var defaultNode ASTNode
+	if len(args)%2 == 1 {
+		defaultNode = args[len(args)-1]
+		args = args[:len(args)-1]
+	}
+
+	pairs := make([][2]ASTNode, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		pairs = append(pairs, [2]ASTNode{args[i], args[i+1]})
+	}
+	return pairs, defaultNode
+}
+
+// buildConditionalChain folds pairs into nested conditionals from the last pair outwards
+func (p *Parser) buildConditionalChain(start int, pairs [][2]ASTNode, defaultNode ASTNode) ASTNode {
+	result := defaultNode
+	for i := len(pairs) - 1; i >= 0; i-- {
+		result = p.track(&ConditionalNode{
+			Condition: pairs[i][0],
+			Then:      pairs[i][1],
+			Else:      result,
+		}, start)
+	}
+	return result
 }
 
 // Helper function to check if operator is a comparison operator
 func isComparisonOp(op string) bool {
 	switch op {
-	case ">", "<", ">=", "<=", "=", "!=":
+	case ">", "<", ">=", "<=", "=", "!=", "<>":
 		return true
 	default:
 		return false
 	}
 }
 
+// grammar is the EBNF of the formula language accepted by Parser. Update it
+// together with the parse functions above.
+const grammar = `(* Formula language accepted by Parser, ISO 14977 EBNF.
+   Keywords and the names IFS and SWITCH are case-insensitive.
+   Whitespace between tokens is ignored. *)
+
+formula        = expression ;
+
+(* The pipe binds loosest: x |> sqrt |> abs is abs(sqrt(x)).
+   The name after |> must be a known function.
+   Inside |...| a pipe is written after a space, |x |> sqrt|,
+   or parenthesized, since |x|>1 is abs(x) > 1. *)
+expression     = logical_or , { "|>" , variable } ;
+
+logical_or     = logical_and , { or , logical_and } ;
+logical_and    = logical_not , { and , logical_not } ;
+logical_not    = { not } , comparison ;
+
+(* With ComparisonPrecedence = ComparisonBelowLogic comparisons bind looser:
+   expression = comparison ; comparison = logical_or , { comparison_op , logical_or } ;
+   logical_not = { not } , arithmetic ; *)
+comparison     = arithmetic , { comparison_op , arithmetic } ;
+comparison_op  = "=" | "!=" | "<>" | ">" | "<" | ">=" | "<=" ;
+
+arithmetic     = term , { ( "+" | "-" ) , term } ;
+term           = power , { ( "*" | "/" | "//" | "%" ) , power } ;
+power          = factor , [ ( "^" | "**" ) , power ] ;   (* right-associative *)
+
+factor         = number
+               | boolean
+               | variable
+               | function_call
+               | conditional
+               | ( "+" | "-" ) , factor
+               | "(" , expression , ")"
+               | "|" , expression , "|" ;                (* absolute value *)
+
+function_call  = name , "(" , [ arguments ] , ")" ;
+arguments      = expression , { "," , expression } ;
+
+conditional    = if , "(" , expression , "," , expression , [ "," , expression ] , ")"
+               | if , expression , then , expression , [ else , expression ]
+               | "IFS" , "(" , expression , "," , expression ,
+                 { "," , expression , "," , expression } , [ "," , expression ] , ")"
+               | "SWITCH" , "(" , expression , "," , expression , "," , expression ,
+                 { "," , expression , "," , expression } , [ "," , expression ] , ")" ;
+
+variable       = name , { "." , letter , { name_char } }
+               | "` + "`" + `" , { any_char - "` + "`" + `" | "` + "``" + `" } , "` + "`" + `" ;
+name           = letter , { name_char } ;
+name_char      = letter | digit | "_" ;
+
+number         = ( digits , [ "." , { digit } ] | "." , digits ) ,
+                 [ ( "e" | "E" ) , [ "+" | "-" ] , digits ] ;
+digits         = digit , { digit } ;
+boolean        = "TRUE" | "FALSE" | "ИСТИНА" | "ЛОЖЬ" ;
+
+if             = "IF" | "ЕСЛИ" ;
+then           = "THEN" | "ТОГДА" ;
+else           = "ELSE" | "ИНАЧЕ" ;
+or             = "OR" | "ИЛИ" ;
+and            = "AND" | "И" ;
+not            = "NOT" | "НЕ" ;
+`
+
+// Grammar returns the EBNF description of the formula language for editor
+// plugins and other tooling. It documents the default options; the comparison
+// precedence alternative is noted in a comment.
+func Grammar() string {
+	return grammar
+}
+
 // SimpleFormulaParser is the main interface for parsing formulas
-type SimpleFormulaParser struct{}
+type SimpleFormulaParser struct {
+	options ParserOptions
+}
 
 func NewSimpleParser() *SimpleFormulaParser {
 	return &SimpleFormulaParser{}
 }
 
+// NewSimpleParserWithOptions creates a formula parser with non-default options
+func NewSimpleParserWithOptions(options ParserOptions) *SimpleFormulaParser {
+	return &SimpleFormulaParser{options: options}
+}
+
 // ParseString parses a formula string into an AST
 func (sfp *SimpleFormulaParser) ParseString(formula string) (ASTNode, error) {
-	// Clean the input
-	formula = strings.TrimSpace(formula)
-	if formula == "" {
-		return nil, fmt.Errorf("empty formula")
+	// Positions are reported relative to the original string, so only check for emptiness here
+	if strings.TrimSpace(formula) == "" {
+		return nil, &ParseError{Message: "empty formula", Token: Token{Type: TokenEOF}}
 	}
 
-	parser := NewParser(formula)
+	parser := NewParserWithOptions(formula, sfp.options)
 	return parser.Parse()
 }
+
+// ParseWithStats parses a formula like ParseString and also reports the number
+// of tokens and nodes the parser processed. Stats are returned even on error.
+func (sfp *SimpleFormulaParser) ParseWithStats(formula string) (ASTNode, ParseStats, error) {
+	if strings.TrimSpace(formula) == "" {
+		return nil, ParseStats{}, &ParseError{Message: "empty formula", Token: Token{Type: TokenEOF}}
+	}
+
+	parser := NewParserWithOptions(formula, sfp.options)
+	node, err := parser.Parse()
+	return node, parser.Stats(), err
+}
+
+// ParseWithStats parses a formula with default options, see
+// (*SimpleFormulaParser).ParseWithStats
+func ParseWithStats(formula string) (ASTNode, ParseStats, error) {
+	return NewSimpleParser().ParseWithStats(formula)
+}
+
+// maxFormulaLine limits the length of a single formula read by ParseReader
+const maxFormulaLine = 1 << 20
+
+// ParseReader parses one formula per line with default options, see
+// (*SimpleFormulaParser).ParseReader
+func ParseReader(r io.Reader) ([]ASTNode, []error) {
+	return NewSimpleParser().ParseReader(r)
+}
+
+// ParseReader reads formulas one per line and parses them as it goes, so the
+// input is never loaded into memory as a whole. Blank lines are skipped. The
+// result holds one node per formula line in input order, nil for lines that
+// failed to parse; errors carry the 1-based line number ("line 3: ...").
+// A read error stops parsing and is returned as the last error.
+func (sfp *SimpleFormulaParser) ParseReader(r io.Reader) ([]ASTNode, []error) {
+	var nodes []ASTNode
+	var errs []error
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFormulaLine)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		node, err := sfp.ParseString(text)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", line, err))
+		}
+		nodes = append(nodes, node)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("reading formulas: %w", err))
+	}
+
+	return nodes, errs
+}
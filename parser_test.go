@@ -0,0 +1,863 @@
+package formula
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// mustParse разбирает формулу или завершает тест
+func mustParse(t testing.TB, formula string) ASTNode {
+	t.Helper()
+	node, err := NewSimpleParser().ParseString(formula)
+	if err != nil {
+		t.Fatalf("parse %q: %v", formula, err)
+	}
+	return node
+}
+
+// evalFormula разбирает и вычисляет формулу с переменными vars
+func evalFormula(t testing.TB, formula string, vars map[string]float64) float64 {
+	t.Helper()
+	ctx := NewContext()
+	ctx.Variables = vars
+	value, err := mustParse(t, formula).Evaluate(ctx)
+	if err != nil {
+		t.Fatalf("evaluate %q: %v", formula, err)
+	}
+	return value
+}
+
+// evalPaths возвращает способы вычисления дерева, которые должны давать
+// одинаковые результаты и ошибки: обход дерева, Compile и байт-код
+func evalPaths(t testing.TB, node ASTNode) map[string]func(*Context) (float64, error) {
+	t.Helper()
+	compiled, err := Compile(node)
+	if err != nil {
+		t.Fatalf("Compile(%s): %v", node, err)
+	}
+	program, err := CompileBytecode(node)
+	if err != nil {
+		t.Fatalf("CompileBytecode(%s): %v", node, err)
+	}
+	return map[string]func(*Context) (float64, error){
+		"Evaluate":        node.Evaluate,
+		"Compile":         compiled,
+		"CompileBytecode": program.Run,
+	}
+}
+
+func TestParseIfForms(t *testing.T) {
+	vars := map[string]float64{"a": 2, "b": 3}
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"IF(a > 1, 10, 20)", 10},
+		{"IF(a > 5, 10, 20)", 20},
+		{"IF(a > 5, 10)", 0},
+		{"IF (a > 1) THEN 10 ELSE 20", 10},
+		{"IF (a + b) * 2 > 9 THEN 1 ELSE 2", 1},
+		{"IF (a) ^ 2 = 4 AND b = 3 THEN 1 ELSE 2", 1},
+		{"IF (a > 1) AND (b > 5) THEN 1 ELSE 2", 2},
+		{"ЕСЛИ (a > 1) ТОГДА 10 ИНАЧЕ 20", 10},
+		{"IF(IF(a > 1, 1, 0), 5, 6) + 1", 6},
+		{"IF (IF (a > 1) THEN 1 ELSE 0) THEN 5 ELSE 6", 5},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, vars); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+}
+
+func TestParseIfSpans(t *testing.T) {
+	formula := "IF (a + b) * 2 > 9 THEN 1"
+	parser := NewParser(formula)
+	node, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	condition := node.(*ConditionalNode).Condition
+	span, ok := parser.Span(condition)
+	if !ok || formula[span.Start:span.End] != "(a + b) * 2 > 9" {
+		t.Errorf("condition span = %+v (%v), want \"(a + b) * 2 > 9\"", span, ok)
+	}
+}
+
+// Каждый токен вложенных IF( должен читаться один раз, иначе время разбора
+// растет экспоненциально с глубиной вложенности
+func TestParseNestedIfLinear(t *testing.T) {
+	for _, open := range []string{"IF(", "IF ("} {
+		formula := "a"
+		for i := 0; i < 30; i++ {
+			if open == "IF(" {
+				formula = open + formula + ", 1, 2)"
+			} else {
+				formula = open + formula + ") THEN 1"
+			}
+		}
+
+		_, stats, err := ParseWithStats(formula)
+		if err != nil {
+			t.Fatalf("parse %q: %v", formula, err)
+		}
+		if want := len(lexAll(formula)); stats.Tokens != want {
+			t.Errorf("%s...: parser read %d tokens, formula has %d", open, stats.Tokens, want)
+		}
+	}
+}
+
+func TestParseIfErrors(t *testing.T) {
+	for _, formula := range []string{"IF (a > 1 THEN 1", "IF () THEN 1", "IF(a > 1, 2"} {
+		if _, err := NewSimpleParser().ParseString(formula); err == nil {
+			t.Errorf("%q: expected error", formula)
+		} else if !strings.Contains(err.Error(), "parse error") {
+			t.Errorf("%q: unexpected error %v", formula, err)
+		}
+	}
+}
+
+func TestParseAbsAndPipe(t *testing.T) {
+	vars := map[string]float64{"x": -4, "y": 3}
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"|x|", 4},
+		{"|x|>1", 1},
+		{"|x|>=4", 1},
+		{"|x| > y", 1},
+		{"||x| - |y||", 1},
+		{"|(x |> abs)|", 4},
+		{"x |> abs |> sqrt", 2},
+		{"|x| |> sqrt", 2},
+		{"y * 3 |> sqrt", 3},
+		{"|x |> abs|", 4},
+		{"|y - 19 |> abs| |> sqrt", 4},
+		{"max(x |> abs, y)", 4},
+		{"|x| > y", 1},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, vars); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+}
+
+// Стадия конвейера завершает выражение: продолжение после нее - ошибка
+// с позицией лишнего токена, а не молча отброшенный хвост
+func TestParsePipeEndsExpression(t *testing.T) {
+	tests := []struct {
+		formula string
+		pos     int
+	}{
+		{"x |> sqrt + 1", 10},
+		{"x |> sqrt * 2 |> abs", 10},
+		{"x |> sqrt 2", 10},
+		{"(x |> sqrt > 1)", 11},
+	}
+	for _, tt := range tests {
+		_, err := NewSimpleParser().ParseString(tt.formula)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%q: error %v, want ParseError", tt.formula, err)
+			continue
+		}
+		if parseErr.Pos != tt.pos {
+			t.Errorf("%q: error at %d (%v), want %d", tt.formula, parseErr.Pos, err, tt.pos)
+		}
+	}
+}
+
+func TestParsePipeRequiresFunction(t *testing.T) {
+	for _, formula := range []string{"x |> a", "x |> 2", "x |>"} {
+		if _, err := NewSimpleParser().ParseString(formula); err == nil {
+			t.Errorf("%q: expected error", formula)
+		}
+	}
+
+	_, err := NewSimpleParser().ParseString("x |> a")
+	if !errors.Is(err, ErrUnknownFunction) {
+		t.Errorf("x |> a: error %v does not wrap ErrUnknownFunction", err)
+	}
+
+	parser := NewSimpleParserWithOptions(ParserOptions{KnownFunctions: []string{"double"}})
+	if _, err := parser.ParseString("x |> double"); err != nil {
+		t.Errorf("x |> double with KnownFunctions: %v", err)
+	}
+}
+
+func TestStrictFunctions(t *testing.T) {
+	strict := NewSimpleParserWithOptions(ParserOptions{StrictFunctions: true})
+	if _, err := strict.ParseString("sqrt(x) + max(1, 2)"); err != nil {
+		t.Errorf("known functions: %v", err)
+	}
+	if _, err := strict.ParseString("SUM(a > 1, b > 2) + Sqrt(x) |> ABS"); err != nil {
+		t.Errorf("known functions in upper case: %v", err)
+	}
+	if _, err := strict.ParseString("sqrtt(x)"); !errors.Is(err, ErrUnknownFunction) {
+		t.Errorf("sqrtt(x): error %v does not wrap ErrUnknownFunction", err)
+	}
+
+	custom := NewSimpleParserWithOptions(ParserOptions{StrictFunctions: true, KnownFunctions: []string{"double"}})
+	if _, err := custom.ParseString("double(x)"); err != nil {
+		t.Errorf("double(x) with KnownFunctions: %v", err)
+	}
+	if _, err := custom.ParseString("sqrt(x)"); !errors.Is(err, ErrUnknownFunction) {
+		t.Errorf("sqrt(x) outside KnownFunctions: error %v", err)
+	}
+}
+
+func BenchmarkParseStrictFunctions(b *testing.B) {
+	parser := NewSimpleParserWithOptions(ParserOptions{StrictFunctions: true})
+	for i := 0; i < b.N; i++ {
+		if _, err := parser.ParseString("sqrt(a) + max(b, c) * abs(d)"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestParseIfs(t *testing.T) {
+	vars := map[string]float64{"a": 3, "b": 1}
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"IFS(a > 5, 1, a > 2, 2)", 2},
+		{"IFS(a > 5, 1, b > 2, 2)", 0},
+		{"IFS(a > 5, 1, b > 2, 2, 7)", 7},
+		{"IFS(a > 2, 1, 7)", 1},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, vars); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+
+	for _, formula := range []string{"IFS(a > 5)", "IFS()"} {
+		if _, err := NewSimpleParser().ParseString(formula); err == nil {
+			t.Errorf("%q: expected error without a condition/value pair", formula)
+		}
+	}
+}
+
+func TestParseSwitch(t *testing.T) {
+	tests := []struct {
+		code float64
+		want float64
+	}{
+		{1, 10}, {2, 20}, {3, 0},
+	}
+	for _, tt := range tests {
+		got := evalFormula(t, "SWITCH(code * 1, 1, 10, 2, 20, 0)", map[string]float64{"code": tt.code})
+		if got != tt.want {
+			t.Errorf("SWITCH with code=%v = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+
+	if _, err := NewSimpleParser().ParseString("SWITCH(code, 1)"); err == nil {
+		t.Error("SWITCH(code, 1): expected error")
+	}
+}
+
+// Выражение SWITCH вычисляется один раз, сколько бы вариантов ни было
+func TestSwitchEvaluatesSubjectOnce(t *testing.T) {
+	node := mustParse(t, "SWITCH(next(), 1, 10, 2, 20, 3, 30, -1)")
+	for name, eval := range evalPaths(t, node) {
+		calls := 0
+		ctx := NewContext()
+		ctx.SetFunction("next", func([]float64) (float64, error) {
+			calls++
+			return float64(calls + 1), nil // 2 при первом вызове, затем 3, 4, ...
+		})
+		if got, err := eval(ctx); err != nil || got != 20 {
+			t.Errorf("%s = %v, %v, want 20", name, got, err)
+		}
+		if calls != 1 {
+			t.Errorf("%s called next() %d times, want 1", name, calls)
+		}
+	}
+}
+
+// С LenientComparisons отсутствующая переменная не совпадает ни с одним
+// вариантом: в выражении она ведет к значению по умолчанию, в варианте -
+// к следующему варианту
+func TestSwitchLenientComparisons(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"SWITCH(missing, 1, 10, 2, 20, 30)", 30},
+		{"SWITCH(missing + 1, 1, 10)", 0},
+		{"SWITCH(code, missing, 10, 2, 20, 30)", 20},
+		{"SWITCH(code, 1, missing, 30)", 30},
+	}
+	for _, tt := range tests {
+		for name, eval := range evalPaths(t, mustParse(t, tt.formula)) {
+			ctx := NewContext()
+			ctx.Variables = map[string]float64{"code": 2}
+			ctx.LenientComparisons = true
+			if got, err := eval(ctx); err != nil || got != tt.want {
+				t.Errorf("%s(%q) = %v, %v, want %v", name, tt.formula, got, err, tt.want)
+			}
+		}
+	}
+}
+
+// SWITCH не должен превращать дерево в граф с общим узлом выражения
+func TestParseSwitchIsTree(t *testing.T) {
+	node := mustParse(t, "SWITCH(code + 1, 1, 10, 2, 20, 3, 30)")
+	seen := make(map[ASTNode]bool)
+	Walk(node, func(n ASTNode) bool {
+		if seen[n] {
+			t.Fatalf("node %v is reachable more than once", n)
+		}
+		seen[n] = true
+		return true
+	})
+	if got := len(CollectVariables(node)); got != 1 {
+		t.Errorf("variables = %d, want 1", got)
+	}
+}
+
+func TestParseNot(t *testing.T) {
+	tests := []struct {
+		formula string
+		vars    map[string]float64
+		want    float64
+	}{
+		{"НЕ (A > B)", map[string]float64{"A": 1, "B": 2}, 1},
+		{"НЕ (A > B)", map[string]float64{"A": 3, "B": 2}, 0},
+		{"NOT(a > b)", map[string]float64{"a": 1, "b": 2}, 1},
+		{"NOT a AND b", map[string]float64{"a": 0, "b": 0}, 0},
+		{"NOT a AND b", map[string]float64{"a": 0, "b": 1}, 1},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, tt.vars); got != tt.want {
+			t.Errorf("%s with %v = %v, want %v", tt.formula, tt.vars, got, tt.want)
+		}
+	}
+
+	node := mustParse(t, "NOT a AND b")
+	if logical, ok := node.(*LogicalNode); !ok || logical.Operator != "AND" {
+		t.Errorf("NOT a AND b parsed as %T, want AND at the top", node)
+	}
+}
+
+func TestParseAbsBars(t *testing.T) {
+	vars := map[string]float64{"A": 1, "B": 4, "x": -3, "y": 5}
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"|A - B|", 3},
+		{"|B - A| * 2", 6},
+		{"||x| - |y||", 2},
+		{"|-|x||", 3},
+		{"|A - |x - B||", 6},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, vars); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+
+	for _, formula := range []string{"|x", "|x||", "||"} {
+		if _, err := NewSimpleParser().ParseString(formula); err == nil {
+			t.Errorf("%q: expected error", formula)
+		}
+	}
+}
+
+// Парсер, как и до поддержки |x|, не проверяет хвост после выражения:
+// "a b" разбирается как a. Лишняя черта - ошибка, так как у нее нет пары.
+func TestParseTrailingTokens(t *testing.T) {
+	for formula, want := range map[string]string{"a b": "a", "|a| b": "|a|", "a + b)": "a + b"} {
+		node, err := NewSimpleParser().ParseString(formula)
+		if err != nil {
+			t.Errorf("%q: %v", formula, err)
+			continue
+		}
+		if got := (formatter{}).format(node); got != want {
+			t.Errorf("%q parsed as %q, want %q", formula, got, want)
+		}
+	}
+	for _, formula := range []string{"|x||", "a + |b||"} {
+		_, err := NewSimpleParser().ParseString(formula)
+		if err == nil || !strings.Contains(err.Error(), "unmatched '|'") {
+			t.Errorf("%q: error %v, want unmatched '|'", formula, err)
+		}
+	}
+}
+
+// IF в начале выражения разбирается как множитель, поэтому следующие за ним
+// операции не теряются - ни в начале формулы, ни в скобках и аргументах
+// функций, где раньше они давали ошибку разбора
+func TestParseLeadingIfKeepsOperations(t *testing.T) {
+	vars := map[string]float64{"a": 2}
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"IF(a > 1, 10, 20) + 1", 11},
+		{"IF(a > 1, 10, 20) * 2 - 1", 19},
+		{"IF(a > 1, 1, 0) AND a > 5", 0},
+		{"max(IF(a > 1, 1, 0) + 1, 0)", 2},
+		{"(IF(a > 1, 1, 0) + 1) * 2", 4},
+		{"ЕСЛИ a > 1 ТОГДА 10 ИНАЧЕ 20", 10},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, vars); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+}
+
+// Имена начинаются с буквы и могут содержать цифры: score2, log10, deg2rad
+func TestParseIdentifiersWithDigits(t *testing.T) {
+	vars := map[string]float64{"score2": 5, "x1": 2}
+	if got := evalFormula(t, "score2 + x1 * deg2rad(0)", vars); got != 5 {
+		t.Errorf("score2 + x1 * deg2rad(0) = %v, want 5", got)
+	}
+	if got := CollectVariables(mustParse(t, "score2 + x1")); !reflect.DeepEqual(got, []string{"score2", "x1"}) {
+		t.Errorf("variables = %v, want [score2 x1]", got)
+	}
+	// Имя не начинается с цифры: в "2x" нет переменной 2x
+	if node, err := NewSimpleParser().ParseString("2x"); err == nil && len(CollectVariables(node)) != 0 {
+		t.Errorf("2x: variables %v, a name cannot start with a digit", CollectVariables(node))
+	}
+}
+
+func TestParseFunctionCalls(t *testing.T) {
+	node := mustParse(t, "max(a, b + 1)")
+	fn, ok := node.(*FunctionNode)
+	if !ok || fn.Name != "max" || len(fn.Args) != 2 {
+		t.Fatalf("max(a, b + 1) parsed as %#v", node)
+	}
+	if _, ok := fn.Args[1].(*OperationNode); !ok {
+		t.Errorf("second argument is %T, want *OperationNode", fn.Args[1])
+	}
+	if got := evalFormula(t, "sqrt(c) + max(a, b)", map[string]float64{"a": 1, "b": 2, "c": 9}); got != 5 {
+		t.Errorf("sqrt(c) + max(a, b) = %v, want 5", got)
+	}
+
+	// Функция, неизвестная парсеру, разбирается и берется из контекста
+	ctx := NewContext()
+	ctx.Functions["double"] = func(args []float64) (float64, error) {
+		return 2 * args[0], nil
+	}
+	if got, err := mustParse(t, "double(4)").Evaluate(ctx); err != nil || got != 8 {
+		t.Errorf("double(4) = %v, %v; want 8", got, err)
+	}
+
+	// Число аргументов проверяется при вычислении, а не при разборе
+	wrongArity := mustParse(t, "sqrt(1, 2)")
+	if _, err := wrongArity.Evaluate(NewContext()); err == nil {
+		t.Error("sqrt(1, 2): expected evaluation error")
+	}
+}
+
+func TestScientificNotation(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"2e3", 2000},
+		{"1.5E-2", 0.015},
+		{"1e6", 1e6},
+		{"1.5e-3 * 1000", 1.5},
+		{"IF 1 > 0 THEN 1e1 ELSE 2", 10},
+		{"IF 1 > 2 THEN 1 ELSE 2e1", 20},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, nil); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+}
+
+func TestQuotedIdentifiers(t *testing.T) {
+	vars := map[string]float64{"total amount": 100, "tax": 20, "a+b": 1, "it`s": 2}
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"`total amount` + tax", 120},
+		{"`a+b` * 10", 10},
+		{"`it``s` + 1", 3},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, vars); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+
+		// String() должен снова заключать такие имена в кавычки
+		printed := mustParse(t, tt.formula).(fmt.Stringer).String()
+		if got := evalFormula(t, printed, vars); got != tt.want {
+			t.Errorf("reparsed %s = %v, want %v", printed, got, tt.want)
+		}
+	}
+
+	if got := mustParse(t, "`total amount` + tax").(*OperationNode).Left.(*VariableNode).String(); got != "`total amount`" {
+		t.Errorf("String() = %s, want `total amount`", got)
+	}
+	if result := NewFormulaValidator().ValidateFormula("`total amount` + tax"); !result.IsValid {
+		t.Errorf("validator rejected a quoted identifier: %v", codes(result))
+	}
+	if _, err := NewSimpleParser().ParseString("`total amount + tax"); err == nil {
+		t.Error("unterminated quoted identifier: expected error")
+	}
+}
+
+func TestNotEqualAlias(t *testing.T) {
+	for _, vars := range []map[string]float64{{"A": 1, "B": 2}, {"A": 3, "B": 3}} {
+		if got, want := evalFormula(t, "A <> B", vars), evalFormula(t, "A != B", vars); got != want {
+			t.Errorf("A <> B = %v, A != B = %v with %v", got, want, vars)
+		}
+	}
+
+	node, ok := mustParse(t, "A <> B").(*ComparisonNode)
+	if !ok || node.Operator != "!=" {
+		t.Errorf("A <> B parsed as %#v, want != comparison", node)
+	}
+	if result := NewFormulaValidator().ValidateFormula("IF(A <> B, 1, 0)"); !result.IsValid {
+		t.Errorf("validator rejected <>: %v", codes(result))
+	}
+}
+
+func TestStrictLogic(t *testing.T) {
+	strict := NewSimpleParserWithOptions(ParserOptions{StrictLogic: true})
+	for _, formula := range []string{"a OR b AND c", "a AND b OR c", "IF(a OR b AND c, 1, 2)"} {
+		if _, err := strict.ParseString(formula); !errors.Is(err, ErrAmbiguousLogic) {
+			t.Errorf("%s: error %v, want ErrAmbiguousLogic", formula, err)
+		}
+		if _, err := NewSimpleParser().ParseString(formula); err != nil {
+			t.Errorf("%s without StrictLogic: %v", formula, err)
+		}
+	}
+	for _, formula := range []string{"a OR (b AND c)", "(a AND b) OR c", "a OR b OR c", "a AND b AND c"} {
+		if _, err := strict.ParseString(formula); err != nil {
+			t.Errorf("%s: %v", formula, err)
+		}
+	}
+
+	v := NewFormulaValidator()
+	v.StrictLogic = true
+	if result := v.ValidateFormula("a OR b AND c"); !hasCode(codes(result), "AMBIGUOUS_LOGIC") {
+		t.Errorf("validator codes = %v, want AMBIGUOUS_LOGIC", codes(result))
+	}
+}
+
+func TestFoldNegativeLiterals(t *testing.T) {
+	parser := NewSimpleParserWithOptions(ParserOptions{FoldNegativeLiterals: true})
+
+	node, err := parser.ParseString("-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if literal, ok := node.(*LiteralNode); !ok || literal.Value != -5 {
+		t.Errorf("-5 = %#v, want LiteralNode{-5}", node)
+	}
+
+	// Минус перед переменной или скобками остается унарным узлом
+	for _, formula := range []string{"-A", "-(5)", "+5"} {
+		node, err := parser.ParseString(formula)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := node.(*UnaryNode); !ok {
+			t.Errorf("%s = %#v, want UnaryNode", formula, node)
+		}
+	}
+
+	node, err = parser.ParseString("2 * -3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op, ok := node.(*OperationNode); !ok || !reflect.DeepEqual(op.Right, &LiteralNode{Value: -3}) {
+		t.Errorf("2 * -3 = %#v, want a negative literal on the right", node)
+	}
+
+	// По умолчанию минус не сворачивается, а значения совпадают в обоих режимах
+	if _, ok := mustParse(t, "-5").(*UnaryNode); !ok {
+		t.Error("-5 without FoldNegativeLiterals should stay unary")
+	}
+	for _, formula := range []string{"-5", "-2^2", "2 - -3", "-2e3 + 1"} {
+		folded, err := parser.ParseString(formula)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := folded.Evaluate(NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := evalFormula(t, formula, nil); got != want {
+			t.Errorf("%s folded = %v, want %v", formula, got, want)
+		}
+	}
+}
+
+func TestParsePower(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"2^10", 1024},
+		{"2 ** 10", 1024},
+		// Степень правоассоциативна: 2 ^ (3 ^ 2)
+		{"2 ^ 3 ^ 2", 512},
+		{"2 ** 3 ** 2", 512},
+		{"(2 ^ 3) ^ 2", 64},
+		// и связывает сильнее умножения
+		{"3 * 2 ^ 2", 12},
+		{"2 ^ 2 * 3", 12},
+		{"2 ^ -1", 0.5},
+		{"a ^ b", 8},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, map[string]float64{"a": 2, "b": 3}); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+
+	// ** - один токен, а не два умножения
+	lexer := NewLexer("2 ** 3")
+	var values []string
+	for token := lexer.NextToken(); token.Type != TokenEOF; token = lexer.NextToken() {
+		values = append(values, token.Value)
+	}
+	if want := []string{"2", "**", "3"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("tokens of 2 ** 3 = %q, want %q", values, want)
+	}
+
+	// Звездочки через пробел - два оператора, а не **
+	_, err := NewSimpleParser().ParseString("a * *b")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Pos != 4 || !strings.Contains(err.Error(), "unexpected operator '*'") {
+		t.Errorf("a * *b: error %v, want unexpected operator '*' at position 4", err)
+	}
+
+	node := mustParse(t, "a ^ b ^ c")
+	if op, ok := node.(*OperationNode); !ok || op.Operator != "^" || reflect.TypeOf(op.Right) != reflect.TypeOf(&OperationNode{}) {
+		t.Errorf("a ^ b ^ c = %#v, want a ^ (b ^ c)", node)
+	}
+}
+
+func TestParseModulo(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"10 % 3", 1},
+		{"a % b", 2},
+		// % имеет тот же приоритет, что * и /, и левоассоциативен
+		{"2 + 10 % 4", 4},
+		{"10 % 4 * 3", 6},
+		{"3 * 10 % 4", 2},
+	}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, map[string]float64{"a": 17, "b": 5}); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+
+	_, err := mustParse(t, "7 % 0").Evaluate(NewContext())
+	if err == nil || err.Error() != "modulo by zero" {
+		t.Errorf("7 %% 0 error = %v, want modulo by zero", err)
+	}
+
+	if result := NewFormulaValidator().ValidateFormula("a % b + 1"); !result.IsValid {
+		t.Errorf("a %% b + 1 is invalid: %v", codes(result))
+	}
+}
+
+// Подсказка в ошибке использует ключевые слова языка, на котором написано условие
+func TestIfErrorSuggestions(t *testing.T) {
+	tests := []struct {
+		formula string
+		pos     int
+		message string
+	}{
+		{"ЕСЛИ a > 1 b", 11, "expected ТОГДА after ЕСЛИ condition, got 'b'; write ЕСЛИ <condition> ТОГДА <value>"},
+		{"ЕСЛИ a > 1", 10, "expected ТОГДА after ЕСЛИ condition, got end of formula; write ЕСЛИ <condition> ТОГДА <value>"},
+		{"IF a > 1 b", 9, "expected THEN after IF condition, got 'b'; write IF <condition> THEN <value>"},
+		{"if a > 1", 8, "expected THEN after if condition, got end of formula; write IF <condition> THEN <value>"},
+		{"ЕСЛИ a ТОГДА 1 b", 15, "expected ИНАЧЕ before 'b'; write ЕСЛИ <condition> ТОГДА <value> ИНАЧЕ <value>"},
+		{"IF a THEN 1 b", 12, "expected ELSE before 'b'; write IF <condition> THEN <value> ELSE <value>"},
+	}
+	for _, tt := range tests {
+		_, err := NewSimpleParser().ParseString(tt.formula)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%s: error %v, want ParseError", tt.formula, err)
+			continue
+		}
+		if parseErr.Pos != tt.pos || parseErr.Message != tt.message {
+			t.Errorf("%s: error at %d %q, want at %d %q", tt.formula, parseErr.Pos, parseErr.Message, tt.pos, tt.message)
+		}
+	}
+}
+
+func TestComparisonPrecedence(t *testing.T) {
+	tests := []struct {
+		formula string
+		above   string // ComparisonAboveLogic, по умолчанию
+		below   string // ComparisonBelowLogic
+	}{
+		// Арифметика связывает сильнее сравнений при любой настройке
+		{"A + 1 > B", "A + 1 > B", "A + 1 > B"},
+		{"A * 2 + 1 >= B - 3", "A * 2 + 1 >= B - 3", "A * 2 + 1 >= B - 3"},
+		// Настройка меняет только порядок сравнений и логических операций
+		{"A = 1 OR B = 1", "A = 1 OR B = 1", "A = (1 OR B) = 1"},
+		{"A > 1 AND B", "A > 1 AND B", "A > (1 AND B)"},
+		{"NOT A = 1", "NOT A = 1", "(NOT A) = 1"},
+		{"(A = 1) OR (B = 1)", "A = 1 OR B = 1", "A = 1 OR B = 1"},
+	}
+	above := NewSimpleParserWithOptions(ParserOptions{ComparisonPrecedence: ComparisonAboveLogic})
+	below := NewSimpleParserWithOptions(ParserOptions{ComparisonPrecedence: ComparisonBelowLogic})
+	for _, tt := range tests {
+		for _, c := range []struct {
+			parser *SimpleFormulaParser
+			want   string
+		}{{above, tt.above}, {below, tt.below}} {
+			node, err := c.parser.ParseString(tt.formula)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.formula, err)
+			}
+			if got := fmt.Sprint(node); got != c.want {
+				t.Errorf("%s parsed as %s, want %s", tt.formula, got, c.want)
+			}
+		}
+
+		// Настройка по умолчанию совпадает с ComparisonAboveLogic
+		if got := fmt.Sprint(mustParse(t, tt.formula)); got != tt.above {
+			t.Errorf("%s parsed by default as %s, want %s", tt.formula, got, tt.above)
+		}
+	}
+
+	node, err := below.ParseString("A + 1 > B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comparison, ok := node.(*ComparisonNode); !ok || comparison.Left.(*OperationNode).Operator != "+" {
+		t.Errorf("A + 1 > B = %#v, want (A + 1) > B", node)
+	}
+}
+
+// errReader возвращает данные, а затем ошибку чтения
+type errReader struct {
+	data string
+	err  error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestParseReader(t *testing.T) {
+	input := "a + b\n\nIF(a > 1, 2, 3)\r\na + * b\n   \nmax(a, b)"
+	nodes, errs := ParseReader(strings.NewReader(input))
+
+	if len(nodes) != 4 {
+		t.Fatalf("ParseReader returned %d nodes, want 4", len(nodes))
+	}
+	for i, want := range []string{"a + b", "IF a > 1 THEN 2 ELSE 3", "", "max(a, b)"} {
+		if want == "" {
+			if nodes[i] != nil {
+				t.Errorf("node %d = %s, want nil for the bad line", i, nodes[i])
+			}
+			continue
+		}
+		if got := fmt.Sprint(nodes[i]); got != want {
+			t.Errorf("node %d = %s, want %s", i, got, want)
+		}
+	}
+
+	// Номер строки считается с учетом пустых строк
+	var parseErr *ParseError
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "line 4: ") || !errors.As(errs[0], &parseErr) {
+		t.Errorf("errors = %v, want one ParseError on line 4", errs)
+	}
+
+	// Ошибка чтения завершает разбор и возвращается последней
+	readErr := errors.New("disk failure")
+	nodes, errs = ParseReader(&errReader{data: "a + 1\nb * 2\n", err: readErr})
+	if len(nodes) != 2 || len(errs) != 1 || !errors.Is(errs[0], readErr) {
+		t.Errorf("ParseReader with a read error = %v, %v", nodes, errs)
+	}
+}
+
+// TRUE/ИСТИНА - литерал 1, FALSE/ЛОЖЬ - литерал 0 в любом регистре
+func TestParseBooleanLiterals(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"TRUE AND FALSE", 0},
+		{"TRUE OR FALSE", 1},
+		{"NOT FALSE", 1},
+		{"true + true", 2},
+		{"ИСТИНА И ЛОЖЬ", 0},
+		{"ИСТИНА ИЛИ ЛОЖЬ", 1},
+		{"НЕ ЛОЖЬ", 1},
+		{"ЕСЛИ ИСТИНА ТОГДА 5 ИНАЧЕ 7", 5},
+		{"IF(x > 1 AND TRUE, 3, 4)", 3},
+	}
+	vars := map[string]float64{"x": 2}
+	v := NewFormulaValidator()
+	v.KnownVariables = map[string]bool{"x": true}
+	for _, tt := range tests {
+		if got := evalFormula(t, tt.formula, vars); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+		// Ключевые слова не считаются неизвестными переменными
+		if result := v.ValidateFormula(tt.formula); !result.IsValid {
+			t.Errorf("%q: validator errors %v", tt.formula, result.Errors)
+		}
+	}
+}
+
+func TestGrammar(t *testing.T) {
+	g := Grammar()
+	if strings.TrimSpace(g) == "" {
+		t.Fatal("Grammar() is empty")
+	}
+
+	// Убираем комментарии и строки в кавычках, остаются имена правил
+	text := regexp.MustCompile(`(?s)\(\*.*?\*\)`).ReplaceAllString(g, " ")
+	text = regexp.MustCompile("\"[^\"]*\"|`[^`]*`").ReplaceAllString(text, " ")
+
+	defined := make(map[string]bool)
+	for _, m := range regexp.MustCompile(`(?m)^([a-z_]+)\s*=`).FindAllStringSubmatch(text, -1) {
+		if defined[m[1]] {
+			t.Errorf("production %q defined twice", m[1])
+		}
+		defined[m[1]] = true
+	}
+	for _, name := range []string{
+		"formula", "expression", "logical_or", "logical_and", "logical_not", "comparison",
+		"arithmetic", "term", "power", "factor", "function_call", "conditional",
+		"variable", "number", "boolean",
+	} {
+		if !defined[name] {
+			t.Errorf("Grammar() has no production %q", name)
+		}
+	}
+
+	// letter, digit и any_char - классы символов, не описанные в грамматике
+	terminals := map[string]bool{"letter": true, "digit": true, "any_char": true}
+	for _, name := range regexp.MustCompile(`[a-z_]+`).FindAllString(text, -1) {
+		if !defined[name] && !terminals[name] {
+			t.Errorf("Grammar() references undefined production %q", name)
+		}
+	}
+}
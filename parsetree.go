@@ -0,0 +1,91 @@
+package formula
+
+import "encoding/json"
+
+// parseTreeNode is the tooling representation of a parsed node with its source span.
+// Unlike the evaluation JSON format it is output-only and carries positions.
+type parseTreeNode struct {
+	Type      NodeType         `json:"type"`
+	Operator  string           `json:"operator,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Value     *float64         `json:"value,omitempty"`
+	Start     int              `json:"start"`
+	End       int              `json:"end"`
+	Left      *parseTreeNode   `json:"left,omitempty"`
+	Right     *parseTreeNode   `json:"right,omitempty"`
+	Operand   *parseTreeNode   `json:"operand,omitempty"`
+	Condition *parseTreeNode   `json:"condition,omitempty"`
+	Then      *parseTreeNode   `json:"then,omitempty"`
+	Else      *parseTreeNode   `json:"else,omitempty"`
+	Args      []*parseTreeNode `json:"args,omitempty"`
+	Subject   *parseTreeNode   `json:"subject,omitempty"`
+	Cases     []*parseTreeNode `json:"cases,omitempty"`
+	Values    []*parseTreeNode `json:"values,omitempty"`
+	Default   *parseTreeNode   `json:"default,omitempty"`
+}
+
+// ParseTreeJSON parses a formula and returns its parse tree as JSON, where every
+// node carries its type, operator/name/value and the [start, end) rune offsets
+// of the source text it was parsed from. Intended for editor integrations.
+func ParseTreeJSON(formula string) ([]byte, error) {
+	parser := NewParser(formula)
+	root, err := parser.Parse()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(buildParseTree(parser, root))
+}
+
+func buildParseTree(parser *Parser, node ASTNode) *parseTreeNode {
+	if node == nil {
+		return nil
+	}
+
+	span, _ := parser.Span(node)
+	tree := &parseTreeNode{
+		Type:  node.GetType(),
+		Start: span.Start,
+		End:   span.End,
+	}
+
+	switch n := node.(type) {
+	case *LiteralNode:
+		value := n.Value
+		tree.Value = &value
+	case *VariableNode:
+		tree.Name = n.Name
+	case *OperationNode:
+		tree.Operator = n.Operator
+		tree.Left = buildParseTree(parser, n.Left)
+		tree.Right = buildParseTree(parser, n.Right)
+	case *ComparisonNode:
+		tree.Operator = n.Operator
+		tree.Left = buildParseTree(parser, n.Left)
+		tree.Right = buildParseTree(parser, n.Right)
+	case *LogicalNode:
+		tree.Operator = n.Operator
+		tree.Left = buildParseTree(parser, n.Left)
+		tree.Right = buildParseTree(parser, n.Right)
+	case *ConditionalNode:
+		tree.Condition = buildParseTree(parser, n.Condition)
+		tree.Then = buildParseTree(parser, n.Then)
+		tree.Else = buildParseTree(parser, n.Else)
+	case *SwitchNode:
+		tree.Subject = buildParseTree(parser, n.Subject)
+		for i := range n.Cases {
+			tree.Cases = append(tree.Cases, buildParseTree(parser, n.Cases[i]))
+			tree.Values = append(tree.Values, buildParseTree(parser, n.Values[i]))
+		}
+		tree.Default = buildParseTree(parser, n.Default)
+	case *UnaryNode:
+		tree.Operator = n.Operator
+		tree.Operand = buildParseTree(parser, n.Operand)
+	case *FunctionNode:
+		tree.Name = n.Name
+		for _, arg := range n.Args {
+			tree.Args = append(tree.Args, buildParseTree(parser, arg))
+		}
+	}
+
+	return tree
+}
@@ -0,0 +1,63 @@
+package formula
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseTreeJSONSpans(t *testing.T) {
+	formula := "a + max(b, 2)"
+	data, err := ParseTreeJSON(formula)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var root parseTreeNode
+	if err := json.Unmarshal(data, &root); err != nil {
+		t.Fatal(err)
+	}
+
+	text := func(n *parseTreeNode) string {
+		return string([]rune(formula)[n.Start:n.End])
+	}
+	call := root.Right
+	tests := []struct {
+		node *parseTreeNode
+		want string
+	}{
+		{&root, "a + max(b, 2)"},
+		{root.Left, "a"},
+		{call, "max(b, 2)"},
+		{call.Args[0], "b"},
+		{call.Args[1], "2"},
+	}
+	for _, tt := range tests {
+		if got := text(tt.node); got != tt.want {
+			t.Errorf("span [%d, %d) = %q, want %q", tt.node.Start, tt.node.End, got, tt.want)
+		}
+	}
+	if root.Operator != "+" || root.Left.Name != "a" || call.Name != "max" || *call.Args[1].Value != 2 {
+		t.Errorf("unexpected tree %s", data)
+	}
+
+	if _, err := ParseTreeJSON("a +"); err == nil {
+		t.Error("a +: expected error")
+	}
+}
+
+// Смещения считаются в рунах, а не в байтах
+func TestParseTreeJSONRuneOffsets(t *testing.T) {
+	formula := "ЕСЛИ (цена > 1) ТОГДА 2"
+	data, err := ParseTreeJSON(formula)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root parseTreeNode
+	if err := json.Unmarshal(data, &root); err != nil {
+		t.Fatal(err)
+	}
+	condition := root.Condition
+	if got := string([]rune(formula)[condition.Start:condition.End]); got != "цена > 1" {
+		t.Errorf("condition span [%d, %d) = %q, want \"цена > 1\"", condition.Start, condition.End, got)
+	}
+}
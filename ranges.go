@@ -0,0 +1,152 @@
+package formula
+
+import (
+	"math"
+	"sync"
+)
+
+// Range - наблюдаемый диапазон значений: наименьшее и наибольшее значение
+// и число наблюдений. NaN учитывается в Count, но не в Min и Max.
+type Range struct {
+	Min, Max float64
+	Count    int
+}
+
+func (r *Range) observe(value float64) {
+	if r.Count == 0 {
+		r.Min, r.Max = math.Inf(1), math.Inf(-1)
+	}
+	r.Count++
+	if !math.IsNaN(value) {
+		r.Min = math.Min(r.Min, value)
+		r.Max = math.Max(r.Max, value)
+	}
+}
+
+// RangeCollector накапливает диапазоны значений, которые принимали
+// переменные и промежуточные результаты (поддеревья) формулы в серии
+// вычислений. Подключается через поле Context.Ranges и заполняется
+// функцией Evaluate. Полученные границы переменных можно передать
+// в DeadBranches через Bounds. Коллектор безопасен для использования
+// из нескольких горутин.
+type RangeCollector struct {
+	mu        sync.Mutex
+	variables map[string]*Range
+	nodes     map[ASTNode]*Range
+	observed  map[ASTNode]ASTNode // корень формулы -> дерево с наблюдающими узлами
+}
+
+// NewRangeCollector создает пустой коллектор
+func NewRangeCollector() *RangeCollector {
+	return &RangeCollector{
+		variables: make(map[string]*Range),
+		nodes:     make(map[ASTNode]*Range),
+		observed:  make(map[ASTNode]ASTNode),
+	}
+}
+
+// Variable возвращает диапазон значений переменной
+func (c *RangeCollector) Variable(name string) (Range, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.variables[name]
+	if !ok {
+		return Range{}, false
+	}
+	return *r, true
+}
+
+// Node возвращает диапазон значений, которые давал узел формулы
+func (c *RangeCollector) Node(node ASTNode) (Range, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.nodes[node]
+	if !ok {
+		return Range{}, false
+	}
+	return *r, true
+}
+
+// Bounds возвращает наблюдаемые границы переменных в формате DeadBranches.
+// Переменные, принимавшие только NaN, не включаются.
+func (c *RangeCollector) Bounds() map[string][2]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bounds := make(map[string][2]float64, len(c.variables))
+	for name, r := range c.variables {
+		if r.Min <= r.Max {
+			bounds[name] = [2]float64{r.Min, r.Max}
+		}
+	}
+	return bounds
+}
+
+// evaluate вычисляет формулу, записывая значения переменных и поддеревьев
+func (c *RangeCollector) evaluate(node ASTNode, ctx *Context) (float64, error) {
+	c.mu.Lock()
+	observed, ok := c.observed[node]
+	if !ok {
+		observed = c.observe(node)
+		c.observed[node] = observed
+	}
+	c.mu.Unlock()
+
+	// Глубина проверяется по исходному дереву: под observedNode ее не видно
+	ctx, err := ctx.enter(node)
+	if err != nil {
+		return 0, err
+	}
+	return observed.Evaluate(ctx)
+}
+
+// observe оборачивает узел и всех его потомков, кроме литералов, в наблюдающие узлы
+func (c *RangeCollector) observe(node ASTNode) ASTNode {
+	if _, ok := node.(*LiteralNode); ok {
+		return node
+	}
+	return &observedNode{
+		collector: c,
+		original:  node,
+		node:      mapChildren(node, c.observe),
+	}
+}
+
+func (c *RangeCollector) record(original ASTNode, value float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.nodes[original]
+	if !ok {
+		r = &Range{}
+		c.nodes[original] = r
+	}
+	r.observe(value)
+
+	if v, ok := original.(*VariableNode); ok {
+		r, ok := c.variables[v.Name]
+		if !ok {
+			r = &Range{}
+			c.variables[v.Name] = r
+		}
+		r.observe(value)
+	}
+}
+
+// observedNode вычисляет узел и передает результат коллектору
+type observedNode struct {
+	collector *RangeCollector
+	original  ASTNode
+	node      ASTNode
+}
+
+func (n *observedNode) Evaluate(ctx *Context) (float64, error) {
+	value, err := n.node.Evaluate(ctx)
+	if err == nil {
+		n.collector.record(n.original, value)
+	}
+	return value, err
+}
+
+func (n *observedNode) GetType() NodeType {
+	return n.original.GetType()
+}
@@ -0,0 +1,110 @@
+package formula
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestRangeCollector(t *testing.T) {
+	node := mustParse(t, "IF(price > 100, price * qty, 0) + qty")
+	collector := NewRangeCollector()
+
+	for _, vars := range []map[string]float64{
+		{"price": 50, "qty": 2},
+		{"price": 150, "qty": 1},
+		{"price": 120, "qty": 4},
+	} {
+		ctx := NewContext()
+		ctx.Variables = vars
+		ctx.Ranges = collector
+		if _, err := Evaluate(node, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	variables := map[string]Range{
+		"price": {Min: 50, Max: 150, Count: 5},
+		"qty":   {Min: 1, Max: 4, Count: 5},
+	}
+	for name, want := range variables {
+		if got, ok := collector.Variable(name); !ok || got != want {
+			t.Errorf("Variable(%s) = %+v, %v, want %+v", name, got, ok, want)
+		}
+	}
+	if _, ok := collector.Variable("missing"); ok {
+		t.Error("Variable(missing) reported as observed")
+	}
+
+	// Диапазоны поддеревьев: price * qty вычисляется только при price > 100
+	sum := node.(*OperationNode)
+	conditional := sum.Left.(*ConditionalNode)
+	nodes := []struct {
+		node ASTNode
+		want Range
+	}{
+		{sum, Range{Min: 2, Max: 484, Count: 3}},
+		{conditional, Range{Min: 0, Max: 480, Count: 3}},
+		{conditional.Then, Range{Min: 150, Max: 480, Count: 2}},
+		{conditional.Condition, Range{Min: 0, Max: 1, Count: 3}},
+	}
+	for _, tt := range nodes {
+		if got, ok := collector.Node(tt.node); !ok || got != tt.want {
+			t.Errorf("Node(%s) = %+v, %v, want %+v", tt.node, got, ok, tt.want)
+		}
+	}
+
+	want := map[string][2]float64{"price": {50, 150}, "qty": {1, 4}}
+	if got := collector.Bounds(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Bounds = %v, want %v", got, want)
+	}
+}
+
+// NaN учитывается в Count, но не в границах
+func TestRangeCollectorNaN(t *testing.T) {
+	collector := NewRangeCollector()
+	node := mustParse(t, "x")
+	for _, x := range []float64{math.NaN(), 3, math.NaN()} {
+		ctx := NewContext()
+		ctx.Variables = map[string]float64{"x": x}
+		ctx.Ranges = collector
+		if _, err := Evaluate(node, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := collector.Variable("x"); got != (Range{Min: 3, Max: 3, Count: 3}) {
+		t.Errorf("Variable(x) = %+v, want {Min:3 Max:3 Count:3}", got)
+	}
+
+	only := NewRangeCollector()
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"x": math.NaN()}
+	ctx.Ranges = only
+	if _, err := Evaluate(node, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := only.Bounds(); len(got) != 0 {
+		t.Errorf("Bounds with only NaN observed = %v, want empty", got)
+	}
+}
+
+// MaxDepth проверяется по исходному дереву и при сборе диапазонов
+func TestRangeCollectorMaxDepth(t *testing.T) {
+	node := mustParse(t, "((((a + 1) + 1) + 1) + 1) + 1")
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"a": 1}
+	ctx.Ranges = NewRangeCollector()
+	ctx.MaxDepth = 3
+	if _, err := Evaluate(node, ctx); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("MaxDepth 3: error %v, want ErrMaxDepth", err)
+	}
+	if _, ok := ctx.Ranges.Variable("a"); ok {
+		t.Error("variable observed in a rejected evaluation")
+	}
+
+	ctx.MaxDepth = treeDepth(node)
+	if got, err := Evaluate(node, ctx); err != nil || got != 6 {
+		t.Errorf("MaxDepth %d = %v, %v; want 6", ctx.MaxDepth, got, err)
+	}
+}
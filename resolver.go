@@ -0,0 +1,52 @@
+package formula
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JSONResolver разрешает составные имена переменных вида "user.profile.age"
+// по вложенному JSON-документу, полученному через json.Unmarshal в
+// map[string]interface{}. Числа возвращаются как есть, true и false - как 1 и 0;
+// строки, массивы, объекты и null считаются ошибкой.
+type JSONResolver struct {
+	Document map[string]interface{}
+}
+
+// NewJSONContext создает контекст со стандартными функциями, переменные
+// которого берутся из JSON-документа
+func NewJSONContext(document map[string]interface{}) *Context {
+	ctx := NewContext()
+	ctx.Resolver = &JSONResolver{Document: document}
+	return ctx
+}
+
+// Resolve возвращает числовое значение по пути name
+func (r *JSONResolver) Resolve(name string) (float64, error) {
+	var current interface{} = r.Document
+	for _, key := range strings.Split(name, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return 0, fmt.Errorf("path '%s': '%s' is not an object %w", name, key, ErrNotFound)
+		}
+		current, ok = object[key]
+		if !ok {
+			return 0, fmt.Errorf("path '%s': key '%s' %w", name, key, ErrNotFound)
+		}
+	}
+
+	switch value := current.(type) {
+	case float64:
+		return value, nil
+	case json.Number:
+		return value.Float64()
+	case bool:
+		if value {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("value at path '%s' is not numeric: %T", name, current)
+	}
+}
@@ -0,0 +1,67 @@
+package formula
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const userDocument = `{"user": {"age": 34, "active": true, "name": "Ann", "profile": {"score": 7.5}, "tags": [1, 2]}}`
+
+func TestJSONResolver(t *testing.T) {
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(userDocument), &document); err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewJSONContext(document)
+
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"user.age", 34},
+		{"user.age + user.profile.score * 2", 49},
+		{"IF(user.active AND user.age >= 18, 1, 0)", 1},
+	}
+	for _, tt := range tests {
+		got, err := mustParse(t, tt.formula).Evaluate(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.formula, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+
+	// Нечисловые значения - ошибка, а не отсутствующая переменная
+	for _, name := range []string{"user.name", "user.tags", "user.profile"} {
+		_, err := mustParse(t, name).Evaluate(ctx)
+		if err == nil || !strings.Contains(err.Error(), "is not numeric") {
+			t.Errorf("%s: error %v, want a non-numeric value error", name, err)
+		}
+	}
+
+	// Отсутствующий путь ведет себя как отсутствующая переменная
+	for _, name := range []string{"user.height", "user.age.years", "account.id"} {
+		if _, err := (&JSONResolver{Document: document}).Resolve(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Resolve(%s) error = %v, want ErrNotFound", name, err)
+		}
+		if missing := CheckVariables(mustParse(t, name), ctx); len(missing) != 1 {
+			t.Errorf("CheckVariables(%s) = %v, want the path reported missing", name, missing)
+		}
+	}
+}
+
+func TestJSONResolverNumbers(t *testing.T) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(userDocument)))
+	decoder.UseNumber()
+	var document map[string]interface{}
+	if err := decoder.Decode(&document); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := mustParse(t, "user.profile.score").Evaluate(NewJSONContext(document)); err != nil || got != 7.5 {
+		t.Errorf("json.Number leaf = %v, %v, want 7.5", got, err)
+	}
+}
@@ -0,0 +1,69 @@
+package formula
+
+import "fmt"
+
+// ToRPN преобразует дерево в обратную польскую (постфиксную) запись:
+// сначала операнды, затем оператор. "A + B * C" дает A B C * +.
+// Токены операторов и функций содержат Arity - число операндов на стеке:
+// унарный минус - TokenOperator "-" с Arity 1, бинарный - с Arity 2,
+// вызов функции - TokenFunction с числом аргументов, модуль |x| - функция abs,
+// условие - TokenIf с Arity 2 или 3 (условие, THEN и ELSE, если она есть),
+// SWITCH - TokenFunction "SWITCH" с числом всех его операндов.
+// Позиции токенов не заполняются.
+func ToRPN(node ASTNode) ([]Token, error) {
+	var tokens []Token
+	if err := appendRPN(node, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func appendRPN(node ASTNode, tokens *[]Token) error {
+	for _, child := range children(node) {
+		if err := appendRPN(child, tokens); err != nil {
+			return err
+		}
+	}
+
+	var token Token
+	switch n := node.(type) {
+	case *LiteralNode:
+		token = Token{Type: TokenNumber, Value: formatNumber(n.Value)}
+	case *VariableNode:
+		token = Token{Type: TokenVariable, Value: n.Name}
+	case *OperationNode:
+		token = Token{Type: TokenOperator, Value: n.Operator, Arity: 2}
+	case *ComparisonNode:
+		token = Token{Type: TokenOperator, Value: n.Operator, Arity: 2}
+	case *LogicalNode:
+		tokenType := TokenOr
+		if n.Operator == "AND" {
+			tokenType = TokenAnd
+		}
+		token = Token{Type: tokenType, Value: n.Operator, Arity: 2}
+	case *UnaryNode:
+		switch n.Operator {
+		case "abs":
+			token = Token{Type: TokenFunction, Value: "abs", Arity: 1}
+		case "NOT":
+			token = Token{Type: TokenNot, Value: "NOT", Arity: 1}
+		default:
+			token = Token{Type: TokenOperator, Value: n.Operator, Arity: 1}
+		}
+	case *ConditionalNode:
+		arity := 2
+		if n.Else != nil {
+			arity = 3
+		}
+		token = Token{Type: TokenIf, Value: "IF", Arity: arity}
+	case *SwitchNode:
+		token = Token{Type: TokenFunction, Value: "SWITCH", Arity: len(children(n))}
+	case *FunctionNode:
+		token = Token{Type: TokenFunction, Value: n.Name, Arity: len(n.Args)}
+	default:
+		return fmt.Errorf("unsupported node type: %T", node)
+	}
+
+	*tokens = append(*tokens, token)
+	return nil
+}
@@ -0,0 +1,57 @@
+package formula
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// rpnString записывает токены через пробел, добавляя арность операторов
+// с одним операндом, функций и условий: "-/1", "max/2", "IF/3"
+func rpnString(tokens []Token) string {
+	parts := make([]string, len(tokens))
+	for i, token := range tokens {
+		parts[i] = token.Value
+		if token.Arity != 0 && (token.Arity != 2 || token.Type == TokenFunction || token.Type == TokenIf) {
+			parts[i] = fmt.Sprintf("%s/%d", token.Value, token.Arity)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestToRPN(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    string
+	}{
+		{"A + B * C", "A B C * +"},
+		{"(A + B) * C", "A B + C *"},
+		{"A - B - C", "A B - C -"},
+		{"-A + 2.5", "A -/1 2.5 +"},
+		{"max(A, B + 1, 3)", "A B 1 + 3 max/3"},
+		{"|A - B|", "A B - abs/1"},
+		{"A > 1 AND NOT B", "A 1 > B NOT/1 AND"},
+		{"IF(A > 1, B, C)", "A 1 > B C IF/3"},
+		{"IF(A > 1, B)", "A 1 > B IF/2"},
+	}
+	for _, tt := range tests {
+		tokens, err := ToRPN(mustParse(t, tt.formula))
+		if err != nil {
+			t.Fatalf("ToRPN(%q): %v", tt.formula, err)
+		}
+		if got := rpnString(tokens); got != tt.want {
+			t.Errorf("ToRPN(%q) = %s, want %s", tt.formula, got, tt.want)
+		}
+	}
+
+	tokens, err := ToRPN(mustParse(t, "A + B * C"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []TokenType{TokenVariable, TokenVariable, TokenVariable, TokenOperator, TokenOperator}
+	for i, token := range tokens {
+		if token.Type != types[i] {
+			t.Errorf("token %d %q has type %v, want %v", i, token.Value, token.Type, types[i])
+		}
+	}
+}
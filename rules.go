@@ -0,0 +1,88 @@
+package formula
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoRuleMatched возвращается RuleSet.Match, если ни одно условие не
+// выполнено, а ветки по умолчанию (ELSE) нет
+var ErrNoRuleMatched = errors.New("no rule matched")
+
+// Rule - одна ветка цепочки условий с меткой исхода
+type Rule struct {
+	Label     string
+	Condition ASTNode // nil для ветки по умолчанию (ELSE)
+	Value     ASTNode
+}
+
+// RuleSet - цепочка условий IF/IFS, ветки которой помечены именами
+// исходов. Match сообщает, какая ветка сработала, и ее значение.
+// Например, для оценок:
+//
+//	node, _ := NewSimpleParser().ParseString("IFS(score >= 90, 5, score >= 80, 4, score >= 70, 3)")
+//	rules, _ := NewRuleSet(node, "A", "B", "C")
+//	label, grade, _ := rules.Match(map[string]float64{"score": 85}) // "B", 4
+type RuleSet struct {
+	Rules []Rule
+}
+
+// NewRuleSet разбивает цепочку условий на ветки и присваивает им метки по
+// порядку: сначала ветки THEN каждого условия цепочки (IF ... ELSE IF ...),
+// затем ветка ELSE, если она есть. Условия внутри значений веток остаются
+// частью значений. Число меток должно совпадать с числом веток.
+func NewRuleSet(node ASTNode, labels ...string) (*RuleSet, error) {
+	var rules []Rule
+	for node != nil {
+		conditional, ok := node.(*ConditionalNode)
+		if !ok {
+			rules = append(rules, Rule{Value: node})
+			break
+		}
+		rules = append(rules, Rule{Condition: conditional.Condition, Value: conditional.Then})
+		node = conditional.Else
+	}
+
+	if len(rules) == 0 || rules[0].Condition == nil {
+		return nil, fmt.Errorf("rule set requires an IF or IFS formula")
+	}
+	if len(labels) != len(rules) {
+		return nil, fmt.Errorf("rule set has %d branches but %d labels", len(rules), len(labels))
+	}
+	for i := range rules {
+		rules[i].Label = labels[i]
+	}
+	return &RuleSet{Rules: rules}, nil
+}
+
+// Match вычисляет правила для значений переменных vars с функциями NewContext
+// и возвращает метку и значение первой сработавшей ветки
+func (r *RuleSet) Match(vars map[string]float64) (string, float64, error) {
+	ctx := NewContext()
+	ctx.Variables = vars
+	return r.MatchContext(ctx)
+}
+
+// MatchContext вычисляет правила в заданном контексте. Условия проверяются
+// по порядку; если ни одно не выполнено и ветки ELSE нет, возвращается
+// ErrNoRuleMatched.
+func (r *RuleSet) MatchContext(ctx *Context) (string, float64, error) {
+	for _, rule := range r.Rules {
+		if rule.Condition != nil {
+			condition, err := rule.Condition.Evaluate(ctx)
+			if err != nil {
+				return "", 0, fmt.Errorf("rule %s: %w", rule.Label, err)
+			}
+			if !ctx.conditionHolds(condition) {
+				continue
+			}
+		}
+
+		value, err := rule.Value.Evaluate(ctx)
+		if err != nil {
+			return "", 0, fmt.Errorf("rule %s: %w", rule.Label, err)
+		}
+		return rule.Label, value, nil
+	}
+	return "", 0, ErrNoRuleMatched
+}
@@ -0,0 +1,74 @@
+package formula
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRuleSetGrades(t *testing.T) {
+	for _, formula := range []string{
+		"IFS(score >= 90, 5, score >= 80, 4, score >= 70, 3)",
+		"IF(score >= 90, 5, IF(score >= 80, 4, IF(score >= 70, 3)))",
+	} {
+		rules, err := NewRuleSet(mustParse(t, formula), "A", "B", "C")
+		if err != nil {
+			t.Fatalf("NewRuleSet(%q): %v", formula, err)
+		}
+		tests := []struct {
+			score float64
+			label string
+			value float64
+		}{
+			{95, "A", 5},
+			{90, "A", 5},
+			{85, "B", 4},
+			{70, "C", 3},
+		}
+		for _, tt := range tests {
+			label, value, err := rules.Match(map[string]float64{"score": tt.score})
+			if err != nil {
+				t.Errorf("%s: Match(score=%v): %v", formula, tt.score, err)
+				continue
+			}
+			if label != tt.label || value != tt.value {
+				t.Errorf("%s: Match(score=%v) = %q, %v; want %q, %v", formula, tt.score, label, value, tt.label, tt.value)
+			}
+		}
+
+		if _, _, err := rules.Match(map[string]float64{"score": 50}); !errors.Is(err, ErrNoRuleMatched) {
+			t.Errorf("%s: Match(score=50) error = %v, want ErrNoRuleMatched", formula, err)
+		}
+	}
+}
+
+// Ветка ELSE получает последнюю метку и срабатывает, если условия не выполнены
+func TestRuleSetDefault(t *testing.T) {
+	rules, err := NewRuleSet(mustParse(t, "IFS(score >= 90, 5, score >= 80, 4, 2)"), "A", "B", "F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	label, value, err := rules.Match(map[string]float64{"score": 10})
+	if err != nil || label != "F" || value != 2 {
+		t.Errorf("Match(score=10) = %q, %v, %v; want \"F\", 2", label, value, err)
+	}
+}
+
+func TestRuleSetErrors(t *testing.T) {
+	node := mustParse(t, "IFS(score >= 90, 5, score >= 80, 4, score >= 70, 3)")
+	for _, labels := range [][]string{{"A", "B"}, {"A", "B", "C", "D"}, nil} {
+		if _, err := NewRuleSet(node, labels...); err == nil {
+			t.Errorf("NewRuleSet with %d labels: expected error", len(labels))
+		}
+	}
+	if _, err := NewRuleSet(mustParse(t, "score * 2"), "A"); err == nil {
+		t.Error("NewRuleSet(score * 2): expected error")
+	}
+
+	rules, err := NewRuleSet(node, "A", "B", "C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rules.Match(nil); err == nil || errors.Is(err, ErrNoRuleMatched) {
+		t.Errorf("Match without score: error = %v, want missing variable", err)
+	}
+}
@@ -0,0 +1,110 @@
+package formula
+
+import (
+	"math"
+	"sort"
+)
+
+// maxAssignments ограничивает число перебираемых наборов значений
+const maxAssignments = 1 << 16
+
+// FindSatisfyingAssignment подбирает значения переменных vars, при которых
+// логическая формула (сравнения, объединенные AND/OR) истинна, например для
+// "A > 5 AND B < 3" вернет {A: 5.5, B: 0}. Поддерживаются линейные сравнения:
+// значения-кандидаты берутся рядом с порогами вида A > 5, после чего наборы
+// перебираются по очереди. Если подходящий набор не найден, ok равен false.
+func FindSatisfyingAssignment(node ASTNode, vars []string) (map[string]float64, bool) {
+	return findAssignment(node, vars, true)
+}
+
+// FindFalsifyingAssignment подбирает значения переменных, при которых формула ложна
+func FindFalsifyingAssignment(node ASTNode, vars []string) (map[string]float64, bool) {
+	return findAssignment(node, vars, false)
+}
+
+func findAssignment(node ASTNode, vars []string, want bool) (map[string]float64, bool) {
+	candidates := candidateValues(node, vars)
+
+	ctx := NewContext()
+	indices := make([]int, len(vars))
+	for attempt := 0; attempt < maxAssignments; attempt++ {
+		for i, name := range vars {
+			ctx.Variables[name] = candidates[name][indices[i]]
+		}
+
+		if value, err := node.Evaluate(ctx); err == nil && (value != 0) == want {
+			assignment := make(map[string]float64, len(vars))
+			for _, name := range vars {
+				assignment[name] = ctx.Variables[name]
+			}
+			return assignment, true
+		}
+
+		// Следующий набор: перебор как у счетчика с разрядами по переменным
+		i := 0
+		for ; i < len(vars); i++ {
+			indices[i]++
+			if indices[i] < len(candidates[vars[i]]) {
+				break
+			}
+			indices[i] = 0
+		}
+		if i == len(vars) {
+			break
+		}
+	}
+
+	return nil, false
+}
+
+// candidateValues собирает для каждой переменной значения вокруг порогов из
+// линейных сравнений: для A > 5 это 4, 4.5, 5, 5.5 и 6. Порог считается при
+// нулевых значениях остальных переменных.
+func candidateValues(node ASTNode, vars []string) map[string][]float64 {
+	sets := make(map[string]map[float64]bool, len(vars))
+	for _, name := range vars {
+		sets[name] = map[float64]bool{0: true}
+	}
+
+	addThresholds := func(left, right ASTNode) {
+		coeffs, constant, ok := LinearCoefficients(&OperationNode{Operator: "-", Left: left, Right: right})
+		if !ok {
+			return
+		}
+		for name, coeff := range coeffs {
+			set, tracked := sets[name]
+			if !tracked {
+				continue
+			}
+			threshold := -constant / coeff
+			if math.IsNaN(threshold) || math.IsInf(threshold, 0) {
+				continue
+			}
+			for _, delta := range []float64{-1, -0.5, 0, 0.5, 1} {
+				set[threshold+delta] = true
+			}
+		}
+	}
+	collectNames(node, func(n ASTNode) {
+		switch node := n.(type) {
+		case *ComparisonNode:
+			addThresholds(node.Left, node.Right)
+		case *SwitchNode:
+			// Каждый вариант SWITCH - сравнение выражения с ним через '='
+			for _, match := range node.Cases {
+				addThresholds(node.Subject, match)
+			}
+		}
+	})
+
+	candidates := make(map[string][]float64, len(vars))
+	for name, set := range sets {
+		values := make([]float64, 0, len(set))
+		for value := range set {
+			values = append(values, value)
+		}
+		sort.Float64s(values)
+		candidates[name] = values
+	}
+	return candidates
+}
@@ -0,0 +1,51 @@
+package formula
+
+import "testing"
+
+// holds вычисляет формулу на найденных значениях переменных
+func holds(t *testing.T, node ASTNode, assignment map[string]float64) bool {
+	t.Helper()
+	ctx := NewContext()
+	ctx.Variables = assignment
+	value, err := node.Evaluate(ctx)
+	if err != nil {
+		t.Fatalf("evaluate with %v: %v", assignment, err)
+	}
+	return value != 0
+}
+
+func TestFindSatisfyingAssignment(t *testing.T) {
+	tests := []struct {
+		formula string
+		vars    []string
+	}{
+		{"A > 5 AND B < 3", []string{"A", "B"}},
+		{"A >= 5 AND A <= 5", []string{"A"}},
+		{"(A > 10 OR B = 2) AND NOT (C != 1)", []string{"A", "B", "C"}},
+		{"2*A + 1 > B", []string{"A", "B"}},
+	}
+	for _, tt := range tests {
+		node := mustParse(t, tt.formula)
+
+		assignment, ok := FindSatisfyingAssignment(node, tt.vars)
+		if !ok {
+			t.Errorf("FindSatisfyingAssignment(%q): no assignment found", tt.formula)
+		} else if !holds(t, node, assignment) {
+			t.Errorf("FindSatisfyingAssignment(%q) = %v, formula is false", tt.formula, assignment)
+		}
+
+		assignment, ok = FindFalsifyingAssignment(node, tt.vars)
+		if !ok {
+			t.Errorf("FindFalsifyingAssignment(%q): no assignment found", tt.formula)
+		} else if holds(t, node, assignment) {
+			t.Errorf("FindFalsifyingAssignment(%q) = %v, formula is true", tt.formula, assignment)
+		}
+	}
+
+	if assignment, ok := FindSatisfyingAssignment(mustParse(t, "A > 5 AND A < 3"), []string{"A"}); ok {
+		t.Errorf("contradiction satisfied by %v", assignment)
+	}
+	if assignment, ok := FindFalsifyingAssignment(mustParse(t, "A > 5 OR A <= 5"), []string{"A"}); ok {
+		t.Errorf("tautology falsified by %v", assignment)
+	}
+}
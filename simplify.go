@@ -0,0 +1,139 @@
+package formula
+
+import "fmt"
+
+// Simplify возвращает упрощенную копию дерева:
+//   - операции, сравнения и логические выражения над одними константами
+//     сворачиваются в LiteralNode: "price * (1 + 0)" → "price";
+//   - убираются тождественные операции x*1, 1*x, x+0, 0+x, x-0, x/1 и +x;
+//   - условие с постоянным условием заменяется выбранной веткой:
+//     "IF(1 > 0, a, b)" → "a";
+//   - SWITCH с постоянным выражением заменяется выбранным значением, если
+//     все варианты до совпавшего тоже постоянные.
+//
+// Сворачивается только то, что при вычислении не может вернуть ошибку или
+// зависеть от контекста: деление на ноль ("x / 0", "x // 0"), степень
+// (MaxExponent), остаток (ModMode) и вызовы функций остаются в дереве.
+// Ложное условие без ELSE со StrictElse тоже не сворачивается. Результат
+// совпадает с исходной формулой при вычислении в контексте без FixedScale,
+// AccountingScale и Fuzzy. Исходное дерево не изменяется.
+func Simplify(node ASTNode) (ASTNode, error) {
+	if node == nil {
+		return nil, fmt.Errorf("cannot simplify nil node")
+	}
+	return simplifyNode(node), nil
+}
+
+func simplifyNode(node ASTNode) ASTNode {
+	switch n := mapChildren(node, simplifyNode).(type) {
+	case *OperationNode:
+		return simplifyOperation(n)
+
+	case *ComparisonNode:
+		l, lok := literalValue(n.Left)
+		r, rok := literalValue(n.Right)
+		if lok && rok {
+			if value, err := evalComparison(n.Operator, l, r, nil); err == nil {
+				return literal(value)
+			}
+		}
+		return n
+
+	case *LogicalNode:
+		l, lok := literalValue(n.Left)
+		r, rok := literalValue(n.Right)
+		if lok && rok {
+			if value, err := evalLogical(n.Operator, l, r, nil); err == nil {
+				return literal(value)
+			}
+		}
+		return n
+
+	case *UnaryNode:
+		if n.Operator == "+" {
+			return n.Operand
+		}
+		if operand, ok := literalValue(n.Operand); ok {
+			if value, err := evalUnary(n.Operator, operand, nil); err == nil {
+				return literal(value)
+			}
+		}
+		return n
+
+	case *ConditionalNode:
+		condition, ok := literalValue(n.Condition)
+		switch {
+		case !ok:
+			return n
+		case condition != 0:
+			return n.Then
+		case n.Else != nil:
+			return n.Else
+		case !n.StrictElse:
+			return literal(0)
+		}
+		return n
+
+	case *SwitchNode:
+		return simplifySwitch(n)
+
+	default:
+		return n
+	}
+}
+
+// simplifySwitch выбирает значение SWITCH, если выражение и варианты до
+// совпавшего - константы
+func simplifySwitch(n *SwitchNode) ASTNode {
+	subject, ok := literalValue(n.Subject)
+	if !ok {
+		return n
+	}
+	for i, match := range n.Cases {
+		value, ok := literalValue(match)
+		if !ok {
+			return n
+		}
+		if value == subject {
+			return n.Values[i]
+		}
+	}
+	if n.Default != nil {
+		return n.Default
+	}
+	return literal(0)
+}
+
+// simplifyOperation сворачивает арифметику над константами и убирает
+// тождественные операции
+func simplifyOperation(n *OperationNode) ASTNode {
+	l, lok := literalValue(n.Left)
+	r, rok := literalValue(n.Right)
+
+	if lok && rok {
+		switch n.Operator {
+		case "+", "-", "*":
+			if value, err := evalOperation(n.Operator, l, r, nil); err == nil {
+				return literal(value)
+			}
+		case "/", "//":
+			if r != 0 {
+				if value, err := evalOperation(n.Operator, l, r, nil); err == nil {
+					return literal(value)
+				}
+			}
+		}
+	}
+
+	switch {
+	case n.Operator == "+" && rok && r == 0, n.Operator == "-" && rok && r == 0:
+		return n.Left
+	case n.Operator == "+" && lok && l == 0:
+		return n.Right
+	case n.Operator == "*" && rok && r == 1, n.Operator == "/" && rok && r == 1:
+		return n.Left
+	case n.Operator == "*" && lok && l == 1:
+		return n.Right
+	}
+	return n
+}
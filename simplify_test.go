@@ -0,0 +1,87 @@
+package formula
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    string
+	}{
+		{"price * (1 + 0)", "price"},
+		{"IF(1 > 0, a, b)", "a"},
+		{"IF(1 < 0, a, b)", "b"},
+		{"(2 + 3) * x", "5 * x"},
+		{"0 + x - 0", "x"},
+		{"x / 1 + +y", "x + y"},
+		{"NOT (1 > 2) AND x", "1 AND x"},
+		{"SWITCH(2, 1, a, 2, b, c)", "b"},
+		{"SWITCH(x, 1, a, b)", "SWITCH(x, 1, a, b)"},
+		{"6 // 4 + x", "1 + x"},
+	}
+	for _, tt := range tests {
+		node := mustParse(t, tt.formula)
+		got, err := Simplify(node)
+		if err != nil {
+			t.Fatalf("Simplify(%q): %v", tt.formula, err)
+		}
+		if !reflect.DeepEqual(got, mustParse(t, tt.want)) {
+			t.Errorf("Simplify(%q) = %v, want %v", tt.formula, got, tt.want)
+		}
+	}
+}
+
+// Упрощенное дерево вычисляется так же, как исходное
+func TestSimplifyPreservesValue(t *testing.T) {
+	vars := map[string]float64{"price": 12.5, "a": 3, "b": 4, "x": 7}
+	for _, formula := range []string{"price * (1 + 0)", "IF(1 > 0, a, b)", "(a + 0) * 1 - (2 * 3) / x"} {
+		node := mustParse(t, formula)
+		simplified, err := Simplify(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, eval := range evalPaths(t, simplified) {
+			got, err := eval(&Context{Variables: vars})
+			if err != nil {
+				t.Fatalf("%s(%v): %v", name, simplified, err)
+			}
+			if want := evalFormula(t, formula, vars); got != want {
+				t.Errorf("%s(%v) = %v, want %v as for %q", name, simplified, got, want, formula)
+			}
+		}
+	}
+}
+
+// Деление на ноль не сворачивается: ошибка остается при вычислении
+func TestSimplifyKeepsDivisionByZero(t *testing.T) {
+	for _, formula := range []string{"x / 0", "x // 0", "6 / (1 - 1)"} {
+		node := mustParse(t, formula)
+		simplified, err := Simplify(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var division *OperationNode
+		Walk(simplified, func(n ASTNode) bool {
+			if op, ok := n.(*OperationNode); ok && (op.Operator == "/" || op.Operator == "//") {
+				division = op
+			}
+			return true
+		})
+		if division == nil {
+			t.Errorf("Simplify(%q) = %v: division removed", formula, simplified)
+			continue
+		}
+		if _, err := simplified.Evaluate(&Context{Variables: map[string]float64{"x": 5}}); err == nil || !strings.Contains(err.Error(), "division by zero") {
+			t.Errorf("%v: error = %v, want division by zero", simplified, err)
+		}
+	}
+}
+
+func TestSimplifyNil(t *testing.T) {
+	if _, err := Simplify(nil); err == nil {
+		t.Error("Simplify(nil): expected error")
+	}
+}
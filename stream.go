@@ -0,0 +1,179 @@
+package formula
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// StreamContext вычисляет формулы над потоком наблюдений и хранит для каждой
+// переменной последние значения, по которым считаются скользящие агрегаты
+// MOVINGAVG(name, n) и MOVINGSUM(name, n). Например, формула
+// "value / MOVINGAVG(value, 5)" сравнивает текущее значение со средним
+// за последние 5 шагов, включая текущий.
+type StreamContext struct {
+	// Context содержит значения текущего шага и доступные функции
+	Context *Context
+
+	maxWindow int
+	windows   map[string]*ringBuffer
+}
+
+// NewStreamContext создает потоковый контекст, хранящий до maxWindow
+// последних значений каждой переменной. maxWindow должен быть положительным.
+func NewStreamContext(maxWindow int) (*StreamContext, error) {
+	if maxWindow < 1 {
+		return nil, fmt.Errorf("stream window limit must be positive, got %d", maxWindow)
+	}
+	return &StreamContext{
+		Context:   NewContext(),
+		maxWindow: maxWindow,
+		windows:   make(map[string]*ringBuffer),
+	}, nil
+}
+
+// Push переходит к следующему шагу потока: vars становятся текущими значениями
+// переменных и добавляются в окна. Переменные, отсутствующие в vars, в окна
+// на этом шаге не добавляются.
+func (s *StreamContext) Push(vars map[string]float64) {
+	s.Context.Variables = make(map[string]float64, len(vars))
+	for name, value := range vars {
+		s.Context.Variables[name] = value
+
+		window, ok := s.windows[name]
+		if !ok {
+			window = &ringBuffer{values: make([]float64, s.maxWindow)}
+			s.windows[name] = window
+		}
+		window.push(value)
+	}
+}
+
+// Evaluate вычисляет формулу на текущем шаге. Первым аргументом MOVINGAVG и
+// MOVINGSUM должна быть переменная; если значений в окне меньше n, агрегат
+// считается по имеющимся.
+func (s *StreamContext) Evaluate(node ASTNode) (float64, error) {
+	bound, err := s.bindWindows(node)
+	if err != nil {
+		return 0, err
+	}
+	return bound.Evaluate(s.Context)
+}
+
+// bindWindows заменяет вызовы скользящих агрегатов узлами, читающими окна потока
+func (s *StreamContext) bindWindows(node ASTNode) (ASTNode, error) {
+	if fn, ok := node.(*FunctionNode); ok {
+		var aggregate windowAggregate
+		switch strings.ToUpper(fn.Name) {
+		case "MOVINGAVG":
+			aggregate = windowAverage
+		case "MOVINGSUM":
+			aggregate = windowSum
+		}
+
+		if aggregate != nil {
+			if len(fn.Args) != 2 {
+				return nil, fmt.Errorf("%s requires exactly 2 arguments", fn.Name)
+			}
+			variable, ok := fn.Args[0].(*VariableNode)
+			if !ok {
+				return nil, fmt.Errorf("first argument of %s must be a variable name", fn.Name)
+			}
+			size, err := s.bindWindows(fn.Args[1])
+			if err != nil {
+				return nil, err
+			}
+			return &windowNode{stream: s, function: fn.Name, name: variable.Name, size: size, aggregate: aggregate}, nil
+		}
+	}
+
+	var bindErr error
+	bound := mapChildren(node, func(child ASTNode) ASTNode {
+		result, err := s.bindWindows(child)
+		if err != nil && bindErr == nil {
+			bindErr = err
+		}
+		return result
+	})
+	return bound, bindErr
+}
+
+// windowAggregate вычисляет агрегат по значениям окна
+type windowAggregate func(values []float64) float64
+
+func windowSum(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+func windowAverage(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	return windowSum(values) / float64(len(values))
+}
+
+// windowNode - вызов скользящего агрегата, привязанный к окну потока
+type windowNode struct {
+	stream    *StreamContext
+	function  string
+	name      string
+	size      ASTNode
+	aggregate windowAggregate
+}
+
+func (n *windowNode) Evaluate(ctx *Context) (float64, error) {
+	size, err := n.size.Evaluate(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if size < 1 || size != math.Trunc(size) {
+		return 0, fmt.Errorf("%s window size must be a positive integer, got %v", n.function, size)
+	}
+	if int(size) > n.stream.maxWindow {
+		return 0, fmt.Errorf("%s window size %v exceeds the stream limit %d", n.function, size, n.stream.maxWindow)
+	}
+
+	window, ok := n.stream.windows[n.name]
+	if !ok {
+		return 0, missingVariableError{name: n.name}
+	}
+	return n.aggregate(window.last(int(size))), nil
+}
+
+func (n *windowNode) GetType() NodeType {
+	return NodeTypeFunction
+}
+
+// ringBuffer хранит последние len(values) значений
+type ringBuffer struct {
+	values []float64
+	next   int
+	count  int
+}
+
+func (b *ringBuffer) push(value float64) {
+	if len(b.values) == 0 {
+		return
+	}
+	b.values[b.next] = value
+	b.next = (b.next + 1) % len(b.values)
+	if b.count < len(b.values) {
+		b.count++
+	}
+}
+
+// last возвращает до n последних значений в порядке поступления
+func (b *ringBuffer) last(n int) []float64 {
+	if n > b.count {
+		n = b.count
+	}
+	result := make([]float64, n)
+	for i := 0; i < n; i++ {
+		result[i] = b.values[(b.next-n+i+len(b.values))%len(b.values)]
+	}
+	return result
+}
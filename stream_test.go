@@ -0,0 +1,46 @@
+package formula
+
+import "testing"
+
+func TestStreamMovingAverage(t *testing.T) {
+	stream, err := NewStreamContext(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := mustParse(t, "value / MOVINGAVG(value, 3)")
+	sum := mustParse(t, "MOVINGSUM(value, 5)")
+
+	tests := []struct {
+		value, ratio, sum float64
+	}{
+		{2, 1, 2},           // окно [2]
+		{4, 4.0 / 3, 6},     // [2 4]
+		{6, 1.5, 12},        // [2 4 6]
+		{12, 36.0 / 22, 24}, // [4 6 12]
+	}
+	for i, tt := range tests {
+		stream.Push(map[string]float64{"value": tt.value})
+		got, err := stream.Evaluate(node)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if got != tt.ratio {
+			t.Errorf("step %d: ratio = %v, want %v", i, got, tt.ratio)
+		}
+		if got, _ := stream.Evaluate(sum); got != tt.sum {
+			t.Errorf("step %d: MOVINGSUM = %v, want %v", i, got, tt.sum)
+		}
+	}
+
+	if _, err := stream.Evaluate(mustParse(t, "MOVINGAVG(value, 6)")); err == nil {
+		t.Error("window larger than the stream limit: expected error")
+	}
+}
+
+func TestNewStreamContextRejectsWindow(t *testing.T) {
+	for _, maxWindow := range []int{0, -1} {
+		if _, err := NewStreamContext(maxWindow); err == nil {
+			t.Errorf("NewStreamContext(%d): expected error", maxWindow)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package formula
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Decision описывает выбор ветки в условном выражении
+type Decision struct {
+	Node      *ConditionalNode
+	Condition float64 // вычисленное значение условия
+	Taken     bool    // условие истинно
+	Branch    string  // "then", "else" или "none", если ветки ELSE нет
+}
+
+// Trace содержит путь принятия решений при вычислении формулы
+type Trace struct {
+	Decisions []Decision
+	Variables map[string]float64 // значения переменных, участвующих в условиях
+	Result    float64
+}
+
+// EvaluateWithTrace вычисляет формулу и записывает, какие условия были
+// проверены, каким оказалось их значение и какая ветка была выбрана.
+// Для вложенных условий записывается вся цепочка решений в порядке вычисления.
+func EvaluateWithTrace(node ASTNode, ctx *Context) (float64, *Trace, error) {
+	traced := Context{}
+	if ctx != nil {
+		traced = *ctx
+	}
+	traced.trace = &Trace{Variables: make(map[string]float64)}
+
+	result, err := node.Evaluate(&traced)
+	traced.trace.Result = result
+	for name := range traced.trace.conditionVariables() {
+		if value, ok, err := traced.lookupVariable(name); ok && err == nil {
+			traced.trace.Variables[name] = value
+		}
+	}
+	return result, traced.trace, err
+}
+
+// recordDecision добавляет решение по условному узлу; taken - выбрана ветка THEN
+func (t *Trace) recordDecision(node *ConditionalNode, condition float64, taken bool) {
+	decision := Decision{
+		Node:      node,
+		Condition: condition,
+		Taken:     taken,
+		Branch:    "then",
+	}
+	if !decision.Taken {
+		decision.Branch = "else"
+		if node.Else == nil {
+			decision.Branch = "none"
+		}
+	}
+	t.Decisions = append(t.Decisions, decision)
+}
+
+// String возвращает компактный путь решений, например
+// "score=85 → not(>=90), (>=80)=true → 4"
+func (t *Trace) String() string {
+	var parts []string
+
+	var values []string
+	for _, name := range sortedKeys(t.conditionVariables()) {
+		if value, ok := t.Variables[name]; ok {
+			values = append(values, name+"="+formatNumber(value))
+		}
+	}
+	if len(values) > 0 {
+		parts = append(parts, strings.Join(values, ", "))
+	}
+
+	var decisions []string
+	for _, d := range t.Decisions {
+		condition := describeCondition(d.Node.Condition)
+		if d.Taken {
+			decisions = append(decisions, "("+condition+")=true")
+		} else {
+			decisions = append(decisions, "not("+condition+")")
+		}
+	}
+	if len(decisions) > 0 {
+		parts = append(parts, strings.Join(decisions, ", "))
+	}
+
+	parts = append(parts, formatNumber(t.Result))
+	return strings.Join(parts, " → ")
+}
+
+// conditionVariables возвращает множество переменных из проверенных условий
+func (t *Trace) conditionVariables() map[string]bool {
+	names := make(map[string]bool)
+	for _, d := range t.Decisions {
+		for _, name := range CollectVariables(d.Node.Condition) {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// describeCondition возвращает краткое описание условия. Для сравнения
+// переменной с операндом имя переменной опускается, так как ее значение
+// выводится в начале пути: ">=90"
+func describeCondition(node ASTNode) string {
+	if cmp, ok := node.(*ComparisonNode); ok {
+		if _, isVariable := cmp.Left.(*VariableNode); isVariable {
+			return cmp.Operator + StringLocalized(cmp.Right, LanguageEnglish)
+		}
+	}
+	return StringLocalized(node, LanguageEnglish)
+}
+
+// formatNumber форматирует число без лишних нулей
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
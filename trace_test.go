@@ -0,0 +1,40 @@
+package formula
+
+import "testing"
+
+const gradeFormula = "IF score >= 90 THEN 5 ELSE IF score >= 80 THEN 4 ELSE IF score >= 70 THEN 3 ELSE 2"
+
+func TestTraceDecisionPath(t *testing.T) {
+	node := mustParse(t, gradeFormula)
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{85, "score=85 → not(>=90), (>=80)=true → 4"},
+		{95, "score=95 → (>=90)=true → 5"},
+		{10, "score=10 → not(>=90), not(>=80), not(>=70) → 2"},
+	}
+	for _, tt := range tests {
+		ctx := NewContext()
+		ctx.Variables = map[string]float64{"score": tt.score}
+		_, trace, err := EvaluateWithTrace(node, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := trace.String(); got != tt.want {
+			t.Errorf("score=%v: %q, want %q", tt.score, got, tt.want)
+		}
+	}
+
+	ctx := NewContext()
+	ctx.Variables = map[string]float64{"score": 85}
+	_, trace, _ := EvaluateWithTrace(node, ctx)
+	if len(trace.Decisions) != 2 || trace.Decisions[0].Branch != "else" || trace.Decisions[1].Branch != "then" {
+		t.Errorf("decisions = %+v, want else then then", trace.Decisions)
+	}
+
+	_, trace, _ = EvaluateWithTrace(mustParse(t, "IF(score > 100, 1)"), ctx)
+	if trace.Decisions[0].Branch != "none" {
+		t.Errorf("false condition without ELSE: branch %q, want none", trace.Decisions[0].Branch)
+	}
+}
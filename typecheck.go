@@ -0,0 +1,261 @@
+package formula
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrTypeMismatch возвращается TypeCheck, если логическое значение
+// используется как число или число - как логическое значение
+var ErrTypeMismatch = errors.New("type mismatch")
+
+// ResultType - тип значения узла при проверке типов
+type ResultType int
+
+const (
+	Numeric ResultType = iota // число
+	Boolean                   // результат сравнения, AND, OR или NOT
+
+	// untyped - литералы 0 и 1 (в том числе TRUE и FALSE), допустимые и как
+	// число, и как логическое значение
+	untyped
+)
+
+// ValueType - объявленный тип переменной, см. ParseVariableTypes и
+// FormulaValidator.SetVariableTypes
+type ValueType = ResultType
+
+func (t ResultType) String() string {
+	switch t {
+	case Boolean:
+		return "boolean"
+	default:
+		return "numeric"
+	}
+}
+
+// TypeCheck проверяет, что логические значения и числа не смешиваются:
+//   - операнды арифметики, унарного минуса и модуля - числа: "(A > B) + 3" - ошибка;
+//   - операнды AND, OR и NOT - логические значения: "A AND B" - ошибка;
+//   - условие IF - логическое значение, ветки THEN и ELSE одного типа;
+//   - сравнения <, >, <=, >= сравнивают числа, = и != - значения одного типа.
+//
+// Литералы 0 и 1 (TRUE, FALSE) подходят для обоих типов, переменные и вызовы
+// функций считаются числами, аргументы функций могут быть любого типа.
+// Объявленные типы переменных учитывает валидатор, см. SetVariableTypes.
+// Проверка не влияет на вычисление: Evaluate по-прежнему считает логические
+// значения числами 0 и 1. Возвращаются тип результата формулы и все найденные
+// ошибки, каждая оборачивает ErrTypeMismatch.
+func TypeCheck(node ASTNode) (ResultType, []error) {
+	checker := &typeChecker{}
+	return checker.run(node), checker.errs
+}
+
+// ParseVariableTypes разбирает объявления типов переменных вида
+// "price:number, active:bool". Объявления разделяются запятыми, точками с
+// запятой или переводами строк; типы - number (numeric) и bool (boolean),
+// без учета регистра.
+func ParseVariableTypes(declarations string) (map[string]ValueType, error) {
+	types := make(map[string]ValueType)
+	fields := strings.FieldsFunc(declarations, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n'
+	})
+
+	for _, field := range fields {
+		field = strings.TrimFunc(field, unicode.IsSpace)
+		if field == "" {
+			continue
+		}
+		name, typeName, ok := strings.Cut(field, ":")
+		name, typeName = strings.TrimSpace(name), strings.TrimSpace(typeName)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid type declaration '%s', expected name:type", field)
+		}
+
+		switch strings.ToLower(typeName) {
+		case "number", "numeric":
+			types[name] = Numeric
+		case "bool", "boolean":
+			types[name] = Boolean
+		default:
+			return nil, fmt.Errorf("unknown type '%s' for variable '%s'", typeName, name)
+		}
+	}
+	return types, nil
+}
+
+// InferType выводит тип результата формулы с учетом объявленных типов
+// переменных varTypes (nil - все переменные числа) и возвращает типы всех
+// поддеревьев, например для подсказки в редакторе "это подвыражение -
+// логическое значение". Литералы 0 и 1 в types считаются числами. Правила те
+// же, что у TypeCheck; все найденные несоответствия объединяются в err через
+// errors.Join, при этом тип и types заполняются полностью.
+// Типы поддеревьев возвращаются вместе с типом формулы, потому что вычисляются
+// тем же проходом: отдельный вызов для каждого поддерева повторял бы вывод
+// заново и давал квадратичную сложность.
+func InferType(node ASTNode, varTypes map[string]ValueType) (ValueType, map[ASTNode]ValueType, error) {
+	checker := &typeChecker{variables: varTypes, types: make(map[ASTNode]ValueType)}
+	result := checker.run(node)
+	return result, checker.types, errors.Join(checker.errs...)
+}
+
+type typeChecker struct {
+	variables map[string]ValueType  // объявленные типы переменных
+	types     map[ASTNode]ValueType // типы поддеревьев, если нужны вызывающему
+	errs      []error
+	nodes     []ASTNode // узел, к которому относится каждая ошибка
+}
+
+// run проверяет дерево и возвращает тип результата
+func (c *typeChecker) run(node ASTNode) ResultType {
+	result := c.check(node)
+	if result == untyped {
+		result = Numeric
+	}
+	return result
+}
+
+func (c *typeChecker) check(node ASTNode) ResultType {
+	result := c.infer(node)
+	if c.types != nil && node != nil {
+		if result == untyped {
+			c.types[node] = Numeric
+		} else {
+			c.types[node] = result
+		}
+	}
+	return result
+}
+
+func (c *typeChecker) infer(node ASTNode) ResultType {
+	switch n := node.(type) {
+	case *LiteralNode:
+		if n.Value == 0 || n.Value == 1 {
+			return untyped
+		}
+		return Numeric
+
+	case *VariableNode:
+		if t, ok := c.variables[n.Name]; ok {
+			return t
+		}
+		return Numeric
+
+	case *OperationNode:
+		c.expect(n.Left, Numeric, "operand of '"+n.Operator+"'")
+		c.expect(n.Right, Numeric, "operand of '"+n.Operator+"'")
+		return Numeric
+
+	case *UnaryNode:
+		if n.Operator == "NOT" {
+			c.expect(n.Operand, Boolean, "operand of NOT")
+			return Boolean
+		}
+		c.expect(n.Operand, Numeric, "operand of '"+n.Operator+"'")
+		return Numeric
+
+	case *ComparisonNode:
+		if isComparisonOrdering(n.Operator) {
+			c.expect(n.Left, Numeric, "operand of '"+n.Operator+"'")
+			c.expect(n.Right, Numeric, "operand of '"+n.Operator+"'")
+			return Boolean
+		}
+		left, right := c.check(n.Left), c.check(n.Right)
+		if _, ok := unifyTypes(left, right); !ok {
+			c.errorf(n, "cannot compare %s '%s' with %s '%s'", left, n.Left, right, n.Right)
+		}
+		return Boolean
+
+	case *LogicalNode:
+		c.expect(n.Left, Boolean, "operand of "+n.Operator)
+		c.expect(n.Right, Boolean, "operand of "+n.Operator)
+		return Boolean
+
+	case *ConditionalNode:
+		c.expect(n.Condition, Boolean, "IF condition")
+		then := c.check(n.Then)
+		if n.Else == nil {
+			// Ложное условие без ELSE дает 0, подходящий для любого типа
+			return then
+		}
+		otherwise := c.check(n.Else)
+		result, ok := unifyTypes(then, otherwise)
+		if !ok {
+			c.errorf(n, "IF branches have different types: THEN is %s, ELSE is %s", then, otherwise)
+		}
+		return result
+
+	case *SwitchNode:
+		subject := c.check(n.Subject)
+		for _, match := range n.Cases {
+			if t := c.check(match); !canUnify(subject, t) {
+				c.errorf(n, "cannot compare SWITCH %s '%s' with %s case '%s'", subject, n.Subject, t, match)
+			}
+		}
+		result := untyped
+		for _, value := range append(append([]ASTNode(nil), n.Values...), n.Default) {
+			if value == nil {
+				continue
+			}
+			t := c.check(value)
+			unified, ok := unifyTypes(result, t)
+			if !ok {
+				c.errorf(n, "SWITCH values have different types: %s and %s", result, t)
+			}
+			result = unified
+		}
+		return result
+
+	case *FunctionNode:
+		for _, arg := range n.Args {
+			c.check(arg)
+		}
+		return Numeric
+
+	default:
+		return Numeric
+	}
+}
+
+// expect проверяет узел и сообщает об ошибке, если его тип не want
+func (c *typeChecker) expect(node ASTNode, want ResultType, role string) {
+	if node == nil {
+		return
+	}
+	if got := c.check(node); got != untyped && got != want {
+		c.errorf(node, "%s '%s' used as %s", got, node, role)
+	}
+}
+
+func (c *typeChecker) errorf(node ASTNode, format string, args ...interface{}) {
+	c.errs = append(c.errs, fmt.Errorf("%w: "+format, append([]interface{}{ErrTypeMismatch}, args...)...))
+	c.nodes = append(c.nodes, node)
+}
+
+// unifyTypes возвращает общий тип двух значений
+func unifyTypes(a, b ResultType) (ResultType, bool) {
+	switch {
+	case a == untyped:
+		return b, true
+	case b == untyped, a == b:
+		return a, true
+	}
+	return a, false
+}
+
+// canUnify сообщает, что у двух значений есть общий тип
+func canUnify(a, b ResultType) bool {
+	_, ok := unifyTypes(a, b)
+	return ok
+}
+
+// isComparisonOrdering сообщает, что сравнение упорядочивает числа
+func isComparisonOrdering(operator string) bool {
+	switch operator {
+	case ">", "<", ">=", "<=":
+		return true
+	}
+	return false
+}
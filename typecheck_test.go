@@ -0,0 +1,162 @@
+package formula
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTypeCheck(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    ResultType
+		errs    int
+	}{
+		{"A + B * 2", Numeric, 0},
+		{"A > B AND C < 1", Boolean, 0},
+		{"(A > B) + 3", Numeric, 1},
+		{"A AND B", Boolean, 2},
+		{"NOT A", Boolean, 1},
+		{"IF(A, 1, 2)", Numeric, 1},
+		{"IF(A > B, C > 1, 5)", Boolean, 1},
+		{"IF(A > B, C > 1)", Boolean, 0},
+		{"(A > B) = C", Boolean, 1},
+		{"(A > B) > 1", Boolean, 1},
+		{"max(A > B, C)", Numeric, 0},
+
+		// Литералы 0 и 1 подходят для обоих типов
+		{"(A > B) AND 1", Boolean, 0},
+		{"IF(A > B, C > 1, 0)", Boolean, 0},
+		{"IF(1, A, B)", Numeric, 0},
+		{"(A > B) = 1", Boolean, 0},
+		{"0 + 1", Numeric, 0},
+		{"1", Numeric, 0},
+		{"(A > B) AND 2", Boolean, 1},
+	}
+	for _, tt := range tests {
+		got, errs := TypeCheck(mustParse(t, tt.formula))
+		if got != tt.want {
+			t.Errorf("TypeCheck(%q) type = %v, want %v", tt.formula, got, tt.want)
+		}
+		if len(errs) != tt.errs {
+			t.Errorf("TypeCheck(%q) = %d errors %v, want %d", tt.formula, len(errs), errs, tt.errs)
+		}
+		for _, err := range errs {
+			if !errors.Is(err, ErrTypeMismatch) {
+				t.Errorf("TypeCheck(%q): error %v does not wrap ErrTypeMismatch", tt.formula, err)
+			}
+		}
+	}
+}
+
+// Проверка типов не влияет на вычисление
+func TestTypeCheckDoesNotChangeEvaluation(t *testing.T) {
+	vars := map[string]float64{"A": 3, "B": 2}
+	if _, errs := TypeCheck(mustParse(t, "(A > B) + 3")); len(errs) == 0 {
+		t.Fatal("(A > B) + 3: expected a type error")
+	}
+	if got := evalFormula(t, "(A > B) + 3", vars); got != 4 {
+		t.Errorf("(A > B) + 3 = %v, want 4", got)
+	}
+}
+
+func TestParseVariableTypes(t *testing.T) {
+	types, err := ParseVariableTypes("price:number, active : Bool; qty:numeric\nvip:boolean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]ValueType{"price": Numeric, "active": Boolean, "qty": Numeric, "vip": Boolean}
+	if len(types) != len(want) {
+		t.Errorf("ParseVariableTypes = %v, want %v", types, want)
+	}
+	for name, typ := range want {
+		if types[name] != typ {
+			t.Errorf("type of %s = %v, want %v", name, types[name], typ)
+		}
+	}
+
+	if types, err := ParseVariableTypes(""); err != nil || len(types) != 0 {
+		t.Errorf("empty declarations = %v, %v; want no types", types, err)
+	}
+
+	for _, declarations := range []string{"price", "price:money", ":bool", "price:number, active"} {
+		if _, err := ParseVariableTypes(declarations); err == nil {
+			t.Errorf("ParseVariableTypes(%q): expected error", declarations)
+		}
+	}
+}
+
+// InferType учитывает объявленные типы и возвращает типы всех поддеревьев
+func TestInferType(t *testing.T) {
+	varTypes := map[string]ValueType{"active": Boolean, "vip": Boolean}
+	tests := []struct {
+		formula string
+		want    ValueType
+		wantErr bool
+	}{
+		{"price * qty + 10", Numeric, false},
+		{"active AND price > 100", Boolean, false},
+		{"IF(active, price * 0.9, price)", Numeric, false},
+		{"IF(vip OR qty > 10, active, 0)", Boolean, false},
+		{"max(price, 10) >= 5 OR NOT vip", Boolean, false},
+		{"active + 1", Numeric, true},
+		{"price AND vip", Boolean, true},
+		{"IF(active, price, vip)", Numeric, true},
+		{"1", Numeric, false},
+	}
+	for _, tt := range tests {
+		got, types, err := InferType(mustParse(t, tt.formula), varTypes)
+		if got != tt.want {
+			t.Errorf("InferType(%q) = %v, want %v", tt.formula, got, tt.want)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("InferType(%q) error = %v, wantErr %v", tt.formula, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("InferType(%q): error %v does not wrap ErrTypeMismatch", tt.formula, err)
+		}
+		if len(types) == 0 {
+			t.Errorf("InferType(%q): no subtree types", tt.formula)
+		}
+	}
+}
+
+func TestInferTypeSubtrees(t *testing.T) {
+	node := mustParse(t, "IF(active AND price > 100, price * 0.9, 1)")
+	got, types, err := InferType(node, map[string]ValueType{"active": Boolean})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != Numeric {
+		t.Errorf("type = %v, want %v", got, Numeric)
+	}
+
+	want := map[string]ValueType{
+		"IF active AND price > 100 THEN price * 0.9 ELSE 1": Numeric,
+		"active AND price > 100":                            Boolean,
+		"active":                                            Boolean,
+		"price > 100":                                       Boolean,
+		"price":                                             Numeric,
+		"100":                                               Numeric,
+		"price * 0.9":                                       Numeric,
+		"0.9":                                               Numeric,
+		"1":                                                 Numeric,
+	}
+	seen := make(map[string]bool)
+	Walk(node, func(n ASTNode) bool {
+		text := formatter{}.format(n)
+		typ, ok := types[n]
+		switch {
+		case !ok:
+			t.Errorf("no type for subtree %q", text)
+		case typ != want[text]:
+			t.Errorf("type of %q = %v, want %v", text, typ, want[text])
+		}
+		seen[text] = true
+		return true
+	})
+	for text := range want {
+		if !seen[text] {
+			t.Errorf("subtree %q not found", text)
+		}
+	}
+}
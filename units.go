@@ -0,0 +1,272 @@
+package formula
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrDimensionMismatch = errors.New("dimension mismatch")
+)
+
+// dimension хранит степени базовых единиц, например м/с^2 -> {m: 1, s: -2}
+type dimension map[string]int
+
+// CheckDimensions проверяет согласованность единиц измерения в формуле.
+// units задает единицы переменных в виде "m", "m/s", "kg*m/s^2";
+// переменные без единиц и литералы считаются безразмерными.
+// Сложение, вычитание и сравнение требуют одинаковых единиц, умножение и
+// деление комбинируют их: distance/time допустимо, distance + time - нет.
+func CheckDimensions(node ASTNode, units map[string]string) error {
+	parsed := make(map[string]dimension, len(units))
+	for name, unit := range units {
+		dim, err := parseUnit(unit)
+		if err != nil {
+			return fmt.Errorf("invalid unit for variable '%s': %v", name, err)
+		}
+		parsed[name] = dim
+	}
+
+	_, err := inferDimension(node, parsed)
+	return err
+}
+
+// inferDimension вычисляет размерность узла
+func inferDimension(node ASTNode, units map[string]dimension) (dimension, error) {
+	switch n := node.(type) {
+	case *LiteralNode:
+		return dimension{}, nil
+
+	case *VariableNode:
+		if dim, ok := units[n.Name]; ok {
+			return dim, nil
+		}
+		return dimension{}, nil
+
+	case *OperationNode:
+		left, err := inferDimension(n.Left, units)
+		if err != nil {
+			return nil, err
+		}
+		right, err := inferDimension(n.Right, units)
+		if err != nil {
+			return nil, err
+		}
+
+		switch n.Operator {
+		case "*":
+			return left.combine(right, 1), nil
+		case "/", "//":
+			return left.combine(right, -1), nil
+		case "^", "**":
+			return powerDimension(left, right, n.Right)
+		default:
+			if !left.equal(right) {
+				return nil, fmt.Errorf("%w: cannot apply '%s' to %s and %s", ErrDimensionMismatch, n.Operator, left, right)
+			}
+			return left, nil
+		}
+
+	case *ComparisonNode:
+		left, err := inferDimension(n.Left, units)
+		if err != nil {
+			return nil, err
+		}
+		right, err := inferDimension(n.Right, units)
+		if err != nil {
+			return nil, err
+		}
+		if !left.equal(right) {
+			return nil, fmt.Errorf("%w: cannot compare %s with %s", ErrDimensionMismatch, left, right)
+		}
+		return dimension{}, nil
+
+	case *ConditionalNode:
+		if _, err := inferDimension(n.Condition, units); err != nil {
+			return nil, err
+		}
+		then, err := inferDimension(n.Then, units)
+		if err != nil {
+			return nil, err
+		}
+		if n.Else == nil {
+			return then, nil
+		}
+		otherwise, err := inferDimension(n.Else, units)
+		if err != nil {
+			return nil, err
+		}
+		if !then.equal(otherwise) {
+			return nil, fmt.Errorf("%w: conditional branches have units %s and %s", ErrDimensionMismatch, then, otherwise)
+		}
+		return then, nil
+
+	case *SwitchNode:
+		subject, err := inferDimension(n.Subject, units)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range n.Cases {
+			value, err := inferDimension(match, units)
+			if err != nil {
+				return nil, err
+			}
+			if !subject.equal(value) {
+				return nil, fmt.Errorf("%w: cannot compare %s with %s", ErrDimensionMismatch, subject, value)
+			}
+		}
+		var result dimension
+		for i, value := range append(append([]ASTNode(nil), n.Values...), n.Default) {
+			if value == nil {
+				continue
+			}
+			d, err := inferDimension(value, units)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 && !result.equal(d) {
+				return nil, fmt.Errorf("%w: SWITCH values have units %s and %s", ErrDimensionMismatch, result, d)
+			}
+			result = d
+		}
+		return result, nil
+
+	case *UnaryNode:
+		operand, err := inferDimension(n.Operand, units)
+		if n.Operator == "NOT" && err == nil {
+			return dimension{}, nil // отрицание дает безразмерное логическое значение
+		}
+		return operand, err
+
+	default:
+		// Логические операции и функции дают безразмерный результат,
+		// но их операнды все равно проверяются
+		for _, child := range children(node) {
+			if _, err := inferDimension(child, units); err != nil {
+				return nil, err
+			}
+		}
+		return dimension{}, nil
+	}
+}
+
+// powerDimension вычисляет размерность степени: показатель должен быть
+// безразмерным, а размерное основание допускает только целый литеральный показатель
+func powerDimension(base, exponent dimension, exponentNode ASTNode) (dimension, error) {
+	if len(exponent) > 0 {
+		return nil, fmt.Errorf("%w: exponent must be dimensionless, got %s", ErrDimensionMismatch, exponent)
+	}
+	if len(base) == 0 {
+		return base, nil
+	}
+
+	literal, ok := exponentNode.(*LiteralNode)
+	if !ok || literal.Value != float64(int(literal.Value)) {
+		return nil, fmt.Errorf("%w: %s can only be raised to an integer literal power", ErrDimensionMismatch, base)
+	}
+
+	result := dimension{}
+	for unit, power := range base {
+		if p := power * int(literal.Value); p != 0 {
+			result[unit] = p
+		}
+	}
+	return result, nil
+}
+
+// parseUnit разбирает запись вида "kg*m/s^2"
+func parseUnit(unit string) (dimension, error) {
+	dim := dimension{}
+	unit = strings.ReplaceAll(unit, " ", "")
+	if unit == "" || unit == "1" {
+		return dim, nil
+	}
+
+	sign := 1
+	start := 0
+	for i := 0; i <= len(unit); i++ {
+		if i < len(unit) && unit[i] != '*' && unit[i] != '/' {
+			continue
+		}
+
+		factor := unit[start:i]
+		if factor == "" {
+			return nil, fmt.Errorf("empty factor in '%s'", unit)
+		}
+
+		name, power := factor, 1
+		if idx := strings.Index(factor, "^"); idx >= 0 {
+			p, err := strconv.Atoi(factor[idx+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid power in '%s'", factor)
+			}
+			name, power = factor[:idx], p
+		}
+		if name != "1" {
+			dim[name] += sign * power
+			if dim[name] == 0 {
+				delete(dim, name)
+			}
+		}
+
+		sign = 1
+		if i < len(unit) && unit[i] == '/' {
+			sign = -1
+		}
+		start = i + 1
+	}
+
+	return dim, nil
+}
+
+// combine умножает (sign = 1) или делит (sign = -1) размерности
+func (d dimension) combine(other dimension, sign int) dimension {
+	result := dimension{}
+	for unit, power := range d {
+		result[unit] = power
+	}
+	for unit, power := range other {
+		result[unit] += sign * power
+		if result[unit] == 0 {
+			delete(result, unit)
+		}
+	}
+	return result
+}
+
+func (d dimension) equal(other dimension) bool {
+	if len(d) != len(other) {
+		return false
+	}
+	for unit, power := range d {
+		if other[unit] != power {
+			return false
+		}
+	}
+	return true
+}
+
+func (d dimension) String() string {
+	if len(d) == 0 {
+		return "dimensionless"
+	}
+
+	units := make([]string, 0, len(d))
+	for unit := range d {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+
+	parts := make([]string, 0, len(units))
+	for _, unit := range units {
+		if d[unit] == 1 {
+			parts = append(parts, unit)
+		} else {
+			parts = append(parts, fmt.Sprintf("%s^%d", unit, d[unit]))
+		}
+	}
+	return strings.Join(parts, "*")
+}
@@ -0,0 +1,37 @@
+package formula
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckDimensions(t *testing.T) {
+	units := map[string]string{"distance": "m", "time": "s", "speed": "m/s", "mass": "kg", "force": "kg*m/s^2"}
+
+	for _, formula := range []string{
+		"distance / time",
+		"distance / time + speed",
+		"mass * distance / time / time - force",
+		"2 * distance / time > speed",
+		"distance * 3",
+	} {
+		if err := CheckDimensions(mustParse(t, formula), units); err != nil {
+			t.Errorf("%s: %v", formula, err)
+		}
+	}
+
+	for _, formula := range []string{
+		"distance + time",
+		"speed - distance",
+		"distance / time > mass",
+		"distance + 1",
+	} {
+		if err := CheckDimensions(mustParse(t, formula), units); !errors.Is(err, ErrDimensionMismatch) {
+			t.Errorf("%s: error %v, want ErrDimensionMismatch", formula, err)
+		}
+	}
+
+	if err := CheckDimensions(mustParse(t, "a"), map[string]string{"a": "m^x"}); err == nil {
+		t.Error("malformed unit: expected error")
+	}
+}
@@ -1,13 +1,19 @@
 package formula
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 	"unicode"
+	"unicode/utf8"
 )
 
-// ValidationError представляет ошибку валидации
+// ValidationError представляет ошибку валидации. Position отсчитывается в
+// рунах от начала формулы, как позиции токенов лексера, и равна -1, если
+// ошибка относится ко всей формуле (пустая формула, незакрытые скобки).
 type ValidationError struct {
 	Message  string
 	Position int
@@ -21,34 +27,103 @@ func (e *ValidationError) Error() string {
 	return fmt.Sprintf("validation error: %s", e.Message)
 }
 
+// ValidationWarning представляет предупреждение валидации. Code позволяет
+// отфильтровать или локализовать предупреждения, Position равна -1, если
+// предупреждение относится ко всей формуле.
+type ValidationWarning struct {
+	Message  string
+	Position int
+	Code     string
+}
+
+// String возвращает текст предупреждения
+func (w ValidationWarning) String() string {
+	return w.Message
+}
+
 // ValidationResult содержит результат валидации
 type ValidationResult struct {
 	IsValid  bool
 	Errors   []ValidationError
-	Warnings []string
+	Warnings []ValidationWarning
 }
 
-// FormulaValidator валидирует формулы
+// WarningStrings возвращает тексты предупреждений, как до введения ValidationWarning
+func (r ValidationResult) WarningStrings() []string {
+	messages := make([]string, len(r.Warnings))
+	for i, warning := range r.Warnings {
+		messages[i] = warning.Message
+	}
+	return messages
+}
+
+// Регулярные выражения проверок компилируются один раз
+var (
+	operatorPattern = regexp.MustCompile(`[+\-*/%=!><]{3,}`)
+	russianPattern  = regexp.MustCompile(`[а-яё]`)
+	englishPattern  = regexp.MustCompile(`[a-z]`)
+	variablePattern = regexp.MustCompile(`[a-zA-Zа-яёА-ЯЁ_][a-zA-Zа-яёА-ЯЁ0-9_]*`)
+)
+
+// FormulaValidator валидирует формулы. Проверка только читает настройки
+// валидатора, поэтому один валидатор можно использовать из нескольких
+// горутин, пока его настройки не меняются.
 type FormulaValidator struct {
 	allowedOperators map[rune]bool
 	keywords         map[string]bool
+
+	// ExpectNumeric включает предупреждение, если формула целиком является
+	// сравнением (например, "salary = base"), а ожидается числовой результат
+	ExpectNumeric bool
+
+	// StrictLogic требует скобок при смешении AND и OR на одном уровне
+	// (ошибка AMBIGUOUS_LOGIC)
+	StrictLogic bool
+
+	// AllowNestedConditionInCondition разрешает условные выражения внутри
+	// условия другого IF, например IF(IF(a, b, c), d, e). При false такие
+	// формулы отклоняются с кодом NESTED_CONDITION_IN_CONDITION. По умолчанию true.
+	AllowNestedConditionInCondition bool
+
+	// KnownVariables - переменные, которые вызывающий код может передать при
+	// вычислении. Если задано, ссылки на другие переменные отклоняются с кодом
+	// UNKNOWN_VARIABLE. Встроенные константы (E, PI) известны всегда.
+	// nil отключает проверку.
+	KnownVariables map[string]bool
+
+	// variableTypes - объявленные типы переменных для проверки типов,
+	// см. SetVariableTypes
+	variableTypes map[string]ValueType
+}
+
+// SetVariableTypes включает проверку типов (см. TypeCheck) с объявленными
+// типами переменных: например, логическая переменная в арифметике ("active + 1")
+// или числовая в AND/OR дает предупреждение TYPE_MISMATCH. Типы можно получить
+// из объявлений "price:number, active:bool" функцией ParseVariableTypes.
+// nil отключает проверку.
+func (v *FormulaValidator) SetVariableTypes(types map[string]ValueType) {
+	v.variableTypes = types
 }
 
 // NewFormulaValidator создает новый валидатор
 func NewFormulaValidator() *FormulaValidator {
 	return &FormulaValidator{
+		AllowNestedConditionInCondition: true,
 		allowedOperators: map[rune]bool{
-			'+': true, '-': true, '*': true, '/': true,
+			'+': true, '-': true, '*': true, '/': true, '%': true, '^': true,
 			'=': true, '!': true, '>': true, '<': true,
 			'(': true, ')': true, ',': true, '.': true,
+			'|': true,
 		},
 		keywords: map[string]bool{
 			// Русские ключевые слова
-			"ЕСЛИ": true, "ИЛИ": true, "И": true,
+			"ЕСЛИ": true, "ИЛИ": true, "И": true, "НЕ": true,
 			"ТОГДА": true, "ИНАЧЕ": true,
+			"ИСТИНА": true, "ЛОЖЬ": true,
 			// Английские ключевые слова
 			"IF": true, "THEN": true, "ELSE": true,
-			"OR": true, "AND": true,
+			"OR": true, "AND": true, "NOT": true,
+			"TRUE": true, "FALSE": true,
 		},
 	}
 }
@@ -58,9 +133,13 @@ func (v *FormulaValidator) ValidateFormula(formula string) ValidationResult {
 	result := ValidationResult{
 		IsValid:  true,
 		Errors:   []ValidationError{},
-		Warnings: []string{},
+		Warnings: []ValidationWarning{},
 	}
 
+	// Имена в обратных кавычках могут содержать любые символы,
+	// поэтому посимвольные проверки выполняются по замаскированной формуле
+	masked := maskQuotedIdentifiers(formula)
+
 	// Базовые проверки
 	if err := v.validateBasicStructure(formula); err != nil {
 		result.Errors = append(result.Errors, *err)
@@ -68,25 +147,31 @@ func (v *FormulaValidator) ValidateFormula(formula string) ValidationResult {
 	}
 
 	// Проверка недопустимых символов
-	if errors := v.validateCharacters(formula); len(errors) > 0 {
+	if errors := v.validateCharacters(masked); len(errors) > 0 {
 		result.Errors = append(result.Errors, errors...)
 		result.IsValid = false
 	}
 
 	// Проверка использования кириллицы
-	if errors := v.validateCyrillicUsage(formula); len(errors) > 0 {
+	if errors := v.validateCyrillicUsage(masked); len(errors) > 0 {
 		result.Errors = append(result.Errors, errors...)
 		result.IsValid = false
 	}
 
 	// Проверка скобок
-	if err := v.validateParentheses(formula); err != nil {
+	if err := v.validateParentheses(masked); err != nil {
 		result.Errors = append(result.Errors, *err)
 		result.IsValid = false
 	}
 
 	// Проверка операторов
-	if errors := v.validateOperators(formula); len(errors) > 0 {
+	if errors := v.validateOperators(masked); len(errors) > 0 {
+		result.Errors = append(result.Errors, errors...)
+		result.IsValid = false
+	}
+
+	// Числа с несколькими точками
+	if errors := v.validateNumbers(formula); len(errors) > 0 {
 		result.Errors = append(result.Errors, errors...)
 		result.IsValid = false
 	}
@@ -99,28 +184,78 @@ func (v *FormulaValidator) ValidateFormula(formula string) ValidationResult {
 		}
 	}
 
+	// Условие внутри условия
+	if result.IsValid && !v.AllowNestedConditionInCondition {
+		if errors := v.validateNestedConditions(formula); len(errors) > 0 {
+			result.Errors = append(result.Errors, errors...)
+			result.IsValid = false
+		}
+	}
+
+	// Неизвестные переменные
+	if result.IsValid && v.KnownVariables != nil {
+		if errors := v.validateVariables(formula); len(errors) > 0 {
+			result.Errors = append(result.Errors, errors...)
+			result.IsValid = false
+		}
+	}
+
 	// Предупреждения
-	warnings := v.generateWarnings(formula)
+	warnings := v.generateWarnings(masked)
 	result.Warnings = append(result.Warnings, warnings...)
 
+	// Несоответствие типов
+	if result.IsValid && v.variableTypes != nil {
+		result.Warnings = append(result.Warnings, v.checkTypes(formula)...)
+	}
+
+	// Сравнение там, где ожидается число
+	if result.IsValid && v.ExpectNumeric {
+		if warning := v.checkNumericResult(formula); warning != nil {
+			result.Warnings = append(result.Warnings, *warning)
+		}
+	}
+
 	return result
 }
 
+// maskQuotedIdentifiers заменяет содержимое имен в обратных кавычках
+// символом '_', сохраняя длину формулы в рунах
+func maskQuotedIdentifiers(formula string) string {
+	runes := []rune(formula)
+	quoted := false
+
+	for i, r := range runes {
+		if r == '`' {
+			quoted = !quoted
+			runes[i] = '_'
+			continue
+		}
+		if quoted {
+			runes[i] = '_'
+		}
+	}
+
+	return string(runes)
+}
+
 // validateBasicStructure проверяет базовую структуру формулы
 func (v *FormulaValidator) validateBasicStructure(formula string) *ValidationError {
 	trimmed := strings.TrimSpace(formula)
 
 	if len(trimmed) == 0 {
 		return &ValidationError{
-			Message: "формула не может быть пустой",
-			Code:    "EMPTY_FORMULA",
+			Message:  "формула не может быть пустой",
+			Position: -1,
+			Code:     "EMPTY_FORMULA",
 		}
 	}
 
 	if len(trimmed) > 1000 {
 		return &ValidationError{
-			Message: "формула слишком длинная (максимум 1000 символов)",
-			Code:    "FORMULA_TOO_LONG",
+			Message:  "формула слишком длинная (максимум 1000 символов)",
+			Position: -1,
+			Code:     "FORMULA_TOO_LONG",
 		}
 	}
 
@@ -258,8 +393,9 @@ func (v *FormulaValidator) validateParentheses(formula string) *ValidationError
 
 	if stack > 0 {
 		return &ValidationError{
-			Message: fmt.Sprintf("не хватает %d закрывающих скобок", stack),
-			Code:    "MISSING_CLOSING_PAREN",
+			Message:  fmt.Sprintf("не хватает %d закрывающих скобок", stack),
+			Position: -1,
+			Code:     "MISSING_CLOSING_PAREN",
 		}
 	}
 
@@ -271,25 +407,28 @@ func (v *FormulaValidator) validateOperators(formula string) []ValidationError {
 	var errors []ValidationError
 
 	// Проверка на подряд идущие операторы
-	operatorPattern := regexp.MustCompile(`[+\-*/=!><]{3,}`)
-	matches := operatorPattern.FindAllStringIndex(formula, -1)
+	// "**" - один оператор степени, "//" - целочисленное деление, поэтому
+	// "2**-3" и "7//-2" допустимы
+	normalized := strings.NewReplacer("**", "^ ", "//", "^ ").Replace(formula)
+	matches := operatorPattern.FindAllStringIndex(normalized, -1)
 
+	// Позиции считаются в рунах, как и в остальных проверках
 	for _, match := range matches {
 		errors = append(errors, ValidationError{
 			Message:  "недопустимая последовательность операторов",
-			Position: match[0],
+			Position: utf8.RuneCountInString(formula[:match[0]]),
 			Code:     "INVALID_OPERATOR_SEQUENCE",
 		})
 	}
 
 	// Проверка на операторы в начале/конце (кроме унарного минуса)
-	trimmed := strings.TrimSpace(formula)
+	trimmed := strings.TrimRightFunc(formula, unicode.IsSpace)
 	if len(trimmed) > 0 {
-		lastChar := rune(trimmed[len(trimmed)-1])
-		if strings.ContainsRune("*/=!><", lastChar) {
+		lastChar, _ := utf8.DecodeLastRuneInString(trimmed)
+		if strings.ContainsRune("*/%^=!><", lastChar) {
 			errors = append(errors, ValidationError{
 				Message:  "формула не может заканчиваться оператором",
-				Position: len(formula) - 1,
+				Position: utf8.RuneCountInString(trimmed) - 1,
 				Code:     "FORMULA_ENDS_WITH_OPERATOR",
 			})
 		}
@@ -298,6 +437,23 @@ func (v *FormulaValidator) validateOperators(formula string) []ValidationError {
 	return errors
 }
 
+// validateNumbers находит числа с несколькими точками, например "1.2.3" или "..5"
+func (v *FormulaValidator) validateNumbers(formula string) []ValidationError {
+	var errors []ValidationError
+
+	for _, token := range lexAll(formula) {
+		if isMalformedNumber(token) {
+			errors = append(errors, ValidationError{
+				Message:  fmt.Sprintf("некорректное число '%s'", token.Value),
+				Position: token.Pos,
+				Code:     "MALFORMED_NUMBER",
+			})
+		}
+	}
+
+	return errors
+}
+
 // validateSyntax проверяет синтаксис через токенизацию
 func (v *FormulaValidator) validateSyntax(formula string) *ValidationError {
 	lexer := NewLexer(formula)
@@ -320,12 +476,24 @@ func (v *FormulaValidator) validateSyntax(formula string) *ValidationError {
 	}
 
 	// Пытаемся распарсить формулу
-	parser := NewParser(formula)
+	parser := NewParserWithOptions(formula, ParserOptions{StrictLogic: v.StrictLogic})
 	_, err := parser.Parse()
+	if errors.Is(err, ErrAmbiguousLogic) {
+		return &ValidationError{
+			Message:  "смешение AND и OR без скобок, используйте скобки, например A OR (B AND C)",
+			Position: -1,
+			Code:     "AMBIGUOUS_LOGIC",
+		}
+	}
 	if err != nil {
+		position, ok := errorPosition(err)
+		if !ok {
+			position = -1
+		}
 		return &ValidationError{
-			Message: fmt.Sprintf("ошибка синтаксиса: %v", err),
-			Code:    "SYNTAX_ERROR",
+			Message:  fmt.Sprintf("ошибка синтаксиса: %v", err),
+			Position: position,
+			Code:     "SYNTAX_ERROR",
 		}
 	}
 
@@ -333,46 +501,236 @@ func (v *FormulaValidator) validateSyntax(formula string) *ValidationError {
 }
 
 // generateWarnings генерирует предупреждения
-func (v *FormulaValidator) generateWarnings(formula string) []string {
-	var warnings []string
+func (v *FormulaValidator) generateWarnings(formula string) []ValidationWarning {
+	var warnings []ValidationWarning
 
 	// Предупреждение о смешении языков
-	hasRussian := regexp.MustCompile(`[а-яё]`).MatchString(strings.ToLower(formula))
-	hasEnglish := regexp.MustCompile(`[a-z]`).MatchString(strings.ToLower(formula))
+	hasRussian := russianPattern.MatchString(strings.ToLower(formula))
+	hasEnglish := englishPattern.MatchString(strings.ToLower(formula))
 
 	if hasRussian && hasEnglish {
-		warnings = append(warnings, "формула содержит смешение русских и английских ключевых слов")
+		warnings = append(warnings, ValidationWarning{
+			Message:  "формула содержит смешение русских и английских ключевых слов",
+			Position: -1,
+			Code:     "MIXED_LANGUAGES",
+		})
 	}
 
 	// Предупреждение о сложности
 	if strings.Count(formula, "(") > 5 {
-		warnings = append(warnings, "формула может быть слишком сложной для понимания")
+		warnings = append(warnings, ValidationWarning{
+			Message:  "формула может быть слишком сложной для понимания",
+			Position: -1,
+			Code:     "COMPLEX_FORMULA",
+		})
 	}
 
 	// Предупреждение о длинных именах переменных
-	variablePattern := regexp.MustCompile(`[a-zA-Zа-яёА-ЯЁ_][a-zA-Zа-яёА-ЯЁ0-9_]*`)
-	variables := variablePattern.FindAllString(formula, -1)
+	matches := variablePattern.FindAllStringIndex(formula, -1)
 
-	for _, variable := range variables {
+	for _, match := range matches {
+		variable := formula[match[0]:match[1]]
 		if !v.keywords[strings.ToUpper(variable)] && len(variable) > 20 {
-			warnings = append(warnings, fmt.Sprintf("переменная '%s' имеет очень длинное имя", variable))
+			warnings = append(warnings, ValidationWarning{
+				Message:  fmt.Sprintf("переменная '%s' имеет очень длинное имя", variable),
+				Position: utf8.RuneCountInString(formula[:match[0]]),
+				Code:     "LONG_VARIABLE_NAME",
+			})
 		}
 	}
 
 	return warnings
 }
 
+// validateNestedConditions находит условные выражения внутри условий других IF
+func (v *FormulaValidator) validateNestedConditions(formula string) []ValidationError {
+	parser := NewParserWithOptions(formula, ParserOptions{StrictLogic: v.StrictLogic})
+	node, err := parser.Parse()
+	if err != nil {
+		return nil
+	}
+
+	var errors []ValidationError
+	findNestedConditions(node, false, func(nested *ConditionalNode) {
+		position := -1
+		if span, ok := parser.Span(nested); ok {
+			position = span.Start
+		}
+		errors = append(errors, ValidationError{
+			Message:  "условное выражение внутри условия другого IF затрудняет чтение формулы",
+			Position: position,
+			Code:     "NESTED_CONDITION_IN_CONDITION",
+		})
+	})
+
+	return errors
+}
+
+// findNestedConditions за один обход вызывает report для каждого условного
+// выражения, находящегося внутри условия другого IF; inCondition означает,
+// что node уже находится внутри такого условия
+func findNestedConditions(node ASTNode, inCondition bool, report func(*ConditionalNode)) {
+	if conditional, ok := node.(*ConditionalNode); ok {
+		if inCondition {
+			report(conditional)
+		}
+		findNestedConditions(conditional.Condition, true, report)
+		findNestedConditions(conditional.Then, inCondition, report)
+		if conditional.Else != nil {
+			findNestedConditions(conditional.Else, inCondition, report)
+		}
+		return
+	}
+	for _, child := range children(node) {
+		findNestedConditions(child, inCondition, report)
+	}
+}
+
+// validateVariables находит переменные, которых нет в KnownVariables.
+// Каждая неизвестная переменная сообщается один раз, с позицией первого вхождения.
+func (v *FormulaValidator) validateVariables(formula string) []ValidationError {
+	parser := NewParserWithOptions(formula, ParserOptions{StrictLogic: v.StrictLogic})
+	node, err := parser.Parse()
+	if err != nil {
+		return nil
+	}
+
+	var errors []ValidationError
+	reported := make(map[string]bool)
+	collectNames(node, func(n ASTNode) {
+		variable, ok := n.(*VariableNode)
+		if !ok || v.KnownVariables[variable.Name] || reported[variable.Name] {
+			return
+		}
+		if _, constant := constants[variable.Name]; constant {
+			return
+		}
+		reported[variable.Name] = true
+
+		position := -1
+		if span, ok := parser.Span(variable); ok {
+			position = span.Start
+		}
+		errors = append(errors, ValidationError{
+			Message:  fmt.Sprintf("неизвестная переменная '%s'", variable.Name),
+			Position: position,
+			Code:     "UNKNOWN_VARIABLE",
+		})
+	})
+
+	return errors
+}
+
+// checkTypes проверяет типы с учетом объявленных типов переменных
+func (v *FormulaValidator) checkTypes(formula string) []ValidationWarning {
+	parser := NewParserWithOptions(formula, ParserOptions{StrictLogic: v.StrictLogic})
+	node, err := parser.Parse()
+	if err != nil {
+		return nil
+	}
+
+	checker := &typeChecker{variables: v.variableTypes}
+	checker.run(node)
+
+	warnings := make([]ValidationWarning, len(checker.errs))
+	for i, err := range checker.errs {
+		position := -1
+		if span, ok := parser.Span(checker.nodes[i]); ok {
+			position = span.Start
+		}
+		warnings[i] = ValidationWarning{
+			Message:  fmt.Sprintf("ошибка типов: %v", err),
+			Position: position,
+			Code:     "TYPE_MISMATCH",
+		}
+	}
+	return warnings
+}
+
+// checkNumericResult предупреждает, если формула целиком является сравнением
+func (v *FormulaValidator) checkNumericResult(formula string) *ValidationWarning {
+	node, err := NewParser(formula).Parse()
+	if err != nil {
+		return nil
+	}
+
+	comparison, ok := node.(*ComparisonNode)
+	if !ok {
+		return nil
+	}
+
+	warning := &ValidationWarning{
+		Message:  "формула является сравнением и вернет 0 или 1 вместо числа",
+		Position: -1,
+		Code:     "COMPARISON_RESULT",
+	}
+	if comparison.Operator == "=" {
+		warning.Message += "; возможно, '=' использован как присваивание"
+	}
+	return warning
+}
+
+var (
+	defaultValidator     *FormulaValidator
+	defaultValidatorOnce sync.Once
+)
+
+// sharedValidator возвращает общий валидатор с настройками по умолчанию для
+// функций пакета. Его настройки не меняются, поэтому он безопасен для
+// одновременного использования.
+func sharedValidator() *FormulaValidator {
+	defaultValidatorOnce.Do(func() {
+		defaultValidator = NewFormulaValidator()
+	})
+	return defaultValidator
+}
+
 // QuickValidate быстрая валидация для простых случаев
 func QuickValidate(formula string) bool {
-	validator := NewFormulaValidator()
-	result := validator.ValidateFormula(formula)
+	result := sharedValidator().ValidateFormula(formula)
 	return result.IsValid
 }
 
+// ValidateMany валидирует набор формул одним валидатором и возвращает
+// результаты в порядке формул. Валидатор не меняет состояние при проверке,
+// поэтому формулы проверяются параллельно, по одной горутине на процессор.
+func ValidateMany(formulas []string) []ValidationResult {
+	return sharedValidator().ValidateMany(formulas)
+}
+
+// ValidateMany валидирует набор формул параллельно и возвращает результаты
+// в порядке формул. Настройки валидатора нельзя менять во время проверки.
+func (v *FormulaValidator) ValidateMany(formulas []string) []ValidationResult {
+	results := make([]ValidationResult, len(formulas))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(formulas) {
+		workers = len(formulas)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = v.ValidateFormula(formulas[i])
+			}
+		}()
+	}
+	for i := range formulas {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
 // ValidateAndGetErrors валидация с возвратом всех ошибок
 func ValidateAndGetErrors(formula string) (bool, []string) {
-	validator := NewFormulaValidator()
-	result := validator.ValidateFormula(formula)
+	result := sharedValidator().ValidateFormula(formula)
 
 	var errorMessages []string
 	for _, err := range result.Errors {
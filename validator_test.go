@@ -0,0 +1,350 @@
+package formula
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// codes возвращает коды ошибок результата проверки
+func codes(result ValidationResult) []string {
+	var codes []string
+	for _, e := range result.Errors {
+		codes = append(codes, e.Code)
+	}
+	return codes
+}
+
+func TestValidateNestedConditions(t *testing.T) {
+	v := NewFormulaValidator()
+	v.AllowNestedConditionInCondition = false
+
+	result := v.ValidateFormula("IF(IF(IF(a>1,1,0),1,0),2,3)")
+	var positions []int
+	for _, e := range result.Errors {
+		if e.Code == "NESTED_CONDITION_IN_CONDITION" {
+			positions = append(positions, e.Position)
+		}
+	}
+	if len(positions) != 2 || positions[0] != 3 || positions[1] != 6 {
+		t.Errorf("nested condition positions = %v, want [3 6]", positions)
+	}
+
+	if result := v.ValidateFormula("IF(a > 1, IF(b > 1, 1, 2), 3)"); !result.IsValid {
+		t.Errorf("IF in a branch rejected: %v", codes(result))
+	}
+
+	v.AllowNestedConditionInCondition = true
+	if result := v.ValidateFormula("IF(IF(a > 1, 1, 0), 2, 3)"); !result.IsValid {
+		t.Errorf("nested condition rejected by default: %v", codes(result))
+	}
+}
+
+// warningCodes возвращает коды предупреждений результата проверки
+func warningCodes(result ValidationResult) []string {
+	var codes []string
+	for _, w := range result.Warnings {
+		codes = append(codes, w.Code)
+	}
+	return codes
+}
+
+func hasCode(codes []string, code string) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidateExpectNumeric(t *testing.T) {
+	v := NewFormulaValidator()
+	v.ExpectNumeric = true
+
+	for _, formula := range []string{"salary = base", "a > b"} {
+		if result := v.ValidateFormula(formula); !hasCode(warningCodes(result), "COMPARISON_RESULT") {
+			t.Errorf("%s: warnings %v, want COMPARISON_RESULT", formula, warningCodes(result))
+		}
+	}
+	for _, formula := range []string{"salary + base", "IF(a > b, 1, 2)", "(a > b) * 10"} {
+		if result := v.ValidateFormula(formula); hasCode(warningCodes(result), "COMPARISON_RESULT") {
+			t.Errorf("%s: unexpected COMPARISON_RESULT warning", formula)
+		}
+	}
+
+	v.ExpectNumeric = false
+	if result := v.ValidateFormula("salary = base"); hasCode(warningCodes(result), "COMPARISON_RESULT") {
+		t.Error("COMPARISON_RESULT reported without ExpectNumeric")
+	}
+}
+
+func TestValidationWarningCodes(t *testing.T) {
+	longName := "очень_длинное_имя_переменной_zz"
+	tests := []struct {
+		formula  string
+		code     string
+		position int
+	}{
+		{"ЕСЛИ a > 1 THEN 2 ИНАЧЕ 3", "MIXED_LANGUAGES", -1},
+		{"((((((a))))))", "COMPLEX_FORMULA", -1},
+		{"1 + " + longName, "LONG_VARIABLE_NAME", 4},
+	}
+	v := NewFormulaValidator()
+	for _, tt := range tests {
+		result := v.ValidateFormula(tt.formula)
+		var found *ValidationWarning
+		for i := range result.Warnings {
+			if result.Warnings[i].Code == tt.code {
+				found = &result.Warnings[i]
+			}
+		}
+		if found == nil {
+			t.Errorf("%s: warnings %v, want %s", tt.formula, warningCodes(result), tt.code)
+			continue
+		}
+		if found.Position != tt.position {
+			t.Errorf("%s: %s at position %d, want %d", tt.formula, tt.code, found.Position, tt.position)
+		}
+		if messages := result.WarningStrings(); len(messages) != len(result.Warnings) || messages[0] != result.Warnings[0].String() {
+			t.Errorf("%s: WarningStrings = %v", tt.formula, messages)
+		}
+	}
+
+	if result := v.ValidateFormula("a + b"); len(result.Warnings) != 0 {
+		t.Errorf("a + b: unexpected warnings %v", warningCodes(result))
+	}
+}
+
+// Результаты возвращаются в порядке формул и совпадают с проверкой по одной
+func TestValidateMany(t *testing.T) {
+	formulas := []string{
+		"a + b",
+		"a + * b",
+		"IF(score >= 90, 5, 4)",
+		"(a + b",
+		"",
+		"цена $ 2",
+		gradeFormula,
+		"max(a, b) * 2",
+	}
+	for i := 0; i < 5; i++ {
+		formulas = append(formulas, formulas...)
+	}
+
+	results := ValidateMany(formulas)
+	if len(results) != len(formulas) {
+		t.Fatalf("ValidateMany returned %d results for %d formulas", len(results), len(formulas))
+	}
+	v := NewFormulaValidator()
+	for i, formula := range formulas {
+		want := v.ValidateFormula(formula)
+		if !reflect.DeepEqual(results[i], want) {
+			t.Errorf("result %d for %q = %+v, want %+v", i, formula, results[i], want)
+		}
+	}
+	if !results[0].IsValid || results[1].IsValid || !results[2].IsValid || results[3].IsValid {
+		t.Errorf("unexpected validity: %v %v %v %v", results[0].IsValid, results[1].IsValid, results[2].IsValid, results[3].IsValid)
+	}
+
+	if got := ValidateMany(nil); len(got) != 0 {
+		t.Errorf("ValidateMany(nil) = %v, want empty", got)
+	}
+}
+
+// benchmarkFormulas - пакет формул для сравнения ValidateMany с проверкой по одной
+func benchmarkFormulas() []string {
+	formulas := make([]string, 0, 200)
+	for i := 0; i < 50; i++ {
+		formulas = append(formulas, gradeFormula, "a + * b", "max(a, b) * (c - d) / 2", "(a + b")
+	}
+	return formulas
+}
+
+func BenchmarkValidateMany(b *testing.B) {
+	formulas := benchmarkFormulas()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ValidateMany(formulas)
+	}
+}
+
+func BenchmarkValidatePerFormula(b *testing.B) {
+	formulas := benchmarkFormulas()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, formula := range formulas {
+			NewFormulaValidator().ValidateFormula(formula)
+		}
+	}
+}
+
+// Формулы из examples/validation с набором известных переменных {A, B, C, D}
+func TestValidateKnownVariables(t *testing.T) {
+	v := NewFormulaValidator()
+	v.KnownVariables = map[string]bool{"A": true, "B": true, "C": true, "D": true}
+
+	for _, formula := range []string{
+		"A + B", "A * B - C", "(A + B) * C", "-A + B",
+		"ЕСЛИ A > B ТОГДА C ИНАЧЕ D", "IF A > B THEN C ELSE D",
+		"A = 5 ИЛИ B = 10", "A >= B AND C <= D", "A * PI + E",
+	} {
+		if result := v.ValidateFormula(formula); !result.IsValid {
+			t.Errorf("%s: errors %v, want valid", formula, codes(result))
+		}
+	}
+
+	result := v.ValidateFormula("asdasdasdas")
+	if result.IsValid || len(result.Errors) != 1 || result.Errors[0].Code != "UNKNOWN_VARIABLE" || result.Errors[0].Position != 0 {
+		t.Errorf("asdasdasdas: errors %+v, want one UNKNOWN_VARIABLE at position 0", result.Errors)
+	}
+
+	// Каждая неизвестная переменная сообщается один раз, с первой позицией
+	result = v.ValidateFormula("A + x * y - x")
+	var unknown []ValidationError
+	for _, err := range result.Errors {
+		if err.Code == "UNKNOWN_VARIABLE" {
+			unknown = append(unknown, err)
+		}
+	}
+	if len(unknown) != 2 || unknown[0].Position != 4 || unknown[1].Position != 8 {
+		t.Errorf("A + x * y - x: unknown variables %+v, want x at 4 and y at 8", unknown)
+	}
+
+	// Без набора переменные не проверяются
+	if result := NewFormulaValidator().ValidateFormula("asdasdasdas"); !result.IsValid {
+		t.Errorf("asdasdasdas without KnownVariables: errors %v, want valid", codes(result))
+	}
+}
+
+// Логическая переменная в арифметике дает предупреждение, только если типы объявлены
+func TestValidateVariableTypes(t *testing.T) {
+	types, err := ParseVariableTypes("price:number, active:bool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := NewFormulaValidator()
+	if result := v.ValidateFormula("active + 1"); hasCode(warningCodes(result), "TYPE_MISMATCH") {
+		t.Errorf("type warning without declared types: %v", result.Warnings)
+	}
+
+	v.SetVariableTypes(types)
+	result := v.ValidateFormula("price * 2 + active")
+	var mismatch *ValidationWarning
+	for i, w := range result.Warnings {
+		if w.Code == "TYPE_MISMATCH" {
+			mismatch = &result.Warnings[i]
+		}
+	}
+	if mismatch == nil {
+		t.Fatalf("bool variable in arithmetic: warnings %v, want TYPE_MISMATCH", warningCodes(result))
+	}
+	if mismatch.Position != 12 {
+		t.Errorf("TYPE_MISMATCH position = %d, want 12", mismatch.Position)
+	}
+	if !result.IsValid {
+		t.Errorf("type mismatch must be a warning, got errors %v", codes(result))
+	}
+
+	if result := v.ValidateFormula("active AND price > 10"); hasCode(warningCodes(result), "TYPE_MISMATCH") {
+		t.Errorf("well-typed formula warned: %v", result.Warnings)
+	}
+	if result := v.ValidateFormula("price OR active"); !hasCode(warningCodes(result), "TYPE_MISMATCH") {
+		t.Errorf("number variable in OR: warnings %v, want TYPE_MISMATCH", warningCodes(result))
+	}
+}
+
+// Числа с несколькими точками отмечаются кодом MALFORMED_NUMBER с позицией
+// начала числа
+func TestValidateMalformedNumber(t *testing.T) {
+	tests := []struct {
+		formula  string
+		position int
+	}{
+		{"1.2.3", 0},
+		{"..5", 0},
+		{"x + 1.2.3", 4},
+		{"x * ..5", 4},
+		{"ЦЕНА + 1..2", 7},
+	}
+	v := NewFormulaValidator()
+	for _, tt := range tests {
+		result := v.ValidateFormula(tt.formula)
+		if result.IsValid {
+			t.Errorf("%q: expected invalid", tt.formula)
+		}
+		var found bool
+		for _, e := range result.Errors {
+			if e.Code != "MALFORMED_NUMBER" {
+				continue
+			}
+			found = true
+			if e.Position != tt.position {
+				t.Errorf("%q: MALFORMED_NUMBER position = %d, want %d", tt.formula, e.Position, tt.position)
+			}
+		}
+		if !found {
+			t.Errorf("%q: errors %v, want MALFORMED_NUMBER", tt.formula, codes(result))
+		}
+	}
+
+	for _, formula := range []string{"1.5 + .5", "x + 2.", "10"} {
+		if result := v.ValidateFormula(formula); hasCode(codes(result), "MALFORMED_NUMBER") {
+			t.Errorf("%q: unexpected MALFORMED_NUMBER", formula)
+		}
+	}
+}
+
+// Общий валидатор QuickValidate не создает наборы функций и ключевых слов
+// при каждом вызове
+func BenchmarkValidateNewValidator(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if !NewFormulaValidator().ValidateFormula(benchmarkFormula).IsValid {
+			b.Fatal("benchmark formula is invalid")
+		}
+	}
+}
+
+func BenchmarkValidateSharedValidator(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if !sharedValidator().ValidateFormula(benchmarkFormula).IsValid {
+			b.Fatal("benchmark formula is invalid")
+		}
+	}
+}
+
+// Позиции считаются в рунах; ошибки без позиции имеют Position -1, и
+// Error выводит их без позиции
+func TestValidationErrorPositions(t *testing.T) {
+	v := NewFormulaValidator()
+	tests := []struct {
+		formula  string
+		code     string
+		position int
+	}{
+		{"", "EMPTY_FORMULA", -1},
+		{"(a + b", "MISSING_CLOSING_PAREN", -1},
+		{"ЦЕНА +*- 2", "INVALID_OPERATOR_SEQUENCE", 5},
+		{"ЦЕНА * 2 *", "FORMULA_ENDS_WITH_OPERATOR", 9},
+	}
+	for _, tt := range tests {
+		result := v.ValidateFormula(tt.formula)
+		var found bool
+		for _, e := range result.Errors {
+			if e.Code != tt.code {
+				continue
+			}
+			found = true
+			if e.Position != tt.position {
+				t.Errorf("%q: %s position = %d, want %d", tt.formula, tt.code, e.Position, tt.position)
+			}
+			if tt.position < 0 && strings.Contains(e.Error(), "position") {
+				t.Errorf("%q: Error() = %q, want no position", tt.formula, e.Error())
+			}
+		}
+		if !found {
+			t.Errorf("%q: errors %v, want %s", tt.formula, codes(result), tt.code)
+		}
+	}
+}
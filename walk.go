@@ -0,0 +1,267 @@
+package formula
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Walk обходит дерево в прямом порядке: сначала узел, затем его дочерние узлы
+// слева направо в порядке вычисления - Left и Right у операций, сравнений и
+// логических выражений, Condition, Then и Else у условий, Subject, затем
+// пары Cases[i] и Values[i] и Default у SWITCH, Operand у унарных
+// операций, Args у функций. Отсутствующие дочерние узлы (например, Else без
+// ветки ELSE) пропускаются. Если fn возвращает false, дочерние узлы текущего
+// узла не посещаются, а обход продолжается с его соседей.
+func Walk(node ASTNode, fn func(ASTNode) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+	for _, child := range children(node) {
+		Walk(child, fn)
+	}
+}
+
+// children возвращает непосредственные дочерние узлы в порядке вычисления
+func children(node ASTNode) []ASTNode {
+	var result []ASTNode
+	add := func(nodes ...ASTNode) {
+		for _, n := range nodes {
+			if n != nil {
+				result = append(result, n)
+			}
+		}
+	}
+
+	switch n := node.(type) {
+	case *OperationNode:
+		add(n.Left, n.Right)
+	case *ComparisonNode:
+		add(n.Left, n.Right)
+	case *LogicalNode:
+		add(n.Left, n.Right)
+	case *ConditionalNode:
+		add(n.Condition, n.Then, n.Else)
+	case *SwitchNode:
+		add(n.Subject)
+		for i := range n.Cases {
+			add(n.Cases[i], n.Values[i])
+		}
+		add(n.Default)
+	case *UnaryNode:
+		add(n.Operand)
+	case *FunctionNode:
+		add(n.Args...)
+	}
+
+	return result
+}
+
+// mapChildren возвращает копию узла, в которой каждый дочерний узел заменен
+// результатом fn. Листья возвращаются без копирования.
+func mapChildren(node ASTNode, fn func(ASTNode) ASTNode) ASTNode {
+	mapped := func(child ASTNode) ASTNode {
+		if child == nil {
+			return nil
+		}
+		return fn(child)
+	}
+
+	switch n := node.(type) {
+	case *OperationNode:
+		c := *n
+		c.Left, c.Right = mapped(n.Left), mapped(n.Right)
+		return &c
+	case *ComparisonNode:
+		c := *n
+		c.Left, c.Right = mapped(n.Left), mapped(n.Right)
+		return &c
+	case *LogicalNode:
+		c := *n
+		c.Left, c.Right = mapped(n.Left), mapped(n.Right)
+		return &c
+	case *ConditionalNode:
+		c := *n
+		c.Condition, c.Then, c.Else = mapped(n.Condition), mapped(n.Then), mapped(n.Else)
+		return &c
+	case *SwitchNode:
+		c := *n
+		c.Subject = mapped(n.Subject)
+		c.Cases = make([]ASTNode, len(n.Cases))
+		c.Values = make([]ASTNode, len(n.Values))
+		for i := range n.Cases {
+			c.Cases[i], c.Values[i] = mapped(n.Cases[i]), mapped(n.Values[i])
+		}
+		c.Default = mapped(n.Default)
+		return &c
+	case *UnaryNode:
+		c := *n
+		c.Operand = mapped(n.Operand)
+		return &c
+	case *FunctionNode:
+		c := *n
+		c.Args = make([]ASTNode, len(n.Args))
+		for i, arg := range n.Args {
+			c.Args[i] = mapped(arg)
+		}
+		return &c
+	default:
+		return node
+	}
+}
+
+// ReplaceAt возвращает копию дерева, в которой узел по пути path заменен на
+// replacement. Путь состоит из имен дочерних полей: "left", "right",
+// "operand", "condition", "then", "else", "args[i]" для аргументов функций
+// и "subject", "cases[i]", "values[i]", "default" для SWITCH,
+// например ["else", "then"]. Последний сегмент может указывать на пустое
+// поле: ["else"] добавляет ветку ELSE условию без нее. Исходное дерево не
+// изменяется: копируются только узлы на пути, остальные поддеревья
+// используются совместно. Пустой replacement - ошибка: для удаления ветки
+// ReplaceAt не предназначен.
+func ReplaceAt(root ASTNode, path []string, replacement ASTNode) (ASTNode, error) {
+	if replacement == nil {
+		return nil, fmt.Errorf("replacement node is nil")
+	}
+	if len(path) == 0 {
+		return replacement, nil
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("invalid path segment '%s': node is nil", path[0])
+	}
+
+	var child ASTNode
+	if len(path) > 1 {
+		var err error
+		if child, err = childAt(root, path[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	newChild, err := ReplaceAt(child, path[1:], replacement)
+	if err != nil {
+		return nil, err
+	}
+
+	return withChild(root, path[0], newChild)
+}
+
+// childAt возвращает дочерний узел по имени поля
+func childAt(node ASTNode, segment string) (ASTNode, error) {
+	field := childField(node, segment)
+	if field == nil || *field == nil {
+		return nil, fmt.Errorf("invalid path segment '%s' for %s node", segment, node.GetType())
+	}
+	return *field, nil
+}
+
+// withChild возвращает копию узла с замененным дочерним узлом
+func withChild(node ASTNode, segment string, child ASTNode) (ASTNode, error) {
+	copied := shallowCopy(node)
+	field := childField(copied, segment)
+	if field == nil {
+		return nil, fmt.Errorf("invalid path segment '%s' for %s node", segment, node.GetType())
+	}
+	*field = child
+	return copied, nil
+}
+
+// childField возвращает указатель на поле дочернего узла segment или nil,
+// если у узла нет такого поля
+func childField(node ASTNode, segment string) *ASTNode {
+	switch n := node.(type) {
+	case *OperationNode:
+		return binaryField(&n.Left, &n.Right, segment)
+	case *ComparisonNode:
+		return binaryField(&n.Left, &n.Right, segment)
+	case *LogicalNode:
+		return binaryField(&n.Left, &n.Right, segment)
+	case *ConditionalNode:
+		switch segment {
+		case "condition":
+			return &n.Condition
+		case "then":
+			return &n.Then
+		case "else":
+			return &n.Else
+		}
+	case *SwitchNode:
+		switch segment {
+		case "subject":
+			return &n.Subject
+		case "default":
+			return &n.Default
+		}
+		if i, ok := sliceIndex(segment, "cases", len(n.Cases)); ok {
+			return &n.Cases[i]
+		}
+		if i, ok := sliceIndex(segment, "values", len(n.Values)); ok {
+			return &n.Values[i]
+		}
+	case *UnaryNode:
+		if segment == "operand" {
+			return &n.Operand
+		}
+	case *FunctionNode:
+		if i, ok := sliceIndex(segment, "args", len(n.Args)); ok {
+			return &n.Args[i]
+		}
+	}
+	return nil
+}
+
+func binaryField(left, right *ASTNode, segment string) *ASTNode {
+	switch segment {
+	case "left":
+		return left
+	case "right":
+		return right
+	}
+	return nil
+}
+
+// shallowCopy возвращает копию узла с теми же дочерними узлами; аргументы
+// функции и варианты SWITCH копируются в новые срезы
+func shallowCopy(node ASTNode) ASTNode {
+	switch n := node.(type) {
+	case *OperationNode:
+		c := *n
+		return &c
+	case *ComparisonNode:
+		c := *n
+		return &c
+	case *LogicalNode:
+		c := *n
+		return &c
+	case *ConditionalNode:
+		c := *n
+		return &c
+	case *SwitchNode:
+		c := *n
+		c.Cases = append([]ASTNode(nil), n.Cases...)
+		c.Values = append([]ASTNode(nil), n.Values...)
+		return &c
+	case *UnaryNode:
+		c := *n
+		return &c
+	case *FunctionNode:
+		c := *n
+		c.Args = append([]ASTNode(nil), n.Args...)
+		return &c
+	}
+	return node
+}
+
+// sliceIndex разбирает сегмент вида "args[2]" для среза с именем name
+func sliceIndex(segment, name string, count int) (int, bool) {
+	prefix := name + "["
+	if !strings.HasPrefix(segment, prefix) || !strings.HasSuffix(segment, "]") {
+		return 0, false
+	}
+	i, err := strconv.Atoi(segment[len(prefix) : len(segment)-1])
+	if err != nil || i < 0 || i >= count {
+		return 0, false
+	}
+	return i, true
+}
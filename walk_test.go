@@ -0,0 +1,100 @@
+package formula
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWalkOrder(t *testing.T) {
+	node := mustParse(t, "IF(a > 1, f(b, 2), -c)")
+	var visited []string
+	Walk(node, func(n ASTNode) bool {
+		visited = append(visited, string(n.GetType()))
+		return true
+	})
+	want := []string{"conditional", "comparison", "variable", "literal", "function", "variable", "literal", "unary", "variable"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("visited %v, want %v", visited, want)
+	}
+
+	count := 0
+	Walk(node, func(n ASTNode) bool {
+		count++
+		_, isFunction := n.(*FunctionNode)
+		return !isFunction
+	})
+	if count != 7 {
+		t.Errorf("visited %d nodes when skipping function arguments, want 7", count)
+	}
+}
+
+func TestReplaceAt(t *testing.T) {
+	tests := []struct {
+		formula string
+		path    []string
+		want    string
+	}{
+		{"a + b * c", []string{"right", "left"}, "a + x * c"},
+		{"IF(a > 1, 2, 3)", []string{"then"}, "IF a > 1 THEN x ELSE 3"},
+		{"IF(a > 1, 2, 3)", []string{"condition"}, "IF x THEN 2 ELSE 3"},
+		{"IF(a > 1, 2, 3)", []string{"else"}, "IF a > 1 THEN 2 ELSE x"},
+		{"IF(a > 1, 2)", []string{"else"}, "IF a > 1 THEN 2 ELSE x"},
+		{"IF(a > 1, 2, IF(b, 3, 4))", []string{"else", "then"}, "IF a > 1 THEN 2 ELSE (IF b THEN x ELSE 4)"},
+		{"max(a, b)", []string{"args[1]"}, "max(a, x)"},
+		{"-a", []string{"operand"}, "-x"},
+		{"SWITCH(a, 1, 2, 3, 4)", []string{"cases[1]"}, "SWITCH(a, 1, 2, x, 4)"},
+		{"SWITCH(a, 1, 2)", []string{"default"}, "SWITCH(a, 1, 2, x)"},
+		{"a", nil, "x"},
+	}
+	for _, tt := range tests {
+		root := mustParse(t, tt.formula)
+		before := root.(interface{ String() string }).String()
+		got, err := ReplaceAt(root, tt.path, &VariableNode{Name: "x"})
+		if err != nil {
+			t.Errorf("%s %v: %v", tt.formula, tt.path, err)
+			continue
+		}
+		if s := got.(interface{ String() string }).String(); s != tt.want {
+			t.Errorf("%s %v = %s, want %s", tt.formula, tt.path, s, tt.want)
+		}
+		if after := root.(interface{ String() string }).String(); after != before {
+			t.Errorf("%s: original tree changed to %s", tt.formula, after)
+		}
+	}
+}
+
+func TestReplaceAtInvalidPath(t *testing.T) {
+	tests := []struct {
+		formula string
+		path    []string
+	}{
+		{"IF(a > 1, 2)", []string{"else", "left"}},
+		{"a + b", []string{"operand"}},
+		{"max(a, b)", []string{"args[2]"}},
+		{"max(a, b)", []string{"args[-1]"}},
+		{"SWITCH(a, 1, 2)", []string{"values[1]"}},
+		{"a", []string{"left"}},
+	}
+	for _, tt := range tests {
+		if _, err := ReplaceAt(mustParse(t, tt.formula), tt.path, &LiteralNode{Value: 1}); err == nil {
+			t.Errorf("%s %v: expected error", tt.formula, tt.path)
+		}
+	}
+}
+
+func TestReplaceAtNilReplacement(t *testing.T) {
+	tests := []struct {
+		formula string
+		path    []string
+	}{
+		{"a + b", []string{"left"}},
+		{"IF(a > 1, 2, 3)", []string{"else"}},
+		{"max(a, b)", []string{"args[0]"}},
+		{"a", nil},
+	}
+	for _, tt := range tests {
+		if got, err := ReplaceAt(mustParse(t, tt.formula), tt.path, nil); err == nil {
+			t.Errorf("%s %v with nil replacement = %v, expected error", tt.formula, tt.path, got)
+		}
+	}
+}